@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// hashOptions select what is computed for a file, the coordinator sends its options to the agents with every file
+type hashOptions struct {
+	ExtraHashes []string `json:"extra_hashes,omitempty"`
+	Addnet      bool     `json:"addnet,omitempty"`
+	Metadata    bool     `json:"metadata,omitempty"`
+	Kohya       bool     `json:"kohya,omitempty"`
+	GGUF        bool     `json:"gguf,omitempty"`
+	ModelType   bool     `json:"model_type,omitempty"`
+}
+
+func optionsFor(path string) *hashOptions {
+	return &hashOptions{ExtraHashes: params.ExtraHashes, Addnet: wantAddnet(path), Metadata: wantMetadata(path),
+		Kohya: wantKohya(path), GGUF: wantGGUF(path), ModelType: wantModelType(path)}
+}
+
+// hasher returns the library hasher computing what the options select, the files are read by the worker
+func (o *hashOptions) hasher() *sdhasher.Hasher {
+	return &sdhasher.Hasher{ExtraHashes: o.ExtraHashes, Metadata: o.Metadata, Kohya: o.Kohya, GGUF: o.GGUF,
+		ModelType: o.ModelType, MTime: mtimeMode()}
+}
+
+// wanted returns the optional parts of the entry the options select
+func (o *hashOptions) wanted() sdhasher.Wanted {
+	return sdhasher.Wanted{Metadata: o.Metadata, Addnet: o.Addnet, ModelType: o.ModelType, GGUF: o.GGUF,
+		ExtraHashes: o.ExtraHashes, MTime: mtimeMode()}
+}
+
+type agentRequest struct {
+	Key     string      `json:"key"`
+	Size    int64       `json:"size"`
+	Options hashOptions `json:"options"`
+}
+
+// agentResponse carries the fields of the entry that aren't stored in its JSON form
+type agentResponse struct {
+	SHA256   string            `json:"sha256"`
+	Size     int64             `json:"size"`
+	Extra    map[string]string `json:"extra,omitempty"`
+	Addnet   string            `json:"addnet,omitempty"`
+	Metadata json.RawMessage   `json:"metadata,omitempty"`
+}
+
+// agentClient sends the files to an agent, they're found by the cache key in the models directories of the agent
+type agentClient struct {
+	url string
+}
+
+var agents []agentClient
+
+func setupAgents() {
+	for _, u := range params.Agents {
+		if !isURL(u) {
+			fatal("Invalid agent URL", "url", u)
+		}
+		agents = append(agents, agentClient{url: strings.TrimSuffix(u, "/")})
+	}
+}
+
+// hash asks the agent to hash the file of the task, the modification time of the local file is stored as the agent
+// may see another one
+func (a agentClient) hash(ctx context.Context, id int, t task) (*sdhasher.Entry, error) {
+	started := time.Now()
+	info, err := t.d.Info()
+	if err != nil {
+		slog.Error("Error getting file info", "path", t.path, "worker", id, "error", err)
+		return nil, err
+	}
+	slog.Info("Hashing", "path", t.path, "bytes", t.size, "worker", id, "agent", a.url)
+	body, err := json.Marshal(agentRequest{Key: t.key, Size: info.Size(), Options: *optionsFor(t.path)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/hash", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if params.AgentToken != "" {
+		req.Header.Set("Authorization", "Bearer "+params.AgentToken)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		slog.Error("Error sending file to agent", "path", t.path, "worker", id, "agent", a.url, "error", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err := fmt.Errorf("agent %s: %s: %s", a.url, resp.Status, strings.TrimSpace(string(msg)))
+		if resp.StatusCode == http.StatusNotFound {
+			err = fmt.Errorf("%w: %w", fs.ErrNotExist, err)
+		}
+		slog.Error("Error hashing file on agent", "path", t.path, "worker", id, "agent", a.url, "error", err)
+		return nil, err
+	}
+	var r agentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		slog.Error("Error reading agent response", "path", t.path, "worker", id, "agent", a.url, "error", err)
+		return nil, err
+	}
+	progress.read(int(r.Size))
+	result := &sdhasher.Entry{MTime: mtimeMode().FileMTime(info), SHA256: r.SHA256, Size: r.Size, Extra: r.Extra,
+		Addnet: r.Addnet, Metadata: r.Metadata, Path: t.path, Key: t.key}
+	slog.Info("Done", "path", t.path, "sha256", result.SHA256, "bytes", result.Size, "duration", time.Since(started),
+		"worker", id, "agent", a.url)
+	return result, nil
+}
+
+// agentServer hashes the files for the coordinators, at most -m files at a time
+type agentServer struct {
+	// ids are the free worker numbers
+	ids     chan int
+	retried atomic.Int64
+}
+
+func (s *agentServer) handleHash(w http.ResponseWriter, r *http.Request) {
+	if agentOptions.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")),
+		[]byte("Bearer "+agentOptions.Token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+	var req agentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path, fi, err := statKey(req.Key)
+	if path == "" {
+		http.Error(w, "the key is outside of the models directories", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if fi.Size() != req.Size {
+		http.Error(w, fmt.Sprintf("%s has %d bytes instead of %d", path, fi.Size(), req.Size), http.StatusConflict)
+		return
+	}
+	var id int
+	select {
+	case id = <-s.ids:
+	case <-r.Context().Done():
+		return
+	}
+	defer func() { s.ids <- id }()
+	t := newTask(path, req.Key, fs.FileInfoToDirEntry(fi))
+	t.opts = &req.Options
+	e, err := hashWithRetries(r.Context(), id, *t, worker, &s.retried)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, agentResponse{SHA256: e.SHA256, Size: e.Size, Extra: e.Extra, Addnet: e.Addnet, Metadata: e.Metadata})
+}
+
+// runAgent serves the hashing requests of the coordinators until the context is cancelled
+func runAgent(ctx context.Context) {
+	listRemotes(ctx)
+	s := &agentServer{ids: make(chan int, params.MaxHashers)}
+	for i := 0; i < params.MaxHashers; i++ {
+		s.ids <- i
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hash", s.handleHash)
+	mux.Handle("GET /metrics", &metrics)
+	srv := &http.Server{
+		Addr:        agentOptions.Listen,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	l, err := net.Listen("tcp", agentOptions.Listen)
+	if err != nil {
+		fatal("Error serving", "address", agentOptions.Listen, "error", err)
+	}
+	slog.Info("Waiting for files to hash", "address", agentOptions.Listen, "paths", baseDirs)
+	sdNotify("READY=1")
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		fatal("Error serving", "address", agentOptions.Listen, "error", err)
+	}
+}
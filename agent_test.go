@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAgent(t *testing.T) {
+	savedParams, savedRoots, savedOptions, savedClient := params, roots, agentOptions, httpClient
+	t.Cleanup(func() {
+		params, roots, agentOptions, httpClient = savedParams, savedRoots, savedOptions, savedClient
+		bufferPool = sync.Pool{}
+	})
+	dir := t.TempDir()
+	data := []byte("model data")
+	path := filepath.Join(dir, "model.safetensors")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	roots = []root{{path: dir, prefix: "checkpoint/", fsys: localStorage{dir: dir}}}
+	resetNames()
+	params.MaxHashers, params.BufferSize = 1, 4096
+	setupBuffers()
+	agentOptions.Token = "secret"
+	s := &agentServer{ids: make(chan int, 1)}
+	s.ids <- 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /hash", s.handleHash)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	httpClient = srv.Client()
+	post := func(auth string, req agentRequest) *http.Response {
+		t.Helper()
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := http.NewRequest(http.MethodPost, srv.URL+"/hash", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	model := agentRequest{Key: "checkpoint/model.safetensors", Size: int64(len(data))}
+	tests := []struct {
+		name string
+		auth string
+		req  agentRequest
+		want int
+	}{
+		{"no token", "", model, http.StatusUnauthorized},
+		{"wrong token", "Bearer wrong", model, http.StatusUnauthorized},
+		{"token without the scheme", "secret", model, http.StatusUnauthorized},
+		{"token prefix", "Bearer secre", model, http.StatusUnauthorized},
+		{"valid", "Bearer secret", model, http.StatusOK},
+		{"outside of the roots", "Bearer secret", agentRequest{Key: "lora/model"}, http.StatusNotFound},
+		{"missing file", "Bearer secret", agentRequest{Key: "checkpoint/missing.safetensors"}, http.StatusNotFound},
+		{"other size", "Bearer secret", agentRequest{Key: model.Key, Size: 1}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		if resp := post(tt.auth, tt.req); resp.StatusCode != tt.want {
+			t.Errorf("%s: got %s, want %d", tt.name, resp.Status, tt.want)
+		}
+	}
+	// the coordinator sends its token
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := newTask(path, model.Key, fs.FileInfoToDirEntry(fi))
+	a := agentClient{url: srv.URL}
+	if _, err := a.hash(context.Background(), 0, *task); err == nil {
+		t.Error("no error without the token")
+	}
+	params.AgentToken = "secret"
+	e, err := a.hash(context.Background(), 0, *task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.SHA256 != want || e.Size != int64(len(data)) || e.Key != model.Key {
+		t.Errorf("got %+v, want sha256 %s", e, want)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// auditRecord is the line appended to --audit-log for every run that changed the cache
+type auditRecord struct {
+	Time     time.Time         `json:"time"`
+	Added    map[string]string `json:"added,omitempty"`
+	Rehashed []sdhasher.Change `json:"rehashed,omitempty"`
+	Pruned   map[string]string `json:"pruned,omitempty"`
+}
+
+// writeAudit appends the keys added, rehashed and removed since the original cache to --audit-log, the rehashed files
+// are listed even if their hash is the same
+func writeAudit(original, result sdhasher.Cache) {
+	if params.AuditLog == "" {
+		return
+	}
+	d := sdhasher.Diff(original, result)
+	record := auditRecord{Time: time.Now(), Added: d.Added, Pruned: d.Removed}
+	changed := map[string]bool{}
+	for _, c := range d.Changed {
+		record.Rehashed = append(record.Rehashed, c)
+		changed[c.Key] = true
+	}
+	for _, e := range stats.hashed {
+		if o, ok := original.Hashes[e.Key]; ok && !changed[e.Key] {
+			record.Rehashed = append(record.Rehashed, sdhasher.Change{Key: e.Key, Old: o.SHA256, New: e.SHA256})
+			changed[e.Key] = true
+		}
+	}
+	if len(record.Added) == 0 && len(record.Rehashed) == 0 && len(record.Pruned) == 0 {
+		return
+	}
+	sort.Slice(record.Rehashed, func(i, j int) bool { return record.Rehashed[i].Key < record.Rehashed[j].Key })
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Error encoding audit record", "error", err)
+		return
+	}
+	f, err := os.OpenFile(params.AuditLog, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		slog.Error("Error opening audit log", "path", params.AuditLog, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		slog.Error("Error writing audit log", "path", params.AuditLog, "error", err)
+	}
+}
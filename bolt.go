@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltSectionKey keeps the sections that aren't objects as bbolt doesn't allow the empty keys, the cache keys never
+// start with a zero byte
+const boltSectionKey = "\x00"
+
+// boltStore keeps the cache in a bbolt database, the sections are the buckets of the JSON values of the entries, every
+// write is a transaction that survives a crash at any point
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (store, error) {
+	// another program writing the database holds its lock until it's closed
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("error locking %s, is it used by another program: %w", path, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	return boltStore{db: db}, nil
+}
+
+func (s boltStore) load() (storeRows, error) {
+	rows := storeRows{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			section := map[string]string{}
+			rows[string(name)] = section
+			return b.ForEach(func(k, v []byte) error {
+				// sdhasher doesn't write the nested buckets
+				if v != nil {
+					section[boltKey(string(k))] = string(v)
+				}
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", s.db.Path(), err)
+	}
+	return rows, nil
+}
+
+func (s boltStore) write(changes []storeChange) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, c := range changes {
+			if c.value == "" {
+				if b := tx.Bucket([]byte(c.section)); b != nil {
+					if err := b.Delete([]byte(boltKey(c.key))); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte(c.section))
+			if err != nil {
+				return fmt.Errorf("error creating bucket %s: %w", c.section, err)
+			}
+			if err := b.Put([]byte(boltKey(c.key)), []byte(c.value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) close() error {
+	return s.db.Close()
+}
+
+// boltKey swaps the empty key of the whole section and boltSectionKey
+func boltKey(key string) string {
+	switch key {
+	case "":
+		return boltSectionKey
+	case boltSectionKey:
+		return ""
+	}
+	return key
+}
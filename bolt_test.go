@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func openTestBolt(t *testing.T, path string) boltStore {
+	t.Helper()
+	s, err := openBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(boltStore)
+}
+
+// checkBolt runs the consistency check of bbolt on the database
+func checkBolt(t *testing.T, s boltStore) {
+	t.Helper()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	rnd := rand.New(rand.NewSource(1))
+	model := storeRows{}
+	s := openTestBolt(t, path)
+	for round := 0; round < 30; round++ {
+		changes := map[[2]string]string{}
+		for i := 0; i < 1+rnd.Intn(300); i++ {
+			section := []string{"hashes", "hashes-addnet", "safetensors-metadata"}[rnd.Intn(3)]
+			key := fmt.Sprintf("checkpoint/model-%04d.safetensors", rnd.Intn(2000))
+			value := ""
+			if rnd.Intn(4) > 0 {
+				// some values need the overflow pages
+				value = fmt.Sprintf(`{"sha256":"%x","pad":"%s"}`, rnd.Int63(), strings.Repeat("x", rnd.Intn(300)))
+				if rnd.Intn(50) == 0 {
+					value = fmt.Sprintf(`{"value":"%s"}`, strings.Repeat("y", 5000+rnd.Intn(10000)))
+				}
+			}
+			changes[[2]string{section, key}] = value
+		}
+		var list []storeChange
+		for k, v := range changes {
+			list = append(list, storeChange{k[0], k[1], v})
+			if v == "" {
+				delete(model[k[0]], k[1])
+				continue
+			}
+			if model[k[0]] == nil {
+				model[k[0]] = map[string]string{}
+			}
+			model[k[0]][k[1]] = v
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].section < list[j].section ||
+				list[i].section == list[j].section && list[i].key < list[j].key
+		})
+		if err := s.write(list); err != nil {
+			t.Fatal(err)
+		}
+		checkBolt(t, s)
+		if round%10 == 9 {
+			s.close()
+			s = openTestBolt(t, path)
+		}
+		got, err := s.load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, model) {
+			t.Fatalf("round %d: the loaded rows differ from the written ones", round)
+		}
+	}
+	s.close()
+}
+
+func TestBoltStoreCache(t *testing.T) {
+	value := "bolt:" + filepath.Join(t.TempDir(), "cache.db")
+	s, err := openStore(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := storeTestCacheValue(t)
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	c.Remove("lora/b")
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	// the database is used by the open store
+	if _, err := openStore(value); err == nil {
+		t.Error("no error opening the locked database")
+	}
+	s.close()
+	reopened, err := openStore(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.close()
+	checkStoreCache(t, reopened, c)
+}
+
+func TestBoltStoreInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"text":      []byte(strings.Repeat("not a database\n", 1000)),
+		"truncated": make([]byte, 100),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openBoltStore(path); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
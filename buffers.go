@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"unsafe"
+)
+
+const (
+	// bufferAlign is the alignment O_DIRECT requires for the buffer address and size
+	bufferAlign = 4096
+	// maxBufferMemory caps the memory used by the read buffers of all hashers
+	maxBufferMemory = 1 << 30
+)
+
+var bufferPool sync.Pool
+
+// setupBuffers checks the buffer size against the memory cap and rounds it up to the alignment
+func setupBuffers() {
+	size := int(params.BufferSize)
+	if size < bufferAlign {
+		size = bufferAlign
+	}
+	if size*params.MaxHashers*buffersPerHasher() > maxBufferMemory {
+		size = maxBufferMemory / params.MaxHashers / buffersPerHasher()
+		slog.Info("Buffer size reduced to fit the memory limit", "buffer_size", formatBytes(int64(size)),
+			"limit", formatBytes(maxBufferMemory))
+	}
+	size = (size + bufferAlign - 1) / bufferAlign * bufferAlign
+	bufferPool.New = func() any {
+		buf := make([]byte, size+bufferAlign)
+		if offset := int(uintptr(unsafe.Pointer(&buf[0])) & (bufferAlign - 1)); offset != 0 {
+			buf = buf[bufferAlign-offset:]
+		}
+		buf = buf[:size]
+		return &buf
+	}
+}
+
+// buffersPerHasher returns the number of read buffers used by each hasher, two when the reads are pipelined
+func buffersPerHasher() int {
+	if params.NoPipeline {
+		return 1
+	}
+	return 2
+}
+
+// getBuffer returns an aligned read buffer from the pool, it should be returned with putBuffer
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+func putBuffer(buf *[]byte) {
+	bufferPool.Put(buf)
+}
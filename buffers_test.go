@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+func TestSetupBuffers(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() {
+		params = savedParams
+		bufferPool = sync.Pool{}
+	})
+	tests := []struct {
+		name       string
+		size       byteSize
+		hashers    int
+		noPipeline bool
+		want       int
+	}{
+		{"aligned", 1 << 20, 4, false, 1 << 20},
+		{"rounded up", 1<<20 + 1, 4, false, 1<<20 + bufferAlign},
+		{"odd size", 10000, 1, false, 3 * bufferAlign},
+		{"minimum", 1, 1, false, bufferAlign},
+		{"zero", 0, 1, false, bufferAlign},
+		{"memory limit", 64 << 20, 16, false, maxBufferMemory / 32},
+		{"memory limit without pipeline", 64 << 20, 64, true, maxBufferMemory / 64},
+		// the reduced size is aligned too
+		{"unaligned memory limit", 64 << 20, 48, false,
+			(maxBufferMemory/96 + bufferAlign - 1) / bufferAlign * bufferAlign},
+	}
+	for _, tt := range tests {
+		params.BufferSize, params.MaxHashers, params.NoPipeline = tt.size, tt.hashers, tt.noPipeline
+		bufferPool = sync.Pool{}
+		setupBuffers()
+		// a few buffers so that the allocations land at different addresses
+		for i := 0; i < 3; i++ {
+			bufp := getBuffer()
+			buf := *bufp
+			if len(buf) != tt.want {
+				t.Errorf("%s: got size %d, want %d", tt.name, len(buf), tt.want)
+			}
+			if addr := uintptr(unsafe.Pointer(&buf[0])); addr%bufferAlign != 0 {
+				t.Errorf("%s: buffer address %#x isn't aligned", tt.name, addr)
+			}
+			if cap(buf) < tt.want {
+				t.Errorf("%s: got capacity %d, want at least %d", tt.name, cap(buf), tt.want)
+			}
+		}
+	}
+}
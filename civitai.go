@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+const civitaiInfoExt = ".civitai.info"
+
+// previewTypes are the names of the downloaded previews by the image type
+var previewTypes = map[string]string{
+	"image/png":  ".preview.png",
+	"image/jpeg": ".preview.jpg",
+	"image/webp": ".preview.webp",
+	"image/gif":  ".preview.gif",
+}
+
+// previewExts are the preview file names the webui recognizes, the downloaded ones go first
+var previewExts = []string{".preview.png", ".preview.jpg", ".preview.webp", ".preview.gif", ".preview.jpeg", ".png",
+	".jpg", ".jpeg", ".webp", ".gif"}
+
+type civitaiInfo struct {
+	Images []struct {
+		URL  string `json:"url"`
+		Type string `json:"type"`
+	} `json:"images"`
+}
+
+var lastCivitaiRequest time.Time
+
+// civitaiGet requests the URL waiting between requests so that Civitai doesn't throttle us and returns the body with
+// its content type, nil is returned if it's not found
+func civitaiGet(ctx context.Context, url string) ([]byte, string, error) {
+	if wait := params.CivitaiDelay - time.Since(lastCivitaiRequest); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+	defer func() {
+		lastCivitaiRequest = time.Now()
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	return data, resp.Header.Get("Content-Type"), err
+}
+
+// civitaiLookup returns the Civitai model version info for the hash, nil if the model isn't known
+func civitaiLookup(ctx context.Context, sha256 string) ([]byte, error) {
+	data, _, err := civitaiGet(ctx, strings.TrimSuffix(params.CivitaiURL, "/")+"/api/v1/model-versions/by-hash/"+sha256)
+	return data, err
+}
+
+// civitaiSkipped reports whether the model was looked up on Civitai and not found within --civitai-not-found-ttl
+func civitaiSkipped(e sdhasher.Entry) bool {
+	t, err := time.Parse(time.RFC3339, e.Extra[sdhasher.CivitaiNotFoundField])
+	return err == nil && time.Since(t) < params.CivitaiNotFoundTTL
+}
+
+// civitaiNotFound records the time of the lookup in the entry so that the model isn't looked up again until the TTL
+// passes, the entries are shared with the cache clones so the extra fields are copied
+func civitaiNotFound(c sdhasher.Cache, key string) {
+	e := c.Hashes[key]
+	extra := map[string]string{sdhasher.CivitaiNotFoundField: time.Now().UTC().Format(time.RFC3339)}
+	for k, v := range e.Extra {
+		if k != sdhasher.CivitaiNotFoundField {
+			extra[k] = v
+		}
+	}
+	e.Extra = extra
+	c.Hashes[key] = e
+}
+
+// sidecarPath returns the path of the file next to the model with the extension replaced
+func sidecarPath(modelPath, ext string) string {
+	return strings.TrimSuffix(modelPath, filepath.Ext(modelPath)) + ext
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+func hasPreview(modelPath string) bool {
+	for _, ext := range previewTypes {
+		if exists(sidecarPath(modelPath, ext)) {
+			return true
+		}
+	}
+	if params.SkipExisting {
+		for _, ext := range previewExts {
+			if exists(sidecarPath(modelPath, ext)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// previewExt returns the name of the downloaded preview by the content type of the image or the extension of its URL,
+// PNG is assumed if neither is known
+func previewExt(contentType, imageURL string) string {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if ext, ok := previewTypes[mediaType]; ok {
+			return ext
+		}
+	}
+	if u, err := url.Parse(imageURL); err == nil {
+		mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(path.Ext(u.Path))))
+		if ext, ok := previewTypes[mediaType]; ok {
+			return ext
+		}
+	}
+	return previewTypes["image/png"]
+}
+
+// civitaiActions saves the missing .civitai.info files and previews for the cached models, the models of the remote
+// roots are skipped, it returns the number of the entries marked as not found
+func civitaiActions(ctx context.Context, result sdhasher.Cache) int {
+	changes := 0
+	for k, e := range result.Hashes {
+		if ctx.Err() != nil {
+			break
+		}
+		modelPath, _, err := statKey(k)
+		if modelPath == "" || err != nil || rootFor(modelPath).remote != nil {
+			continue
+		}
+		infoPath := sidecarPath(modelPath, civitaiInfoExt)
+		needInfo := params.Civitai && !exists(infoPath)
+		needPreview := params.CivitaiPreview && !hasPreview(modelPath)
+		if !needInfo && !needPreview {
+			continue
+		}
+		var info []byte
+		if !needInfo {
+			info, err = os.ReadFile(infoPath)
+		}
+		if info == nil {
+			if civitaiSkipped(e) {
+				slog.Debug("Model not found on Civitai recently, skipping", "path", modelPath)
+				continue
+			}
+			info, err = civitaiLookup(ctx, e.SHA256)
+		}
+		if err != nil {
+			slog.Error("Error looking up model on Civitai", "path", modelPath, "error", err)
+			continue
+		}
+		if info == nil {
+			slog.Warn("Model not found on Civitai", "path", modelPath)
+			civitaiNotFound(result, k)
+			changes++
+			continue
+		}
+		if needInfo {
+			if err := writeCivitaiInfo(infoPath, info); err != nil {
+				slog.Error("Error writing Civitai info", "path", infoPath, "error", err)
+				continue
+			}
+			slog.Info("Saved Civitai info", "path", infoPath)
+		}
+		if needPreview {
+			if err := downloadPreview(ctx, modelPath, info); err != nil {
+				slog.Error("Error downloading preview", "path", modelPath, "error", err)
+			}
+		}
+	}
+	return changes
+}
+
+func writeCivitaiInfo(infoPath string, info []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, info, "", "    "); err != nil {
+		return err
+	}
+	return os.WriteFile(infoPath, buf.Bytes(), 0644)
+}
+
+func downloadPreview(ctx context.Context, modelPath string, info []byte) error {
+	var ci civitaiInfo
+	if err := json.Unmarshal(info, &ci); err != nil {
+		return err
+	}
+	imageURL := ""
+	for _, img := range ci.Images {
+		if img.Type == "" || img.Type == "image" {
+			imageURL = img.URL
+			break
+		}
+	}
+	if imageURL == "" {
+		slog.Warn("No preview images", "path", modelPath)
+		return nil
+	}
+	data, contentType, err := civitaiGet(ctx, imageURL)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return fmt.Errorf("image %s not found", imageURL)
+	}
+	previewPath := sidecarPath(modelPath, previewExt(contentType, imageURL))
+	if err := os.WriteFile(previewPath, data, 0644); err != nil {
+		return err
+	}
+	slog.Info("Saved preview", "path", previewPath, "bytes", len(data))
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorPurple = "\x1b[35m"
+	colorDim    = "\x1b[2m"
+)
+
+// logColors are the colors of the lines by the level or the beginning of the message and an attribute, the first
+// matching entry wins
+var logColors = []struct {
+	level, msg, attr string
+	color            string
+}{
+	{level: "ERROR", color: colorRed},
+	{level: "WARN", color: colorPurple},
+	// the up to date files are only logged at the debug level
+	{level: "DEBUG", color: colorDim},
+	{msg: "Done", attr: " rehash=true", color: colorYellow},
+	{msg: "Done", color: colorGreen},
+	{msg: "New file", color: colorGreen},
+	{msg: "File changed, rehashing", color: colorYellow},
+	{msg: "File size changed, rehashing", color: colorYellow},
+}
+
+// useColor returns true if the log lines should be colored
+func useColor() bool {
+	switch params.Color {
+	case "always":
+		return true
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && colorTerminal(os.Stderr)
+	}
+	return false
+}
+
+// colorWriter colors the log lines of the log package by their level and message
+type colorWriter struct {
+	io.Writer
+}
+
+func (w colorWriter) Write(p []byte) (int, error) {
+	color := lineColor(p)
+	if color == "" {
+		return w.Writer.Write(p)
+	}
+	line := bytes.TrimSuffix(p, []byte("\n"))
+	var b bytes.Buffer
+	b.WriteString(color)
+	b.Write(line)
+	b.WriteString(colorReset)
+	b.Write(p[len(line):])
+	if _, err := w.Writer.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// lineColor finds the level after the time of the line and returns the color of the message
+func lineColor(p []byte) string {
+	level, msg, first := "", []byte(nil), -1
+	for _, l := range []string{"DEBUG", "INFO", "WARN", "ERROR"} {
+		if i := bytes.Index(p, []byte(" "+l+" ")); i >= 0 && (first < 0 || i < first) {
+			level, msg, first = l, p[i+len(l)+2:], i
+		}
+	}
+	if level == "" {
+		return ""
+	}
+	for _, c := range logColors {
+		if c.level != "" && c.level == level ||
+			c.msg != "" && bytes.HasPrefix(msg, []byte(c.msg)) && bytes.Contains(msg, []byte(c.attr)) {
+			return c.color
+		}
+	}
+	return ""
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// the subcommands share the global options, running without a subcommand is the same as hash
+type (
+	hashCommand       struct{}
+	verifyCommand     struct{}
+	completionCommand struct{}
+	versionCommand    struct{}
+	validateCommand   struct{}
+	pruneCommand      struct{}
+	lookupCommand     struct{}
+	mergeCommand      struct {
+		Strategy string `long:"strategy" description:"How to resolve different entries for the same key" choice:"prefer-newer-mtime" choice:"prefer-first" choice:"fail-on-conflict" default:"prefer-newer-mtime" env:"SDHASHER_MERGE_STRATEGY"`
+	}
+	diffCommand struct {
+		JSON bool `long:"json" description:"Print the differences as JSON" env:"SDHASHER_DIFF_JSON"`
+	}
+	exportCommand struct {
+		Format string `long:"format" description:"Export format" choice:"csv" choice:"tsv" choice:"sha256sum" choice:"bsd" choice:"comfyui" choice:"invokeai" choice:"webui" default:"csv" env:"SDHASHER_EXPORT_FORMAT"`
+	}
+	dedupeCommand struct {
+		Reflink bool `long:"reflink" description:"Replace the duplicates with reflinked copies instead of hardlinks, Linux only" env:"SDHASHER_DEDUPE_REFLINK"`
+		Delete  bool `long:"delete" description:"Delete the duplicates and their cache entries instead of linking them" env:"SDHASHER_DEDUPE_DELETE"`
+		Yes     bool `short:"y" long:"yes" description:"Don't ask for confirmation before deleting" env:"SDHASHER_DEDUPE_YES"`
+	}
+	serveCommand struct {
+		Listen     string `long:"listen" description:"Address to listen on" default:"127.0.0.1:7862" env:"SDHASHER_SERVE_LISTEN"`
+		GRPCListen string `long:"grpc-listen" description:"Also serve the gRPC API of pkg/sdhasherpb/sdhasher.proto on this address" env:"SDHASHER_SERVE_GRPC_LISTEN"`
+	}
+	renameCommand struct {
+		Mapping string `long:"mapping" description:"JSON file the old and new keys and names of the renamed models are appended to" default:"renames.json" env:"SDHASHER_RENAME_MAPPING"`
+	}
+	serviceCommand struct {
+		Name string `long:"name" description:"Name of the Windows service" default:"sdhasher" env:"SDHASHER_SERVICE_NAME"`
+	}
+	agentCommand struct {
+		Listen string `long:"listen" description:"Address to listen on" default:"127.0.0.1:7863" env:"SDHASHER_AGENT_LISTEN"`
+		Token  string `long:"token" description:"Only accept the requests with this bearer token, pass it to the coordinator with --agent-token" env:"SDHASHER_AGENT_TOKEN"`
+	}
+)
+
+var (
+	mergeOptions   mergeCommand
+	diffOptions    diffCommand
+	serveOptions   serveCommand
+	exportOptions  exportCommand
+	dedupeOptions  dedupeCommand
+	agentOptions   agentCommand
+	serviceOptions serviceCommand
+	renameOptions  renameCommand
+)
+
+// serviceActions are the arguments of the service command, the run action is used by the service itself
+var serviceActions = []string{"install", "uninstall", "start", "stop"}
+
+func newParser() *flags.Parser {
+	parser := flags.NewParser(&params, flags.Default)
+	parser.SubcommandsOptional = true
+	parser.AddCommand("hash", "Hash the new and changed files (default)",
+		"Hash the new and changed files and remove the entries of the missing ones, same as running without a command",
+		&hashCommand{})
+	parser.AddCommand("verify", "Rehash the cached files and report mismatches",
+		"Rehash the files from the input cache (or only the keys matching the glob arguments) and report mismatches",
+		&verifyCommand{})
+	parser.AddCommand("prune", "Remove the entries of missing files",
+		"Remove the entries of the files that don't exist anymore without hashing anything", &pruneCommand{})
+	parser.AddCommand("lookup", "Look up the cached models on Civitai",
+		"Save the missing .civitai.info files of the cached models, also download the previews with --civitai-preview",
+		&lookupCommand{})
+	parser.AddCommand("rename", "Rename the models to their Civitai names",
+		"Hash the new and changed files, then rename the models found on Civitai to the sanitized model and version "+
+			"name together with their .civitai.info, preview and other sidecar files, the cache entries are moved to "+
+			"the new keys and the old and new names are appended to the mapping file to resolve the old infotext "+
+			"references, the .civitai.info files are used when they exist", &renameOptions)
+	parser.AddCommand("merge", "Merge cache files",
+		"Merge the cache files given as arguments into the output file", &mergeOptions)
+	parser.AddCommand("diff", "Compare two cache files",
+		"Report the entries added, removed and changed between the old and new cache files", &diffOptions)
+	parser.AddCommand("export", "Export the hashes",
+		"Export the cache entries to the file given as the argument or stdout, one row per file with the path, key, "+
+			"sha256, size and mtime, the paths are only filled if the models directories are given. The sha256sum and "+
+			"bsd formats write a SHA256SUMS manifest that can be checked with sha256sum -c or shasum -c. The comfyui "+
+			"format groups the hashes by the ComfyUI model folders. The invokeai format writes the SQL script updating "+
+			"the InvokeAI model records with the BLAKE3 hashes. The webui format writes the whole cache.json, such as "+
+			"the one kept in the --store database", &exportOptions)
+	parser.AddCommand("dedupe", "Replace the duplicate files with hardlinks",
+		"Hash the new and changed files, then replace the files with the same hash by hardlinks (or reflinks) to one "+
+			"of them so that every name stays but the data is stored once, or delete them with --delete",
+		&dedupeOptions)
+	parser.AddCommand("serve", "Serve the hashes over HTTP",
+		"Run the HTTP API: POST /scan rescans the models, GET /hash?path= or ?key= returns the entry, "+
+			"GET /paths?sha256= finds the entries by hash or its prefix, GET /cache returns the whole cache",
+		&serveOptions)
+	parser.AddCommand("agent", "Hash the files for another sdhasher",
+		"Hash the files sent by the sdhasher instances started with --agent pointing to this agent, the files are "+
+			"found by their cache keys in the models directories of the agent, so they should have the same layout as "+
+			"on the coordinator. The hashing options are sent by the coordinator, -m limits the number of files hashed "+
+			"at the same time", &agentOptions)
+	parser.AddCommand("service", "Manage the Windows service",
+		"Install, uninstall, start or stop the Windows service running sdhasher in watch mode, the argument is the "+
+			"action. The install action saves the options given on the command line, use the absolute paths, and the "+
+			"config file for the service, run it as administrator", &serviceOptions)
+	parser.AddCommand("validate", "Check the cache file for malformed entries",
+		"Report the entries of the input cache with a wrong shape, such as a missing or invalid sha256 or mtime, and "+
+			"write the cache with the repaired entries and without the broken ones to the output if it's given, -c "+
+			"rewrites the file in place. The exit code is 1 if there were problems and they weren't written",
+		&validateCommand{})
+	parser.AddCommand("version", "Print the version",
+		"Print the version, the git commit and the build date of sdhasher", &versionCommand{})
+	parser.AddCommand("completion", "Print the shell completion script",
+		"Print the completion script for the shell given as the argument: bash, zsh or fish, for example add "+
+			"eval \"$(sdhasher completion bash)\" to ~/.bashrc, source <(sdhasher completion zsh) to ~/.zshrc or save "+
+			"the fish script to ~/.config/fish/completions/sdhasher.fish", &completionCommand{})
+	return parser
+}
+
+// needsOutput returns true if the command writes the cache
+func needsOutput(command string) bool {
+	return command == "hash" && !params.Verify && !params.DryRun || command == "prune" || command == "serve" ||
+		command == "dedupe" || command == "rename"
+}
+
+// prune removes the entries of the files that don't exist anymore
+func prune(result *sdhasher.Cache) int {
+	stats = runStats{started: time.Now()}
+	events.scanStarted()
+	for k := range result.Hashes {
+		modelPath, _, err := statKey(k)
+		if modelPath == "" || !errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		slog.Info("File not found, removing cache entry", "path", modelPath, "key", k)
+		if !params.DryRun {
+			result.Remove(k)
+		}
+		stats.Pruned++
+	}
+	stats.finish()
+	return stats.Pruned
+}
+
+// mergeCaches merges the cache files and writes the result to the output
+func mergeCaches(paths []string) {
+	if len(paths) < 2 {
+		fatal("At least two cache files are required")
+	}
+	if params.Output == "" {
+		fatal("Output cache file is required")
+	}
+	var caches []sdhasher.Cache
+	for _, p := range paths {
+		c, err := readCache(p)
+		if err != nil {
+			fatal("Error reading cache", "path", p, "error", err)
+		}
+		caches = append(caches, c)
+	}
+	result, err := sdhasher.Merge(caches, sdhasher.MergeStrategy(mergeOptions.Strategy))
+	if err != nil {
+		fatal("Error merging caches", "error", err)
+	}
+	stampVersion(&result)
+	if err := writeCache(result); err != nil {
+		fatal("Error writing cache", "error", err)
+	}
+	slog.Info("Merged", "files", len(paths), "entries", len(result.Hashes))
+}
+
+// diffCaches prints the differences between two cache files
+func diffCaches(paths []string) {
+	if len(paths) != 2 {
+		fatal("The old and new cache files are required")
+	}
+	old, err := readCache(paths[0])
+	if err != nil {
+		fatal("Error reading cache", "path", paths[0], "error", err)
+	}
+	updated, err := readCache(paths[1])
+	if err != nil {
+		fatal("Error reading cache", "path", paths[1], "error", err)
+	}
+	d := sdhasher.Diff(old, updated)
+	if diffOptions.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "    ")
+		enc.Encode(d)
+		return
+	}
+	for _, k := range sortedKeys(d.Added) {
+		fmt.Printf("+ %s %s\n", k, d.Added[k])
+	}
+	for _, k := range sortedKeys(d.Removed) {
+		fmt.Printf("- %s %s\n", k, d.Removed[k])
+	}
+	for _, c := range d.Changed {
+		fmt.Printf("~ %s %s -> %s\n", c.Key, c.Old, c.New)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
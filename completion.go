@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// completionScripts call the program itself with GO_FLAGS_COMPLETION set, go-flags then prints the matching options,
+// choices and commands, so the completion always follows the current options, paths are completed by the shell when
+// nothing matches
+var completionScripts = map[string]string{
+	"bash": `_{{name}}() {
+    local IFS=$'\n'
+    COMPREPLY=($(GO_FLAGS_COMPLETION=1 "${COMP_WORDS[0]}" "${COMP_WORDS[@]:1:$COMP_CWORD}"))
+    return 0
+}
+complete -o default -F _{{name}} {{name}}
+`,
+	"zsh": `#compdef {{name}}
+_{{name}}() {
+    local -a items
+    items=(${(f)"$(GO_FLAGS_COMPLETION=1 ${words[1]} "${(@)words[2,$CURRENT]}")"})
+    if (( ${#items} )); then
+        compadd -- $items
+    else
+        _files
+    fi
+}
+compdef _{{name}} {{name}}
+`,
+	"fish": `function __{{name}}_complete
+    set -l args (commandline -opc) (commandline -ct)
+    set -l items (env GO_FLAGS_COMPLETION=1 $args[1] $args[2..-1])
+    if test (count $items) -eq 0
+        __fish_complete_path (commandline -ct)
+    else
+        printf '%s\n' $items
+    end
+end
+complete -c {{name}} -f -a '(__{{name}}_complete)'
+`,
+}
+
+// printCompletion prints the completion script for the shell
+func printCompletion(name string, args []string) {
+	if len(args) != 1 {
+		fatal("The shell is required", "shells", sortedKeys(completionScripts))
+	}
+	script, ok := completionScripts[args[0]]
+	if !ok {
+		fatal("Unsupported shell", "shell", args[0], "shells", sortedKeys(completionScripts))
+	}
+	fmt.Print(strings.ReplaceAll(script, "{{name}}", name))
+}
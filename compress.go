@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressionFor returns the compression of the cache written to the path, --compress or the one of the extension
+func compressionFor(path string) string {
+	if params.Compress != "" {
+		return params.Compress
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return "gzip"
+	case strings.HasSuffix(path, ".zst"):
+		return "zstd"
+	}
+	return "none"
+}
+
+// decompress returns the decompressed contents of the gzip or zstd compressed cache and the uncompressed cache as is
+func decompress(r io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return readCloser{zr, r.Close}, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		cmd := exec.Command("zstd", "-d", "-c", "-q")
+		cmd.Stdin = br
+		cmd.Stderr = os.Stderr
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("error running zstd to decompress the cache: %w", err)
+		}
+		return readCloser{out, func() error {
+			r.Close()
+			return cmd.Wait()
+		}}, nil
+	}
+	return readCloser{br, r.Close}, nil
+}
+
+// compressor returns the writer compressing to w, closing it flushes the compressed data but doesn't close w
+func compressor(w io.Writer, method string) (io.WriteCloser, error) {
+	switch method {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		cmd := exec.Command("zstd", "-c", "-q")
+		cmd.Stdout = w
+		cmd.Stderr = os.Stderr
+		in, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("error running zstd to compress the cache: %w", err)
+		}
+		return writeCloser{in, func() error {
+			if err := in.Close(); err != nil {
+				return err
+			}
+			return cmd.Wait()
+		}}, nil
+	}
+	return writeCloser{w, func() error { return nil }}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+type writeCloser struct {
+	io.Writer
+	close func() error
+}
+
+func (w writeCloser) Close() error {
+	return w.close()
+}
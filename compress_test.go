@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"hashes":{"checkpoint/model.safetensors":{"mtime":1,"sha256":"abc"}}}`), 100)
+	for _, method := range []string{"none", "gzip", "zstd"} {
+		if method == "zstd" {
+			if _, err := exec.LookPath("zstd"); err != nil {
+				t.Log("zstd isn't installed, skipping")
+				continue
+			}
+		}
+		var compressed bytes.Buffer
+		w, err := compressor(&compressed, method)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if method != "none" && (bytes.Equal(compressed.Bytes(), data) || compressed.Len() >= len(data)) {
+			t.Errorf("%s: the data isn't compressed", method)
+		}
+		r, err := decompress(io.NopCloser(&compressed))
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: got %q after the round trip", method, got)
+		}
+	}
+}
+
+func TestDecompressCorrupted(t *testing.T) {
+	r, err := decompress(io.NopCloser(bytes.NewReader([]byte{0x1f, 0x8b, 0, 0})))
+	if err == nil {
+		_, err = io.ReadAll(r)
+	}
+	if err == nil {
+		t.Error("no error for the corrupted gzip data")
+	}
+}
+
+func TestCompressionFor(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	tests := []struct {
+		path     string
+		compress string
+		want     string
+	}{
+		{"cache.json", "", "none"},
+		{"cache.json.gz", "", "gzip"},
+		{"cache.json.zst", "", "zstd"},
+		{"cache.json", "zstd", "zstd"},
+		{"cache.json.gz", "none", "none"},
+	}
+	for _, tt := range tests {
+		params.Compress = tt.compress
+		if got := compressionFor(tt.path); got != tt.want {
+			t.Errorf("compressionFor(%s) with --compress=%s = %s, want %s", tt.path, tt.compress, got, tt.want)
+		}
+	}
+}
+
+func TestCompressedCacheFile(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	params.Compress = ""
+	path := filepath.Join(t.TempDir(), "cache.json.gz")
+	var c sdhasher.Cache
+	c.Init()
+	c.Hashes["checkpoint/model.safetensors"] = sdhasher.Entry{MTime: 1, SHA256: "abc"}
+	if err := saveCache(path, c); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		t.Errorf("the cache isn't gzip compressed: %q", data)
+	}
+	got, err := readCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hashes["checkpoint/model.safetensors"].SHA256 != "abc" {
+		t.Errorf("got %v after the round trip", got.Hashes)
+	}
+}
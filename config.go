@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+)
+
+// configFile returns the config file given with --config or SDHASHER_CONFIG, or the default one if it exists, it's
+// found before parsing the command line so that the options given there override the file
+func configFile(args []string) string {
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		if value, ok := strings.CutPrefix(args[i], "--config="); ok {
+			return value
+		}
+		if args[i] == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	if path := os.Getenv("SDHASHER_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(dir, "sdhasher", "sdhasher.toml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// readConfig sets the options from the config file
+func readConfig(parser *flags.Parser) {
+	path := configFile(os.Args[1:])
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fatal("Error reading config file", "path", path, "error", err)
+	}
+	if err := applyConfig(parser, string(data)); err != nil {
+		fatal("Error reading config file", "path", path, "error", err)
+	}
+}
+
+// configValue is a key of the config file with its values as they're given on the command line
+type configValue struct {
+	table   string
+	key     string
+	options []string
+}
+
+// name returns the key as it's written in the config file
+func (v configValue) name() string {
+	if v.table == "" {
+		return v.key
+	}
+	return v.table + "." + v.key
+}
+
+// applyConfig sets the options from the TOML config, the top level keys are the global options and the tables are
+// named after the commands. The options with their environment variable set are skipped so that the environment
+// overrides the file, the command line parsed later overrides both.
+func applyConfig(parser *flags.Parser, data string) error {
+	values, err := parseTOML(data)
+	if err != nil {
+		return err
+	}
+	var ini strings.Builder
+	table := ""
+	for _, v := range values {
+		cmd := parser.Command
+		if v.table != "" {
+			if cmd = parser.Find(v.table); cmd == nil {
+				return fmt.Errorf("unknown command %s", v.table)
+			}
+		}
+		opt := cmd.FindOptionByLongName(v.key)
+		if opt == nil || opt.Field().Tag.Get("no-ini") != "" {
+			return fmt.Errorf("unknown option %s", v.name())
+		}
+		if key := opt.EnvKeyWithNamespace(); key != "" {
+			if _, ok := os.LookupEnv(key); ok {
+				continue
+			}
+		}
+		if v.table != table {
+			table = v.table
+			fmt.Fprintf(&ini, "[%s]\n", table)
+		}
+		for _, o := range v.options {
+			fmt.Fprintf(&ini, "%s = %s\n", v.key, strconv.Quote(o))
+		}
+	}
+	return flags.NewIniParser(parser).Parse(strings.NewReader(ini.String()))
+}
+
+// parseTOML reads the keys of the config in their order, the global options go first, the values are strings,
+// numbers, booleans and arrays of them
+func parseTOML(data string) ([]configValue, error) {
+	var doc map[string]any
+	md, err := toml.Decode(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	var result []configValue
+	for _, key := range md.Keys() {
+		v := configValue{key: key[0]}
+		value := doc[key[0]]
+		if table, ok := value.(map[string]any); ok {
+			// the table itself, its keys follow
+			if len(key) == 1 {
+				continue
+			}
+			v.table, v.key, value = key[0], key[1], table[key[1]]
+		}
+		if len(key) > 2 {
+			return nil, fmt.Errorf("unsupported key %s, the tables can't be nested", key)
+		}
+		if v.options, err = configOptions(value); err != nil {
+			return nil, fmt.Errorf("%s: %w", v.name(), err)
+		}
+		result = append(result, v)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].table == "" && result[j].table != ""
+	})
+	return result, nil
+}
+
+// configOptions returns the value as the option values, the arrays give the options that can be repeated
+func configOptions(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case []any:
+		var result []string
+		for _, e := range v {
+			if _, ok := e.([]any); ok {
+				return nil, fmt.Errorf("nested arrays aren't supported")
+			}
+			o, err := configOptions(e)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, o...)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("unsupported value %v, use a string, a number, a boolean or an array of them", value)
+}
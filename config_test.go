@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	values, err := parseTOML(`# the dotted keys set the options of the commands too
+serve.listen = "0.0.0.0:7862"
+path = ["/models/Stable-diffusion", 'C:\models\Lora=lora/']
+cache = "/webui/cache.json" # in place
+"max-hashers" = 4
+force = true
+min-size = 1_000
+
+[serve]
+exclude = [
+	"*.tmp", # partial downloads
+	"a\"b\u00e9",
+]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []configValue{
+		{"", "path", []string{"/models/Stable-diffusion", `C:\models\Lora=lora/`}},
+		{"", "cache", []string{"/webui/cache.json"}},
+		{"", "max-hashers", []string{"4"}},
+		{"", "force", []string{"true"}},
+		{"", "min-size", []string{"1000"}},
+		{"serve", "listen", []string{"0.0.0.0:7862"}},
+		{"serve", "exclude", []string{"*.tmp", "a\"bé"}},
+	}
+	if !reflect.DeepEqual(values, want) {
+		t.Errorf("got %v, want %v", values, want)
+	}
+	for _, bad := range []string{
+		"cache = /webui/cache.json",
+		"cache = \"unterminated",
+		"path = [\"a\"",
+		"path = [[\"a\"]]",
+		"cache = \"a\" \"b\"",
+		"cache = \"a\"\ncache = \"b\"",
+		"[serve\nlisten = \"a\"",
+		"[a.b]",
+		"[a.b.c]\nd = 1",
+		"date = 2024-01-01",
+		"cache",
+		"cache = \"\\q\"",
+	} {
+		if _, err := parseTOML(bad); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	config := `
+cache = "/file/cache.json"
+output = "/file/output.json"
+max-hashers = 2
+log-level = "debug"
+`
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want [4]any
+	}{
+		{"file", nil, nil, [4]any{"/file/cache.json", "/file/output.json", 2, "debug"}},
+		{"environment over file", map[string]string{"SDHASHER_CACHE": "/env/cache.json", "SDHASHER_MAX_HASHERS": "3"},
+			nil, [4]any{"/env/cache.json", "/file/output.json", 3, "debug"}},
+		{"command line over environment", map[string]string{"SDHASHER_CACHE": "/env/cache.json"},
+			[]string{"-c", "/cli/cache.json", "--log-level", "warn"},
+			[4]any{"/cli/cache.json", "/file/output.json", 2, "warn"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SDHASHER_CACHE", "SDHASHER_OUTPUT", "SDHASHER_MAX_HASHERS",
+				"SDHASHER_LOG_LEVEL"} {
+				t.Setenv(key, tt.env[key])
+				if tt.env[key] == "" {
+					os.Unsetenv(key)
+				}
+			}
+			params = savedParams
+			parser := newParser()
+			if err := applyConfig(parser, config); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := parser.ParseArgs(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			got := [4]any{params.Cache, params.Output, params.MaxHashers, params.LogLevel}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigUnknownOption(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	for _, config := range []string{"no-such-option = 1", "config = \"other.toml\"", "[no-such-command]\na = 1",
+		"[serve]\nno-such-option = 1"} {
+		if err := applyConfig(newParser(), config); err == nil {
+			t.Errorf("no error for %q", config)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// outputState tracks the output cache updated in place so that the changes made to it by the webui or another
+// sdhasher run while we were hashing aren't lost
+type outputState struct {
+	tracked bool
+	// base is the cache we wrote last or read at start, our changes are the difference between it and the result
+	base sdhasher.Cache
+	// written is what the output file contains after our last write
+	written sdhasher.Cache
+	modTime time.Time
+	size    int64
+	// external is set once the file was changed by someone else, all the later writes are merged
+	external bool
+}
+
+var output outputState
+
+// trackOutput starts watching for the foreign changes of the output file when the cache is updated in place
+func trackOutput(result sdhasher.Cache) {
+	if params.Input != params.Output || params.Output == "-" || isURL(params.Output) || isS3(params.Output) {
+		return
+	}
+	output = outputState{tracked: true, base: result.Clone(), written: result.Clone()}
+	output.stat()
+}
+
+func (o *outputState) stat() {
+	o.modTime, o.size = time.Time{}, -1
+	if fi, err := os.Stat(params.Output); err == nil {
+		o.modTime, o.size = fi.ModTime(), fi.Size()
+	}
+}
+
+// rebase merges the result on top of the current output file if it was changed since our last write
+func (o *outputState) rebase(result sdhasher.Cache) sdhasher.Cache {
+	if !o.tracked {
+		return result
+	}
+	theirs := o.written
+	modTime, size := o.modTime, o.size
+	if o.stat(); !o.modTime.Equal(modTime) || o.size != size {
+		c, err := readCache(params.Output)
+		if err != nil {
+			slog.Error("Error reading changed cache, overwriting it", "path", params.Output, "error", err)
+			return result
+		}
+		c.Init()
+		normalizeKeys(&c)
+		slog.Info("Cache was changed by another program, merging", "path", params.Output, "policy", params.OnConflict)
+		theirs = c
+		o.external = true
+	}
+	if !o.external {
+		return result
+	}
+	merged := sdhasher.Rebase(o.base, result, theirs, sdhasher.ConflictPolicy(params.OnConflict))
+	stampVersion(&merged)
+	return merged
+}
+
+// saved remembers the result and the merged cache written for it
+func (o *outputState) saved(result, merged sdhasher.Cache) {
+	if !o.tracked {
+		return
+	}
+	o.base = result.Clone()
+	o.written = merged
+	o.stat()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestWriteCacheMergesExternalChanges(t *testing.T) {
+	savedParams, savedOutput := params, output
+	t.Cleanup(func() { params, output = savedParams, savedOutput })
+	path := filepath.Join(t.TempDir(), "cache.json")
+	params.Input, params.Output, params.OnConflict, params.Backups = path, path, "newer", 0
+	entries := func(c sdhasher.Cache, keys ...string) sdhasher.Cache {
+		c = c.Clone()
+		c.Init()
+		for _, k := range keys {
+			c.Hashes[k] = sdhasher.Entry{MTime: 1, SHA256: k}
+		}
+		return c
+	}
+	check := func(step string, want ...string) {
+		t.Helper()
+		c, err := readCache(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c.Hashes) != len(want) {
+			t.Errorf("%s: got %d entries, want %v: %v", step, len(c.Hashes), want, c.Hashes)
+		}
+		for _, k := range want {
+			if _, ok := c.Hashes[k]; !ok {
+				t.Errorf("%s: no entry for %s", step, k)
+			}
+		}
+	}
+	result := entries(sdhasher.Cache{}, "base")
+	if err := saveCache(path, result); err != nil {
+		t.Fatal(err)
+	}
+	trackOutput(result)
+	result = entries(result, "ours")
+	if err := writeCache(result); err != nil {
+		t.Fatal(err)
+	}
+	check("unchanged file", "base", "ours")
+	// the webui adds its entry and removes the base one, the mtime is moved so that the change is seen
+	theirs := entries(sdhasher.Cache{}, "ours", "theirs")
+	if err := saveCache(path, theirs); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	result = entries(result, "ours2")
+	if err := writeCache(result); err != nil {
+		t.Fatal(err)
+	}
+	check("changed file", "ours", "ours2", "theirs")
+	// the later writes are merged too even if the file isn't changed again
+	result = entries(result, "ours3")
+	if err := writeCache(result); err != nil {
+		t.Fatal(err)
+	}
+	check("write after the merge", "ours", "ours2", "ours3", "theirs")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardPage []byte
+
+const (
+	// dashboardSamples of the throughput are taken every dashboardInterval
+	dashboardSamples  = 120
+	dashboardInterval = 5 * time.Second
+	dashboardChanges  = 50
+)
+
+// dashboardChange is a file hashed by one of the recent scans
+type dashboardChange struct {
+	Time   time.Time `json:"time"`
+	Key    string    `json:"key"`
+	SHA256 string    `json:"sha256"`
+}
+
+// dashboardState is the scan status shown by the web dashboard
+type dashboardState struct {
+	scanning atomic.Bool
+	sync.Mutex
+	lastScan   *runStats
+	lastTime   time.Time
+	throughput []float64
+	changes    []dashboardChange
+	sampling   sync.Once
+}
+
+var dashboard dashboardState
+
+type dashboardStatus struct {
+	Scanning   bool              `json:"scanning"`
+	Progress   *progressStatus   `json:"progress,omitempty"`
+	LastScan   *runStats         `json:"last_scan,omitempty"`
+	LastTime   *time.Time        `json:"last_time,omitempty"`
+	Throughput []float64         `json:"throughput"`
+	Interval   float64           `json:"interval"`
+	Changes    []dashboardChange `json:"changes"`
+	Errors     []string          `json:"errors"`
+	Rescan     bool              `json:"rescan"`
+}
+
+type progressStatus struct {
+	Files      int64 `json:"files"`
+	TotalFiles int64 `json:"total_files"`
+	Bytes      int64 `json:"bytes"`
+	TotalBytes int64 `json:"total_bytes"`
+}
+
+// scanFinished records the summary and the hashed files of the scan
+func (d *dashboardState) scanFinished(s runStats) {
+	d.Lock()
+	defer d.Unlock()
+	d.lastScan = &s
+	d.lastTime = time.Now()
+	for _, e := range s.hashed {
+		d.changes = append(d.changes, dashboardChange{Time: d.lastTime, Key: e.Key, SHA256: e.SHA256})
+	}
+	if len(d.changes) > dashboardChanges {
+		d.changes = d.changes[len(d.changes)-dashboardChanges:]
+	}
+}
+
+// sample records the read throughput every dashboardInterval
+func (d *dashboardState) sample() {
+	last := metrics.bytesRead.Load()
+	for range time.Tick(dashboardInterval) {
+		read := metrics.bytesRead.Load()
+		d.Lock()
+		d.throughput = append(d.throughput, float64(read-last)/dashboardInterval.Seconds())
+		if len(d.throughput) > dashboardSamples {
+			d.throughput = d.throughput[1:]
+		}
+		d.Unlock()
+		last = read
+	}
+}
+
+// register adds the dashboard page and its status to the mux, the rescan button is shown when the mux has the scan
+// handler
+func (d *dashboardState) register(mux *http.ServeMux, rescan bool) {
+	d.sampling.Do(func() { go d.sample() })
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(dashboardPage)
+	})
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, d.status(rescan))
+	})
+}
+
+func (d *dashboardState) status(rescan bool) dashboardStatus {
+	d.Lock()
+	defer d.Unlock()
+	result := dashboardStatus{Scanning: d.scanning.Load(), LastScan: d.lastScan,
+		Throughput: append([]float64{}, d.throughput...), Interval: dashboardInterval.Seconds(),
+		Changes: append([]dashboardChange{}, d.changes...), Errors: recentErrors.get(), Rescan: rescan}
+	if !d.lastTime.IsZero() {
+		result.LastTime = &d.lastTime
+	}
+	if result.Scanning {
+		result.Progress = progress.status()
+	}
+	return result
+}
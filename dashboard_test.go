@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestDashboardStatusDuringScan(t *testing.T) {
+	savedParams, savedRoots, savedDirs, savedExtensions := params, roots, baseDirs, extensions
+	t.Cleanup(func() {
+		params, roots, baseDirs, extensions = savedParams, savedRoots, savedDirs, savedExtensions
+		bufferPool = sync.Pool{}
+	})
+	dir := t.TempDir()
+	data := make([]byte, 1<<20)
+	for i := 0; i < 20; i++ {
+		if err := os.WriteFile(filepath.Join(dir, strconv.Itoa(i)+".safetensors"), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// every scan rehashes the files so that the totals are reset while the status is polled
+	params.MaxHashers, params.BufferSize, params.Force = 2, 64<<10, true
+	setupBuffers()
+	roots = []root{{path: dir, fsys: localStorage{dir: dir}}}
+	baseDirs = []string{dir}
+	extensions = map[string]struct{}{".safetensors": {}}
+	resetNames()
+	mux := http.NewServeMux()
+	dashboard.register(mux, false)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	done := make(chan struct{})
+	var result sdhasher.Cache
+	result.Init()
+	go func() {
+		defer close(done)
+		for i := 0; i < 3; i++ {
+			scan(context.Background(), &result)
+		}
+	}()
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		resp, err := http.Get(srv.URL + "/status")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var status dashboardStatus
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := status.Progress; p != nil && (p.Files > p.TotalFiles || p.Bytes > p.TotalBytes) {
+			t.Errorf("progress past the total: %+v", *p)
+		}
+	}
+	if len(result.Hashes) != 20 {
+		t.Errorf("got %d entries, want 20", len(result.Hashes))
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// dedupTasks keeps one task for every file reachable by several paths (hardlinks and with --symlinks=dedup also
+// symlinks), the rest are returned by the path of the kept task to be filled in from its result
+func dedupTasks(tasks []*task) ([]*task, map[string][]*task) {
+	type file struct {
+		fi os.FileInfo
+		t  *task
+	}
+	stat := os.Lstat
+	if params.Symlinks == "dedup" {
+		stat = os.Stat
+	}
+	var unique []*task
+	// os.SameFile compares the device and inode (or the file index on Windows), the files are grouped by size to
+	// make the comparisons cheap
+	bySize := map[int64][]file{}
+	duplicates := map[string][]*task{}
+tasks:
+	for _, t := range tasks {
+		fi, err := stat(t.path)
+		if err != nil {
+			unique = append(unique, t)
+			continue
+		}
+		for _, f := range bySize[fi.Size()] {
+			if os.SameFile(f.fi, fi) {
+				duplicates[f.t.path] = append(duplicates[f.t.path], t)
+				continue tasks
+			}
+		}
+		bySize[fi.Size()] = append(bySize[fi.Size()], file{fi, t})
+		unique = append(unique, t)
+	}
+	return unique, duplicates
+}
+
+// copyDuplicates adds the entries of the deduplicated paths with the hashes of the files they point to
+func copyDuplicates(hashed []*sdhasher.Entry, duplicates map[string][]*task) []*sdhasher.Entry {
+	for _, e := range hashed {
+		for _, t := range duplicates[e.Path] {
+			c := *e
+			c.Path = t.path
+			c.Key = t.key
+			hashed = append(hashed, &c)
+		}
+	}
+	return hashed
+}
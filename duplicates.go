@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// duplicateFile is a cached file that has the same content as other files
+type duplicateFile struct {
+	key  string
+	path string
+	fi   os.FileInfo
+}
+
+// duplicateGroup is the files with the same hash, copies are the files grouped by the data they point to so that
+// hardlinks and symlinks don't count as wasted space
+type duplicateGroup struct {
+	sha256 string
+	size   int64
+	wasted int64
+	files  []duplicateFile
+	copies [][]duplicateFile
+}
+
+// findDuplicates groups the existing cached files by hash, the groups with the most wasted space come first, the files
+// in the remote roots can't be linked or deleted so they're skipped
+func findDuplicates(c sdhasher.Cache) []duplicateGroup {
+	byHash := map[string][]duplicateFile{}
+	for _, k := range sortedKeys(c.Hashes) {
+		modelPath, fi, err := statKey(k)
+		if modelPath == "" || err != nil || rootFor(modelPath).remote != nil {
+			continue
+		}
+		sha := strings.ToLower(c.Hashes[k].SHA256)
+		byHash[sha] = append(byHash[sha], duplicateFile{key: k, path: modelPath, fi: fi})
+	}
+	var result []duplicateGroup
+	for sha, files := range byHash {
+		if len(files) < 2 {
+			continue
+		}
+		g := duplicateGroup{sha256: sha, size: files[0].fi.Size(), files: files, copies: sameFiles(files)}
+		g.wasted = g.size * int64(len(g.copies)-1)
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].wasted != result[j].wasted {
+			return result[i].wasted > result[j].wasted
+		}
+		return result[i].sha256 < result[j].sha256
+	})
+	return result
+}
+
+// sameFiles groups the files that are hardlinks of or symlinks to each other
+func sameFiles(files []duplicateFile) [][]duplicateFile {
+	var result [][]duplicateFile
+files:
+	for _, f := range files {
+		for i, c := range result {
+			if os.SameFile(f.fi, c[0].fi) {
+				result[i] = append(c, f)
+				continue files
+			}
+		}
+		result = append(result, []duplicateFile{f})
+	}
+	return result
+}
+
+// reportDuplicates writes the duplicate groups to the --duplicates file
+func reportDuplicates(c sdhasher.Cache) {
+	out := io.Writer(os.Stdout)
+	if params.Duplicates != "-" {
+		f, err := os.Create(params.Duplicates)
+		if err != nil {
+			slog.Error("Error writing duplicates report", "path", params.Duplicates, "error", err)
+			return
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	var total int64
+	groups := findDuplicates(c)
+	for _, g := range groups {
+		fmt.Fprintf(w, "%s %d files, %s each, %s wasted\n", g.sha256, len(g.files), formatBytes(g.size),
+			formatBytes(g.wasted))
+		for _, f := range g.files {
+			fmt.Fprintf(w, "    %s\n", f.path)
+		}
+		total += g.wasted
+	}
+	fmt.Fprintf(w, "%d groups of duplicates, %s wasted in total\n", len(groups), formatBytes(total))
+	if err := w.Flush(); err != nil {
+		slog.Error("Error writing duplicates report", "path", params.Duplicates, "error", err)
+	}
+}
+
+// dedupe replaces the duplicates with links to the first file of their group or deletes them
+func dedupe(result *sdhasher.Cache) {
+	groups := findDuplicates(*result)
+	var wasted int64
+	for _, g := range groups {
+		wasted += g.wasted
+	}
+	if wasted == 0 {
+		slog.Info("No duplicates found")
+		return
+	}
+	if dedupeOptions.Delete && !params.DryRun && !dedupeOptions.Yes &&
+		!confirm(fmt.Sprintf("Delete the duplicates taking %s?", formatBytes(wasted))) {
+		return
+	}
+	var freed int64
+	for _, g := range groups {
+		kept := g.copies[0][0]
+		for _, c := range g.copies[1:] {
+			var links []duplicateFile
+			replaced, files := 0, 0
+			for _, f := range c {
+				if !dedupeOptions.Delete && (isSymlink(f.path) || isSame(f.path, kept.fi)) {
+					links = append(links, f)
+					continue
+				}
+				files++
+				if dedupeFile(result, f, kept) {
+					replaced++
+				}
+			}
+			// the symlinks to the linked files and the paths already replaced through the linked directories now have
+			// the mtime of the kept file
+			for _, f := range links {
+				if !params.DryRun && isSame(f.path, kept.fi) {
+					copyEntry(result, kept.key, f.key)
+				}
+			}
+			// the data is only freed when all the hardlinks are replaced
+			if replaced == files {
+				freed += g.size
+			}
+		}
+	}
+	slog.Info("Deduplicated", "freed", formatBytes(freed), "dry_run", params.DryRun)
+}
+
+// dedupeFile replaces or deletes the duplicate file, it returns true on success
+func dedupeFile(result *sdhasher.Cache, f, kept duplicateFile) bool {
+	action := "Linking"
+	switch {
+	case dedupeOptions.Delete:
+		action = "Deleting"
+	case dedupeOptions.Reflink:
+		action = "Reflinking"
+	}
+	slog.Info(action+" duplicate", "path", f.path, "original", kept.path, "dry_run", params.DryRun)
+	if params.DryRun {
+		return true
+	}
+	var err error
+	switch {
+	case dedupeOptions.Delete:
+		// the file can be already deleted through another path
+		if err = os.Remove(f.path); err == nil || errors.Is(err, fs.ErrNotExist) {
+			err = nil
+			result.Remove(f.key)
+		}
+	case dedupeOptions.Reflink:
+		if err = replaceFile(f.path, func(tmp string) error { return reflinkFile(tmp, kept.path, f.fi) }); err == nil {
+			copyEntry(result, kept.key, f.key)
+		}
+	default:
+		if err = replaceFile(f.path, func(tmp string) error { return os.Link(kept.path, tmp) }); err == nil {
+			copyEntry(result, kept.key, f.key)
+		}
+	}
+	if err != nil {
+		slog.Error("Error deduplicating file", "path", f.path, "error", err)
+		return false
+	}
+	return true
+}
+
+// isSymlink reports whether the path is a symlink
+func isSymlink(path string) bool {
+	fi, err := os.Lstat(path)
+	return err == nil && fi.Mode()&fs.ModeSymlink != 0
+}
+
+// isSame reports whether the path points to the file
+func isSame(path string, file os.FileInfo) bool {
+	fi, err := os.Stat(path)
+	return err == nil && os.SameFile(fi, file)
+}
+
+// confirm asks the question on the terminal and returns true if the answer is yes
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// replaceFile creates the replacement with create next to the file and renames it over the file
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".sdhasher-tmp")
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// reflinkFile creates a reflinked copy of src with the mode of the file it replaces and the mtime of src so the entry
+// copied from src stays valid
+func reflinkFile(dst, src string, fi os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	srcInfo, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if err := cloneFile(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+}
+
+// copyEntry replaces the entries of the key with the ones of the other key after the files were linked
+func copyEntry(c *sdhasher.Cache, from, to string) {
+	c.Hashes[to] = c.Hashes[from]
+	if e, ok := c.HashesAddnet[from]; ok {
+		c.HashesAddnet[to] = e
+	}
+	if e, ok := c.SafetensorsMetadata[from]; ok {
+		c.SafetensorsMetadata[to] = e
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// fakeRemote is a listed remote directory that can't be opened
+type fakeRemote []remoteFile
+
+func (r fakeRemote) list(ctx context.Context) ([]remoteFile, error) { return r, nil }
+
+func (r fakeRemote) open(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestDedupeDeleteSkipsRemote(t *testing.T) {
+	savedParams, savedRoots, savedOptions := params, roots, dedupeOptions
+	t.Cleanup(func() { params, roots, dedupeOptions = savedParams, savedRoots, savedOptions })
+	dir := t.TempDir()
+	mtime := time.Unix(1700000000, 0)
+	for _, name := range []string{"a.safetensors", "b.safetensors"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("model"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote := fakeRemote{{name: "c.safetensors", size: 5, mtime: mtime}}
+	roots = []root{
+		{path: dir, prefix: "local/", fsys: localStorage{dir: dir}},
+		{path: "ssh://host/models", prefix: "remote/", remote: remote, fsys: newListedStorage(remote, remote)},
+	}
+	resetNames()
+	params.DryRun = false
+	dedupeOptions = dedupeCommand{Delete: true, Yes: true}
+	var result sdhasher.Cache
+	result.Init()
+	for _, k := range []string{"local/a.safetensors", "local/b.safetensors", "remote/c.safetensors"} {
+		result.Hashes[k] = sdhasher.Entry{MTime: sdhasher.MTime(mtime.Unix()), SHA256: "SAME", Size: 5}
+	}
+	dedupe(&result)
+	if _, err := os.Stat(filepath.Join(dir, "a.safetensors")); err != nil {
+		t.Errorf("kept file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.safetensors")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("local duplicate isn't deleted: %v", err)
+	}
+	for k, want := range map[string]bool{
+		"local/a.safetensors":  true,
+		"local/b.safetensors":  false,
+		"remote/c.safetensors": true,
+	} {
+		if _, ok := result.Hashes[k]; ok != want {
+			t.Errorf("entry %s present = %v, want %v", k, ok, want)
+		}
+	}
+}
+
+func TestStdoutConflict(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	for _, tc := range []struct {
+		name                                string
+		output, summary, stream, duplicates string
+		watch                               bool
+		want                                bool
+	}{
+		{name: "cache", output: "-"},
+		{name: "duplicates", output: "-", duplicates: "-", want: true},
+		{name: "duplicates to file", output: "-", duplicates: "dups.txt"},
+		{name: "duplicates only", output: "cache.json", duplicates: "-"},
+		{name: "summary", output: "-", summary: "-", want: true},
+		{name: "stream", output: "-", stream: "-", want: true},
+		{name: "watch", output: "-", watch: true, want: true},
+	} {
+		params.Output, params.SummaryJSON, params.Stream, params.Duplicates = tc.output, tc.summary, tc.stream,
+			tc.duplicates
+		params.Watch = tc.watch
+		if got := stdoutConflict(); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// progressEvent is the line written to --events, the fields depend on the event
+type progressEvent struct {
+	Event    string          `json:"event"`
+	Time     time.Time       `json:"time"`
+	Paths    []string        `json:"paths,omitempty"`
+	Key      string          `json:"key,omitempty"`
+	Path     string          `json:"path,omitempty"`
+	Size     int64           `json:"size,omitempty"`
+	Rehash   bool            `json:"rehash,omitempty"`
+	SHA256   string          `json:"sha256,omitempty"`
+	Duration float64         `json:"duration,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Progress *progressStatus `json:"progress,omitempty"`
+	Summary  *runStats       `json:"summary,omitempty"`
+	Expected string          `json:"expected,omitempty"`
+	Verified *verifyStats    `json:"verified,omitempty"`
+}
+
+// verifyStats is the summary of a verification
+type verifyStats struct {
+	Files  int `json:"files"`
+	Failed int `json:"failed"`
+}
+
+// eventStream writes the progress events for the front-ends and passes them to the gRPC clients following the run
+type eventStream struct {
+	sync.Mutex
+	enc       *json.Encoder
+	out       io.Closer
+	listeners map[chan progressEvent]struct{}
+}
+
+var events eventStream
+
+func setupEvents() {
+	switch {
+	case params.Events == "":
+		return
+	case params.Events == "-":
+		events.enc = json.NewEncoder(os.Stderr)
+		return
+	case strings.HasPrefix(params.Events, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(params.Events, "unix:"))
+		if err != nil {
+			fatal("Error connecting to event socket", "path", params.Events, "error", err)
+		}
+		events.enc, events.out = json.NewEncoder(conn), conn
+		return
+	}
+	f, err := os.OpenFile(params.Events, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fatal("Error opening event stream", "path", params.Events, "error", err)
+	}
+	events.enc, events.out = json.NewEncoder(f), f
+}
+
+// send writes the event, the stream is closed after an error such as the front-end going away
+func (s *eventStream) send(e progressEvent) {
+	s.Lock()
+	defer s.Unlock()
+	if s.enc == nil && len(s.listeners) == 0 {
+		return
+	}
+	e.Time = time.Now()
+	for l := range s.listeners {
+		// a slow client misses the events instead of stalling the workers
+		select {
+		case l <- e:
+		default:
+		}
+	}
+	if s.enc == nil {
+		return
+	}
+	if err := s.enc.Encode(e); err != nil {
+		slog.Warn("Error writing event stream, not sending more events", "path", params.Events, "error", err)
+		s.enc = nil
+		if s.out != nil {
+			s.out.Close()
+		}
+	}
+}
+
+// subscribe returns the channel receiving the events until unsubscribe is called
+func (s *eventStream) subscribe() chan progressEvent {
+	s.Lock()
+	defer s.Unlock()
+	if s.listeners == nil {
+		s.listeners = map[chan progressEvent]struct{}{}
+	}
+	l := make(chan progressEvent, 1024)
+	s.listeners[l] = struct{}{}
+	return l
+}
+
+func (s *eventStream) unsubscribe(l chan progressEvent) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.listeners, l)
+}
+
+func (s *eventStream) scanStarted() {
+	s.send(progressEvent{Event: "scan_started", Paths: baseDirs})
+}
+
+func (s *eventStream) queued(tasks []*task) {
+	for _, t := range tasks {
+		s.send(progressEvent{Event: "file_queued", Key: t.key, Path: t.path, Size: t.size, Rehash: t.rehash})
+	}
+}
+
+// fileDone sends the result of the task with the progress of the run, the files that couldn't be hashed have the error
+// set
+func (s *eventStream) fileDone(t *task, e *sdhasher.Entry, d time.Duration, err error) {
+	event := progressEvent{Event: "file_done", Key: t.key, Path: t.path, Size: t.size, Rehash: t.rehash,
+		Duration: d.Seconds(), Progress: progress.status()}
+	if err != nil {
+		event.Error = err.Error()
+	} else {
+		event.SHA256 = e.SHA256
+		event.Size = e.Size
+	}
+	s.send(event)
+}
+
+func (s *eventStream) runFinished(stats runStats) {
+	s.send(progressEvent{Event: "run_finished", Summary: &stats})
+}
+
+// fileFailed reports the file that is missing or doesn't match the expected hash during the verification
+func (s *eventStream) fileFailed(key, path, sha256, expected string, err error) {
+	event := progressEvent{Event: "file_failed", Key: key, Path: path, SHA256: sha256, Expected: expected}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	s.send(event)
+}
+
+func (s *eventStream) verifyFinished(v verifyStats) {
+	s.send(progressEvent{Event: "verify_finished", Verified: &v})
+}
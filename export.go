@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// exportCache writes the cache entries in the export format to the file or stdout, the paths are only known if the
+// models directories are given
+func exportCache(result sdhasher.Cache, args []string) error {
+	if exportOptions.Format == "webui" {
+		// the cache file replaced atomically can be exported over the one the webui is using
+		path := "-"
+		if len(args) > 0 {
+			path = args[0]
+		}
+		return saveCache(path, result)
+	}
+	var w io.Writer = os.Stdout
+	if len(args) > 0 && args[0] != "-" {
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	keys := sortedKeys(result.Hashes)
+	switch exportOptions.Format {
+	case "sha256sum", "bsd":
+		return writeChecksums(result, keys, w, args)
+	case "comfyui":
+		return writeComfyUI(result, w)
+	case "invokeai":
+		return writeInvokeAI(result, keys, w)
+	}
+	cw := csv.NewWriter(w)
+	if exportOptions.Format == "tsv" {
+		cw.Comma = '\t'
+	}
+	cw.Write([]string{"path", "key", "sha256", "size", "mtime"})
+	for _, k := range keys {
+		e := result.Hashes[k]
+		path := ""
+		if len(roots) > 0 {
+			path = firstPath(k)
+		}
+		cw.Write([]string{path, k, e.SHA256, strconv.FormatInt(e.Size, 10),
+			strconv.FormatFloat(float64(e.MTime), 'f', -1, 64)})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("error writing export: %w", err)
+	}
+	return nil
+}
+
+// writeChecksums writes the manifest in the sha256sum format or its BSD variant, the paths are relative to the
+// manifest directory so that it can be checked from there
+func writeChecksums(result sdhasher.Cache, keys []string, w io.Writer, args []string) error {
+	if len(roots) == 0 {
+		return fmt.Errorf("the models directories are required for the checksum manifest")
+	}
+	base := "."
+	if len(args) > 0 && args[0] != "-" {
+		base = filepath.Dir(args[0])
+	}
+	bw := bufio.NewWriter(w)
+	for _, k := range keys {
+		path := firstPath(k)
+		if path == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(base, path); err == nil && filepath.IsAbs(path) == filepath.IsAbs(base) {
+			path = rel
+		}
+		path = filepath.ToSlash(path)
+		if exportOptions.Format == "bsd" {
+			fmt.Fprintf(bw, "SHA256 (%s) = %s\n", path, result.Hashes[k].SHA256)
+			continue
+		}
+		// GNU coreutils escapes the names with backslashes and newlines and marks such lines with a backslash
+		prefix := ""
+		if strings.ContainsAny(path, "\\\n") {
+			path = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(path)
+			prefix = "\\"
+		}
+		fmt.Fprintf(bw, "%s%s  %s\n", prefix, result.Hashes[k].SHA256, path)
+	}
+	return bw.Flush()
+}
+
+// comfyUIFolders maps the cache key prefixes to the ComfyUI model folder names
+var comfyUIFolders = map[string]string{
+	"checkpoint/":        "checkpoints",
+	"lora/":              "loras",
+	"vae/":               "vae",
+	"textual_inversion/": "embeddings",
+	"hypernet/":          "hypernetworks",
+	"controlnet/":        "controlnet",
+}
+
+// writeComfyUI writes the hashes grouped by the ComfyUI model folders with the names relative to them the same way
+// ComfyUI refers to the models in the workflows
+func writeComfyUI(result sdhasher.Cache, w io.Writer) error {
+	folders := map[string]map[string]string{}
+	for k, e := range result.Hashes {
+		for prefix, folder := range comfyUIFolders {
+			if !strings.HasPrefix(k, prefix) {
+				continue
+			}
+			if folders[folder] == nil {
+				folders[folder] = map[string]string{}
+			}
+			folders[folder][filepath.ToSlash(strings.TrimPrefix(k, prefix))] = e.SHA256
+			break
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(folders)
+}
+
+// writeInvokeAI writes the SQL script that sets the hashes of the InvokeAI model records to the BLAKE3 hashes computed
+// with --hash blake3, apply it with sqlite3 databases/invokeai.db < script.sql
+func writeInvokeAI(result sdhasher.Cache, keys []string, w io.Writer) error {
+	if len(roots) == 0 {
+		return fmt.Errorf("the models directories are required to match the InvokeAI records")
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "BEGIN;")
+	missing := 0
+	for _, k := range keys {
+		hash := result.Hashes[k].Extra["blake3"]
+		path := firstPath(k)
+		if path == "" {
+			continue
+		}
+		if hash == "" {
+			missing++
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		fmt.Fprintf(bw, "UPDATE models SET config = json_set(config, '$.hash', %s) "+
+			"WHERE json_extract(config, '$.path') = %s;\n", sqlQuote("blake3:"+hash), sqlQuote(filepath.ToSlash(path)))
+	}
+	fmt.Fprintln(bw, "COMMIT;")
+	if missing > 0 {
+		slog.Warn("Entries without the BLAKE3 hash skipped, rehash with --hash blake3", "count", missing)
+	}
+	return bw.Flush()
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestWriteChecksums(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the names with backslashes and newlines are Unix only")
+	}
+	savedRoots, savedOptions := roots, exportOptions
+	t.Cleanup(func() { roots, exportOptions = savedRoots, savedOptions })
+	dir := t.TempDir()
+	models := filepath.Join(dir, "models")
+	roots = []root{{path: models, prefix: "checkpoint/", fsys: localStorage{dir: models}}}
+	resetNames()
+	tests := []struct {
+		name string
+		file string
+		gnu  string
+		bsd  string
+	}{
+		{"plain", "plain.safetensors", "aa  models/plain.safetensors\n", "SHA256 (models/plain.safetensors) = aa\n"},
+		{"space", "with space.safetensors", "aa  models/with space.safetensors\n",
+			"SHA256 (models/with space.safetensors) = aa\n"},
+		// GNU sha256sum -c unescapes the names of the lines starting with a backslash
+		{"backslash", `back\slash.safetensors`, `\aa  models/back\\slash.safetensors` + "\n",
+			`SHA256 (models/back\slash.safetensors) = aa` + "\n"},
+		{"newline", "new\nline.safetensors", `\aa  models/new\nline.safetensors` + "\n",
+			"SHA256 (models/new\nline.safetensors) = aa\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := "checkpoint/" + tt.file
+			if err := os.MkdirAll(models, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(models, tt.file), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			result := sdhasher.Cache{Hashes: map[string]sdhasher.Entry{key: {SHA256: "aa"}}}
+			for format, want := range map[string]string{"sha256sum": tt.gnu, "bsd": tt.bsd} {
+				exportOptions.Format = format
+				var buf bytes.Buffer
+				err := writeChecksums(result, []string{key}, &buf, []string{filepath.Join(dir, "SHA256SUMS")})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if buf.String() != want {
+					t.Errorf("%s: got %q, want %q", format, buf.String(), want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultFetchTimeout limits the cache download when --http-timeout isn't set
+const defaultFetchTimeout = time.Minute
+
+// isURL reports whether the cache path is an HTTP(S) URL
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// fetchCache downloads the cache file, a copy is kept in the user cache directory with its ETag so that the unchanged
+// file isn't downloaded again and the copy is used when the server is unavailable
+func fetchCache(url string) (io.ReadCloser, error) {
+	copyPath := ""
+	if dir, err := os.UserCacheDir(); err == nil {
+		copyPath = filepath.Join(dir, "sdhasher", fmt.Sprintf("%x.json", sha256.Sum256([]byte(url))))
+	}
+	ctx := context.Background()
+	if params.HTTPTimeout == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultFetchTimeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if etag, err := os.ReadFile(copyPath + ".etag"); err == nil && copyPath != "" {
+		req.Header.Set("If-None-Match", string(etag))
+	}
+	slog.Info("Downloading cache", "url", url)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return cachedCopy(copyPath, err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		slog.Info("Cache not modified, using the local copy", "url", url)
+		return os.Open(copyPath)
+	case resp.StatusCode >= 500:
+		return cachedCopy(copyPath, fmt.Errorf("server error %s", resp.Status))
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("error downloading %s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return cachedCopy(copyPath, err)
+	}
+	if copyPath != "" {
+		saveCopy(copyPath, data, resp.Header.Get("ETag"))
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// cachedCopy opens the local copy of the cache after a download error
+func cachedCopy(copyPath string, err error) (io.ReadCloser, error) {
+	f, openErr := os.Open(copyPath)
+	if copyPath == "" || openErr != nil {
+		return nil, err
+	}
+	slog.Warn("Error downloading cache, using the local copy", "path", copyPath, "error", err)
+	return f, nil
+}
+
+// saveCopy stores the downloaded cache and its ETag
+func saveCopy(copyPath string, data []byte, etag string) {
+	err := os.MkdirAll(filepath.Dir(copyPath), 0755)
+	if err == nil {
+		err = os.WriteFile(copyPath, data, 0644)
+	}
+	if err == nil && etag != "" {
+		err = os.WriteFile(copyPath+".etag", []byte(etag), 0644)
+	} else if err == nil {
+		os.Remove(copyPath + ".etag")
+	}
+	if err != nil {
+		slog.Warn("Error saving the local copy of cache", "path", copyPath, "error", err)
+	}
+}
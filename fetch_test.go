@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetchCache(t *testing.T) {
+	savedClient := httpClient
+	t.Cleanup(func() { httpClient = savedClient })
+	// the local copy goes to the user cache directory
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+	var status int
+	var etag, body, ifNoneMatch string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer srv.Close()
+	httpClient = srv.Client()
+	// the steps share the local copy
+	steps := []struct {
+		name        string
+		status      int
+		etag        string
+		body        string
+		ifNoneMatch string
+		want        string
+		err         bool
+	}{
+		{"downloaded", http.StatusOK, `"v1"`, "first", "", "first", false},
+		{"not modified", http.StatusNotModified, `"v1"`, "", `"v1"`, "first", false},
+		{"changed without etag", http.StatusOK, "", "second", `"v1"`, "second", false},
+		{"server error", http.StatusInternalServerError, "", "error", "", "second", false},
+		{"not found", http.StatusNotFound, "", "", "", "", true},
+	}
+	for _, tt := range steps {
+		status, etag, body = tt.status, tt.etag, tt.body
+		r, err := fetchCache(srv.URL + "/cache.json")
+		if ifNoneMatch != tt.ifNoneMatch {
+			t.Errorf("%s: got If-None-Match %q, want %q", tt.name, ifNoneMatch, tt.ifNoneMatch)
+		}
+		if err != nil {
+			if !tt.err {
+				t.Errorf("%s: %s", tt.name, err)
+			}
+			continue
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.err || string(data) != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, data, err, tt.want)
+		}
+	}
+	// the copy is used when the server is unavailable
+	srv.Close()
+	r, err := fetchCache(srv.URL + "/cache.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if data, err := io.ReadAll(r); err != nil || string(data) != "second" {
+		t.Errorf("got %q, %v from the unavailable server, want the local copy", data, err)
+	}
+}
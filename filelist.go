@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// readFileList reads the file paths separated by newlines or NUL characters
+func readFileList(r io.Reader) ([]string, error) {
+	sep := byte('\n')
+	if params.Null {
+		sep = 0
+	}
+	s := bufio.NewScanner(r)
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	var result []string
+	for s.Scan() {
+		line := s.Text()
+		if sep == '\n' {
+			line = strings.TrimSuffix(line, "\r")
+		}
+		if line != "" {
+			result = append(result, line)
+		}
+	}
+	return result, s.Err()
+}
+
+// scanList hashes exactly the listed files and merges them into the cache, the rest of the cache is left as is
+func scanList(ctx context.Context, result *sdhasher.Cache, r io.Reader) int {
+	stats = runStats{started: time.Now()}
+	events.scanStarted()
+	paths, err := readFileList(r)
+	if err != nil {
+		slog.Error("Error reading the file list", "error", err)
+	}
+	var tasks []*task
+	failed := 0
+	seen := map[string]struct{}{}
+	for _, path := range paths {
+		path = longPath(filepath.Clean(path))
+		if _, ok := seen[path]; ok {
+			continue
+		}
+		seen[path] = struct{}{}
+		fi, err := os.Stat(path)
+		if err != nil {
+			slog.Error("Error accessing file", "path", path, "error", err)
+			failed++
+			continue
+		}
+		if fi.IsDir() {
+			slog.Warn("Skipping directory", "path", path)
+			continue
+		}
+		key, err := keyFor(path)
+		if err != nil {
+			slog.Error("Error getting relative path", "path", path, "error", err)
+			failed++
+			continue
+		}
+		if params.DryRun {
+			slog.Info("New file", "path", path, "bytes", fi.Size())
+		}
+		tasks = append(tasks, newTask(path, key, fs.FileInfoToDirEntry(fi)))
+	}
+	if params.DryRun {
+		slog.Info("Plan", "hash", len(tasks), "bytes", totalSize(tasks))
+		return 0
+	}
+	hashed := hashFiles(ctx, tasks, autosaver(result))
+	applyHashed(result, hashed)
+	stats.Hashed = len(hashed)
+	stats.hashed = hashed
+	runHooks(ctx, hashed)
+	stats.Errors += failed
+	stats.finish()
+	if params.AutoV2 {
+		sdhasher.AddAutoV2(result.Hashes)
+	}
+	return len(hashed)
+}
@@ -1,7 +1,35 @@
 module github.com/rkfg/sdhasher
 
-go 1.20
+// 1.22 for the method patterns of the http.ServeMux routes
+go 1.22
 
-require github.com/jessevdk/go-flags v1.5.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	github.com/jessevdk/go-flags v1.5.0
+	github.com/pkg/sftp v1.13.6
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sys v0.22.0
+	google.golang.org/grpc v1.64.0
+	google.golang.org/protobuf v1.34.1
+	modernc.org/sqlite v1.33.1
+)
 
-require golang.org/x/sys v0.0.0-20210320140829-1e4c9ba3b0c4 // indirect
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/crypto v0.21.0 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
+	modernc.org/libc v1.55.3 // indirect
+	modernc.org/mathutil v1.6.0 // indirect
+	modernc.org/memory v1.8.0 // indirect
+	modernc.org/strutil v1.2.0 // indirect
+	modernc.org/token v1.1.0 // indirect
+)
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasherpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer is the gRPC API of the serve command, it shares the cache and the scan lock with the HTTP API
+type grpcServer struct {
+	sdhasherpb.UnimplementedHasherServer
+	s *server
+}
+
+// Scan rescans the models like POST /scan streaming the progress events
+func (g grpcServer) Scan(_ *sdhasherpb.ScanRequest, stream sdhasherpb.Hasher_ScanServer) error {
+	if !g.s.scanMu.TryLock() {
+		return status.Error(codes.Aborted, "scan is already running")
+	}
+	defer g.s.scanMu.Unlock()
+	return streamEvents(stream.Send, func() error {
+		_, err := g.s.rescan(g.s.ctx)
+		return err
+	})
+}
+
+// Verify rehashes the cached files matching the patterns streaming the progress events and the failed files
+func (g grpcServer) Verify(req *sdhasherpb.VerifyRequest, stream sdhasherpb.Hasher_VerifyServer) error {
+	if !g.s.scanMu.TryLock() {
+		return status.Error(codes.Aborted, "scan is already running")
+	}
+	defer g.s.scanMu.Unlock()
+	g.s.mu.RLock()
+	cache := g.s.cache.Clone()
+	g.s.mu.RUnlock()
+	return streamEvents(stream.Send, func() error {
+		verify(g.s.ctx, cache, req.Patterns)
+		return nil
+	})
+}
+
+// Lookup returns the entry of the key or the path like GET /hash or the entries with the hash like GET /paths
+func (g grpcServer) Lookup(_ context.Context, req *sdhasherpb.LookupRequest) (*sdhasherpb.LookupResponse, error) {
+	g.s.mu.RLock()
+	defer g.s.mu.RUnlock()
+	var key string
+	switch q := req.Query.(type) {
+	case *sdhasherpb.LookupRequest_Sha256:
+		if q.Sha256 == "" {
+			return nil, status.Error(codes.InvalidArgument, "sha256 is required")
+		}
+		resp := &sdhasherpb.LookupResponse{}
+		for _, e := range g.s.withHash(q.Sha256) {
+			resp.Entries = append(resp.Entries, g.entryMessage(e))
+		}
+		sort.Slice(resp.Entries, func(i, j int) bool { return resp.Entries[i].Key < resp.Entries[j].Key })
+		return resp, nil
+	case *sdhasherpb.LookupRequest_Path:
+		var err error
+		if key, err = keyFor(longPath(filepath.Clean(q.Path))); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	case *sdhasherpb.LookupRequest_Key:
+		key = q.Key
+	default:
+		return nil, status.Error(codes.InvalidArgument, "key, path or sha256 is required")
+	}
+	e, ok := g.s.cache.Hashes[key]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "no entry for "+key)
+	}
+	return &sdhasherpb.LookupResponse{
+		Entries: []*sdhasherpb.Entry{g.entryMessage(pathEntry{Key: key, Path: firstPath(key), Entry: e})},
+	}, nil
+}
+
+// entryMessage converts the entry, the Additional Networks hash is taken from its section, mu should be held
+func (g grpcServer) entryMessage(e pathEntry) *sdhasherpb.Entry {
+	return &sdhasherpb.Entry{Key: e.Key, Path: e.Path, Sha256: e.Entry.SHA256,
+		Addnet: g.s.cache.HashesAddnet[e.Key].SHA256, Size: e.Entry.Size,
+		Mtime: float64(e.Entry.MTime), Extra: e.Entry.Extra}
+}
+
+// streamEvents sends the events to the client while run runs, the run isn't stopped when the client goes away
+func streamEvents(send func(*sdhasherpb.ProgressEvent) error, run func() error) error {
+	l := events.subscribe()
+	defer events.unsubscribe(l)
+	done := make(chan error, 1)
+	go func() { done <- run() }()
+	var sendErr error
+	forward := func(e progressEvent) {
+		if sendErr == nil {
+			sendErr = send(progressMessage(e))
+		}
+	}
+	for {
+		select {
+		case e := <-l:
+			forward(e)
+		case err := <-done:
+			// the events of the end of the run can still be buffered
+			for len(l) > 0 {
+				forward(<-l)
+			}
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			return sendErr
+		}
+	}
+}
+
+func progressMessage(e progressEvent) *sdhasherpb.ProgressEvent {
+	result := &sdhasherpb.ProgressEvent{Event: e.Event, Time: timestamppb.New(e.Time), Paths: e.Paths, Key: e.Key,
+		Path: e.Path, Size: e.Size, Rehash: e.Rehash, Sha256: e.SHA256, Duration: e.Duration, Error: e.Error,
+		Expected: e.Expected}
+	if p := e.Progress; p != nil {
+		result.Progress = &sdhasherpb.Progress{Files: p.Files, TotalFiles: p.TotalFiles, Bytes: p.Bytes,
+			TotalBytes: p.TotalBytes}
+	}
+	if s := e.Summary; s != nil {
+		result.Summary = &sdhasherpb.Summary{Hashed: int64(s.Hashed), UpToDate: int64(s.UpToDate),
+			Pruned: int64(s.Pruned), Errors: int64(s.Errors), Retried: int64(s.Retried), BytesRead: s.BytesRead,
+			Duration: s.Duration, Throughput: s.Throughput, WorkerUtilization: s.WorkerUtilization}
+	}
+	if v := e.Verified; v != nil {
+		result.Verified = &sdhasherpb.Verified{Files: int64(v.Files), Failed: int64(v.Failed)}
+	}
+	return result
+}
+
+// listenGRPC serves the gRPC API on --grpc-listen until the context is cancelled
+func listenGRPC(ctx context.Context, s *server) {
+	l, err := net.Listen("tcp", serveOptions.GRPCListen)
+	if err != nil {
+		fatal("Error serving gRPC", "address", serveOptions.GRPCListen, "error", err)
+	}
+	srv := grpc.NewServer()
+	sdhasherpb.RegisterHasherServer(srv, grpcServer{s: s})
+	go func() {
+		<-ctx.Done()
+		// the streams end with their scans, the waiting is limited like for the HTTP server
+		timer := time.AfterFunc(10*time.Second, srv.Stop)
+		defer timer.Stop()
+		srv.GracefulStop()
+	}()
+	slog.Info("Serving gRPC", "address", serveOptions.GRPCListen)
+	go func() {
+		if err := srv.Serve(l); err != nil {
+			fatal("Error serving gRPC", "address", serveOptions.GRPCListen, "error", err)
+		}
+	}()
+}
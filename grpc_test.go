@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+	"github.com/rkfg/sdhasher/pkg/sdhasherpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// receiveEvents reads the stream until it ends and returns the names of the events and the last event
+func receiveEvents(t *testing.T, stream grpc.ClientStream) ([]string, *sdhasherpb.ProgressEvent) {
+	t.Helper()
+	var names []string
+	var last *sdhasherpb.ProgressEvent
+	for {
+		e := &sdhasherpb.ProgressEvent{}
+		err := stream.RecvMsg(e)
+		if err == io.EOF {
+			return names, last
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, e.Event)
+		last = e
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	savedParams, savedRoots, savedDirs, savedExtensions := params, roots, baseDirs, extensions
+	t.Cleanup(func() {
+		params, roots, baseDirs, extensions = savedParams, savedRoots, savedDirs, savedExtensions
+		bufferPool = sync.Pool{}
+	})
+	dir := t.TempDir()
+	models := filepath.Join(dir, "models")
+	if err := os.Mkdir(models, 0755); err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]string{}
+	for _, name := range []string{"a.safetensors", "b.safetensors"} {
+		data := []byte("model " + name)
+		if err := os.WriteFile(filepath.Join(models, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256(data)
+		hashes["checkpoint/"+name] = hex.EncodeToString(sum[:])
+	}
+	params.MaxHashers, params.BufferSize, params.Output = 1, 4096, filepath.Join(dir, "cache.json")
+	setupBuffers()
+	roots = []root{{path: models, prefix: "checkpoint/", fsys: localStorage{dir: models}}}
+	baseDirs = []string{models}
+	extensions = map[string]struct{}{".safetensors": {}}
+	resetNames()
+	var cache sdhasher.Cache
+	cache.Init()
+	s := &server{cache: cache, ctx: context.Background()}
+	l := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	sdhasherpb.RegisterHasherServer(srv, grpcServer{s: s})
+	go srv.Serve(l)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return l.DialContext(ctx) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := sdhasherpb.NewHasherClient(conn)
+	ctx := context.Background()
+
+	scan, err := client.Scan(ctx, &sdhasherpb.ScanRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, last := receiveEvents(t, scan)
+	want := []string{"scan_started", "file_queued", "file_queued", "file_done", "file_done", "run_finished"}
+	if len(names) != len(want) || names[0] != want[0] || names[len(names)-1] != want[len(want)-1] {
+		t.Errorf("got scan events %v, want %v", names, want)
+	}
+	if last.GetSummary().GetHashed() != 2 {
+		t.Errorf("got summary %v, want 2 hashed files", last.GetSummary())
+	}
+
+	lookups := []struct {
+		name string
+		req  *sdhasherpb.LookupRequest
+		keys []string
+		code codes.Code
+	}{
+		{"key", &sdhasherpb.LookupRequest{Query: &sdhasherpb.LookupRequest_Key{Key: "checkpoint/a.safetensors"}},
+			[]string{"checkpoint/a.safetensors"}, codes.OK},
+		{"path", &sdhasherpb.LookupRequest{Query: &sdhasherpb.LookupRequest_Path{
+			Path: filepath.Join(models, "b.safetensors")}}, []string{"checkpoint/b.safetensors"}, codes.OK},
+		{"sha256 prefix", &sdhasherpb.LookupRequest{Query: &sdhasherpb.LookupRequest_Sha256{
+			Sha256: hashes["checkpoint/a.safetensors"][:10]}}, []string{"checkpoint/a.safetensors"}, codes.OK},
+		{"unknown hash", &sdhasherpb.LookupRequest{Query: &sdhasherpb.LookupRequest_Sha256{Sha256: "none"}}, nil,
+			codes.OK},
+		{"missing key", &sdhasherpb.LookupRequest{Query: &sdhasherpb.LookupRequest_Key{Key: "checkpoint/c"}}, nil,
+			codes.NotFound},
+		{"empty", &sdhasherpb.LookupRequest{}, nil, codes.InvalidArgument},
+	}
+	for _, tt := range lookups {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Lookup(ctx, tt.req)
+			if status.Code(err) != tt.code {
+				t.Fatalf("got error %v, want %v", err, tt.code)
+			}
+			if len(resp.GetEntries()) != len(tt.keys) {
+				t.Fatalf("got entries %v, want %v", resp.GetEntries(), tt.keys)
+			}
+			for i, e := range resp.GetEntries() {
+				if e.Key != tt.keys[i] || e.Sha256 != hashes[e.Key] || e.Size == 0 {
+					t.Errorf("got entry %v, want %s with sha256 %s", e, tt.keys[i], hashes[tt.keys[i]])
+				}
+			}
+		})
+	}
+
+	if err := os.WriteFile(filepath.Join(models, "b.safetensors"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	verify, err := client.Verify(ctx, &sdhasherpb.VerifyRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, last = receiveEvents(t, verify)
+	failed := 0
+	for _, name := range names {
+		if name == "file_failed" {
+			failed++
+		}
+	}
+	if failed != 1 || last.GetEvent() != "verify_finished" || last.GetVerified().GetFailed() != 1 {
+		t.Errorf("got verify events %v ending with %v, want one failed file", names, last)
+	}
+
+	// only one scan runs at a time
+	s.scanMu.Lock()
+	defer s.scanMu.Unlock()
+	scan, err = client.Scan(ctx, &sdhasherpb.ScanRequest{})
+	if err == nil {
+		_, err = scan.Recv()
+	}
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("got error %v for the concurrent scan, want %v", err, codes.Aborted)
+	}
+}
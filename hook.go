@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// runHooks runs the --exec command for every hashed file, the file details are passed in the environment under the
+// SDHASHER_FILE_ names that don't clash with the option variables
+func runHooks(ctx context.Context, hashed []*sdhasher.Entry) {
+	if params.Exec == "" {
+		return
+	}
+	for _, e := range hashed {
+		if ctx.Err() != nil {
+			return
+		}
+		var cmd *exec.Cmd
+		if runtime.GOOS == "windows" {
+			cmd = exec.CommandContext(ctx, "cmd", "/C", params.Exec)
+		} else {
+			cmd = exec.CommandContext(ctx, "sh", "-c", params.Exec)
+		}
+		prefix := ""
+		if r := rootFor(e.Path); r != nil {
+			prefix = r.prefix
+		}
+		cmd.Env = append(os.Environ(),
+			"SDHASHER_FILE_PATH="+e.Path,
+			"SDHASHER_FILE_KEY="+e.Key,
+			"SDHASHER_FILE_SHA256="+e.SHA256,
+			"SDHASHER_FILE_SIZE="+strconv.FormatInt(e.Size, 10),
+			"SDHASHER_FILE_PREFIX="+prefix,
+		)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			slog.Error("Error running hook", "path", e.Path, "command", params.Exec, "error", err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpClient is shared by all features that talk to remote servers
+var httpClient = http.DefaultClient
+
+// headerTransport adds the extra headers of the request host, the headers set by the request itself such as the
+// signatures and the credentials of the storages are kept
+type headerTransport struct {
+	headers map[string]http.Header
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers := t.headers[strings.ToLower(req.URL.Hostname())]
+	if len(headers) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	for k, v := range headers {
+		if _, ok := req.Header[k]; !ok {
+			req.Header[k] = v
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// parseHeader parses the host=Name: value header option
+func parseHeader(h string) (host, name, value string, err error) {
+	host, header, ok := strings.Cut(h, "=")
+	if ok {
+		name, value, ok = strings.Cut(header, ":")
+	}
+	host, name = strings.TrimSpace(host), strings.TrimSpace(name)
+	if !ok || host == "" || name == "" || strings.ContainsAny(host, ":/") {
+		return "", "", "", fmt.Errorf("invalid header %s, expected \"host=Name: value\"", h)
+	}
+	return strings.ToLower(host), http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+func setupHTTPClient() error {
+	if params.HTTPProxy == "" && len(params.HTTPHeaders) == 0 && params.HTTPTimeout == 0 && !params.HTTPInsecure {
+		return nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if params.HTTPProxy != "" {
+		proxy, err := url.Parse(params.HTTPProxy)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL %s: %w", params.HTTPProxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if params.HTTPInsecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	if params.HTTPTimeout > 0 {
+		// the whole request isn't limited so that the long downloads of the models aren't cut off
+		dialer := &net.Dialer{Timeout: params.HTTPTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = params.HTTPTimeout
+		transport.ResponseHeaderTimeout = params.HTTPTimeout
+	}
+	var rt http.RoundTripper = transport
+	if len(params.HTTPHeaders) > 0 {
+		headers := map[string]http.Header{}
+		for _, h := range params.HTTPHeaders {
+			host, name, value, err := parseHeader(h)
+			if err != nil {
+				return err
+			}
+			if headers[host] == nil {
+				headers[host] = http.Header{}
+			}
+			headers[host].Add(name, value)
+		}
+		rt = &headerTransport{headers: headers, base: transport}
+	}
+	httpClient = &http.Client{Transport: rt}
+	return nil
+}
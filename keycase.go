@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// caseCollisions warns about the keys that differ only in case and point to the same file on a case-insensitive
+// filesystem, with --fix-case only the key matching the file name on disk is kept, it returns the number of removed
+// entries
+func caseCollisions(c *sdhasher.Cache) int {
+	byFolded := map[string][]string{}
+	for _, k := range sortedKeys(c.Hashes) {
+		folded := strings.ToLower(k)
+		byFolded[folded] = append(byFolded[folded], k)
+	}
+	removed := 0
+	for _, folded := range sortedKeys(byFolded) {
+		keys := byFolded[folded]
+		if len(keys) < 2 {
+			continue
+		}
+		// on the case-sensitive filesystems the names are different files even if they're hardlinked
+		actual := diskKey(keys[0])
+		if !sameDiskKey(keys, actual) {
+			continue
+		}
+		if !params.FixCase {
+			slog.Warn("Cache keys differ only in case", "keys", keys)
+			continue
+		}
+		slog.Warn("Cache keys differ only in case", "keys", keys)
+		if _, ok := c.Hashes[actual]; !ok {
+			// none of the keys matches the disk, keep the latest entry
+			latest := keys[0]
+			for _, k := range keys[1:] {
+				if c.Hashes[k].MTime > c.Hashes[latest].MTime {
+					latest = k
+				}
+			}
+			c.Rename(latest, actual)
+			slog.Info("Renamed key", "key", latest, "new_key", actual)
+		}
+		for _, k := range keys {
+			if k != actual {
+				c.Remove(k)
+			}
+		}
+		removed += len(keys) - 1
+	}
+	return removed
+}
+
+// sameDiskKey reports whether the files of all the keys exist and have the same name on disk
+func sameDiskKey(keys []string, actual string) bool {
+	for _, k := range keys {
+		if _, _, err := statKey(k); err != nil || diskKey(k) != actual {
+			return false
+		}
+	}
+	return true
+}
+
+// diskKey returns the cache key with the case of the directory and file names as they're stored on disk
+func diskKey(key string) string {
+	paths := pathsFor(key)
+	if len(paths) == 0 {
+		return key
+	}
+	r := rootFor(paths[0])
+	rel, err := filepath.Rel(r.path, paths[0])
+	if err != nil {
+		return key
+	}
+	dir := r.path
+	var names []string
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return key
+		}
+		actual := name
+		for _, e := range entries {
+			if e.Name() == name {
+				actual = name
+				break
+			}
+			if strings.EqualFold(e.Name(), name) {
+				actual = e.Name()
+			}
+		}
+		names = append(names, actual)
+		dir = filepath.Join(dir, actual)
+	}
+	return r.prefix + strings.Join(names, "/")
+}
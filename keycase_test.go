@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// caseTree creates the files under a checkpoint/ root
+func caseTree(t *testing.T, files ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, f := range files {
+		path := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots = []root{{path: dir, prefix: "checkpoint/", fsys: localStorage{dir: dir}}}
+	resetNames()
+	return dir
+}
+
+func TestDiskKey(t *testing.T) {
+	savedRoots := roots
+	t.Cleanup(func() { roots = savedRoots })
+	tests := []struct {
+		name  string
+		files []string
+		key   string
+		want  string
+	}{
+		{"same case", []string{"SD/Model.safetensors"}, "checkpoint/SD/Model.safetensors",
+			"checkpoint/SD/Model.safetensors"},
+		{"file and directory", []string{"SD/Model.safetensors"}, "checkpoint/sd/model.SAFETENSORS",
+			"checkpoint/SD/Model.safetensors"},
+		// the exact name wins over the other files differing only in case
+		{"exact match", []string{"model.safetensors", "Model.safetensors"}, "checkpoint/Model.safetensors",
+			"checkpoint/Model.safetensors"},
+		{"missing file", []string{"other.safetensors"}, "checkpoint/model.safetensors", "checkpoint/model.safetensors"},
+		{"other prefix", []string{"model.safetensors"}, "lora/Model", "lora/Model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			caseTree(t, tt.files...)
+			if got := diskKey(tt.key); got != tt.want {
+				t.Errorf("diskKey(%s) = %s, want %s", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaseCollisions(t *testing.T) {
+	savedParams, savedRoots := params, roots
+	t.Cleanup(func() { params, roots = savedParams, savedRoots })
+	params.FixCase = true
+	cache := func(keys ...string) *sdhasher.Cache {
+		var c sdhasher.Cache
+		c.Init()
+		for i, k := range keys {
+			c.Hashes[k] = sdhasher.Entry{MTime: sdhasher.MTime(i + 1), SHA256: k}
+		}
+		return &c
+	}
+	// the different files on a case-sensitive filesystem are left alone
+	dir := caseTree(t, "model.safetensors", "Model.safetensors")
+	if _, err := os.Stat(filepath.Join(dir, "MODEL.safetensors")); err == nil {
+		t.Skip("case-insensitive filesystem")
+	}
+	c := cache("checkpoint/model.safetensors", "checkpoint/Model.safetensors")
+	if removed := caseCollisions(c); removed != 0 || len(c.Hashes) != 2 {
+		t.Errorf("removed %d entries of the different files: %v", removed, c.Hashes)
+	}
+	// the key of the missing file isn't a collision
+	caseTree(t, "Model.safetensors")
+	c = cache("checkpoint/model.safetensors", "checkpoint/Model.safetensors")
+	if removed := caseCollisions(c); removed != 0 || len(c.Hashes) != 2 {
+		t.Errorf("removed %d entries with a missing file: %v", removed, c.Hashes)
+	}
+}
+
+func TestCaseCollisionsInsensitive(t *testing.T) {
+	savedParams, savedRoots := params, roots
+	t.Cleanup(func() { params, roots = savedParams, savedRoots })
+	dir := caseTree(t, "SD/Model.safetensors")
+	if _, err := os.Stat(filepath.Join(dir, "sd", "model.safetensors")); err != nil {
+		t.Skip("case-sensitive filesystem")
+	}
+	tests := []struct {
+		name    string
+		fix     bool
+		keys    []string
+		want    map[string]string
+		removed int
+	}{
+		{"warning only", false, []string{"checkpoint/SD/Model.safetensors", "checkpoint/sd/model.safetensors"},
+			map[string]string{"checkpoint/SD/Model.safetensors": "checkpoint/SD/Model.safetensors",
+				"checkpoint/sd/model.safetensors": "checkpoint/sd/model.safetensors"}, 0},
+		{"disk key kept", true, []string{"checkpoint/SD/Model.safetensors", "checkpoint/sd/model.safetensors"},
+			map[string]string{"checkpoint/SD/Model.safetensors": "checkpoint/SD/Model.safetensors"}, 1},
+		// the latest entry moves to the disk key
+		{"no disk key", true, []string{"checkpoint/SD/model.safetensors", "checkpoint/sd/Model.safetensors"},
+			map[string]string{"checkpoint/SD/Model.safetensors": "checkpoint/sd/Model.safetensors"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params.FixCase = tt.fix
+			var c sdhasher.Cache
+			c.Init()
+			for i, k := range tt.keys {
+				c.Hashes[k] = sdhasher.Entry{MTime: sdhasher.MTime(i + 1), SHA256: k}
+			}
+			if removed := caseCollisions(&c); removed != tt.removed {
+				t.Errorf("removed %d entries, want %d", removed, tt.removed)
+			}
+			got := map[string]string{}
+			for k, e := range c.Hashes {
+				got[k] = e.SHA256
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
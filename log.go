@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// logLevel returns the minimum level of the log messages, --quiet raises it to warnings
+func logLevel() slog.Level {
+	level := logLevels[params.LogLevel]
+	if params.Quiet && level < slog.LevelWarn {
+		level = slog.LevelWarn
+	}
+	return level
+}
+
+// unfilteredHandler logs the records regardless of the minimum level, for the lines printed in quiet mode
+type unfilteredHandler struct {
+	slog.Handler
+}
+
+func (unfilteredHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+// setupLogging configures the default logger according to the log format and level
+func setupLogging() {
+	level := logLevel()
+	// the warnings and errors are also kept for the dashboard
+	out := io.MultiWriter(os.Stderr, &recentErrors)
+	if params.LogFormat == "json" {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})))
+		return
+	}
+	if params.LogFormat == "journal" || params.LogFormat == "text" && underJournal() {
+		out = io.MultiWriter(journalWriter{os.Stderr}, &recentErrors)
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: level, ReplaceAttr: dropTime})))
+		return
+	}
+	if useColor() {
+		out = io.MultiWriter(colorWriter{os.Stderr}, &recentErrors)
+	}
+	log.SetOutput(out)
+	slog.SetLogLoggerLevel(level)
+}
+
+// recentLog keeps the last warning and error lines written to the log
+type recentLog struct {
+	sync.Mutex
+	lines []string
+}
+
+var recentErrors recentLog
+
+const recentLogLines = 50
+
+func (l *recentLog) Write(p []byte) (int, error) {
+	line := strings.TrimSpace(string(p))
+	levels := []string{" WARN ", " ERROR ", `"level":"WARN"`, `"level":"ERROR"`, "level=WARN", "level=ERROR"}
+	for _, level := range levels {
+		if strings.Contains(line, level) {
+			l.Lock()
+			l.lines = append(l.lines, line)
+			if len(l.lines) > recentLogLines {
+				l.lines = l.lines[1:]
+			}
+			l.Unlock()
+			break
+		}
+	}
+	return len(p), nil
+}
+
+func (l *recentLog) get() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string{}, l.lines...)
+}
+
+// dropTime removes the time from the log lines for the logs that add their own like journald and the Windows event log
+func dropTime(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) == 0 && a.Key == slog.TimeKey {
+		return slog.Attr{}
+	}
+	return a
+}
+
+// splitLogLine splits the text handler line written without the time into the level without the offset of the custom
+// levels and the unquoted message followed by the attributes
+func splitLogLine(p []byte) (string, []byte, bool) {
+	line, ok := bytes.CutPrefix(p, []byte("level="))
+	if !ok {
+		return "", nil, false
+	}
+	level, rest, _ := bytes.Cut(line, []byte(" "))
+	name := string(level)
+	// the custom levels look like WARN+2
+	if i := strings.IndexAny(name, "+-"); i > 0 {
+		name = name[:i]
+	}
+	msg, ok := bytes.CutPrefix(rest, []byte("msg="))
+	if !ok {
+		return name, rest, true
+	}
+	value, tail, _ := bytes.Cut(msg, []byte(" "))
+	if quoted, err := strconv.QuotedPrefix(string(msg)); err == nil {
+		unquoted, _ := strconv.Unquote(quoted)
+		value, tail = []byte(unquoted), bytes.TrimPrefix(msg[len(quoted):], []byte(" "))
+	}
+	var b bytes.Buffer
+	b.Write(value)
+	if len(bytes.TrimSpace(tail)) > 0 {
+		b.WriteByte(' ')
+	}
+	b.Write(tail)
+	return name, b.Bytes(), true
+}
+
+// fatal logs the error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	exit(1)
+}
+
+// exit reports the exit code to the service control manager when running as a Windows service and exits
+func exit(code int) {
+	stopService(code)
+	os.Exit(code)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package main
+
+import "path/filepath"
+
+// longPath makes the path absolute, the os package only adds the \\?\ prefix lifting the MAX_PATH limit to absolute
+// paths
+func longPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
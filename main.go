@@ -1,185 +1,1291 @@
 package main
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/jessevdk/go-flags"
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
 )
 
 var params struct {
-	Path       string `short:"p" description:"Path to the models directory" required:"true"`
-	Input      string `short:"i" description:"Path to source cache.json file"`
-	Output     string `short:"o" description:"Path to resulting cache.json file" required:"true"`
-	MaxHashers int    `short:"m" description:"Max number of hashing tasks"`
+	Config             string        `long:"config" description:"TOML file with the values of the options by their long names, the options of the commands go to the tables named after them, the command line overrides the SDHASHER_* environment variables and they override the file (default: sdhasher.toml in the user config directory if it exists)" no-ini:"true" env:"SDHASHER_CONFIG"`
+	Version            bool          `long:"version" description:"Print the version and exit" no-ini:"true"`
+	Paths              []string      `short:"p" long:"path" description:"Path to the models directory or its s3://, ssh://, sftp:// or webdav(s):// URL, can be repeated, an explicit cache key prefix can be given as path=prefix" env:"SDHASHER_PATH" env-delim:","`
+	Input              string        `short:"i" long:"input" description:"Path or HTTP(S) URL of the source cache.json file" env:"SDHASHER_INPUT"`
+	Output             string        `short:"o" long:"output" description:"Path to resulting cache.json file, - for stdout, required unless verifying" env:"SDHASHER_OUTPUT"`
+	Webui              string        `long:"webui" description:"Path to the webui installation, its models directories and cache file are found automatically and the cache is updated in place" env:"SDHASHER_WEBUI"`
+	Cache              string        `short:"c" long:"cache" description:"Path to cache.json file to update in place, replaces -i and -o" env:"SDHASHER_CACHE"`
+	UI                 string        `long:"ui" description:"UI the cache is for, when -i, -o or -c is the UI directory the cache file is found in it" choice:"a1111" choice:"sdnext" default:"a1111" env:"SDHASHER_UI"`
+	Compress           string        `long:"compress" description:"Compress the written cache, by default the outputs ending with .gz and .zst are compressed with gzip and zstd (the zstd command is used), the compressed input caches are detected automatically, the webui can only read uncompressed caches" choice:"gzip" choice:"zstd" choice:"none" env:"SDHASHER_COMPRESS"`
+	Store              string        `long:"store" description:"Database to keep the cache in instead of reading and rewriting the whole cache file, sqlite:PATH for an SQLite database or bolt:PATH for a bbolt database, the entries are written as soon as the files are hashed, the input cache is imported into the empty database and the output cache is exported from it" env:"SDHASHER_STORE"`
+	Lenient            bool          `long:"lenient" description:"Repair or drop the malformed entries of the input cache instead of failing, see the validate command" env:"SDHASHER_LENIENT"`
+	MaxHashers         int           `short:"m" long:"max-hashers" description:"Max number of hashing tasks" env:"SDHASHER_MAX_HASHERS"`
+	AutoLayout         bool          `long:"auto-layout" description:"Treat subdirectories of the models directory as webui model type roots (detected automatically when they're present)" env:"SDHASHER_AUTO_LAYOUT"`
+	RepairKeys         bool          `long:"repair-keys" description:"Move entries of missing files to the keys of found files with the same size and time or hash" env:"SDHASHER_REPAIR_KEYS"`
+	Extensions         []string      `long:"ext" description:"File extension to hash, can be repeated or comma separated, replaces the defaults" default:".safetensors" default:".ckpt" default:".gguf" env:"SDHASHER_EXT" env-delim:","`
+	Excludes           []string      `long:"exclude" description:"Glob pattern of the files to skip in the .gitignore syntax, can be repeated, .sdhasherignore files are also read from the scanned directories" env:"SDHASHER_EXCLUDE" env-delim:","`
+	Symlinks           string        `long:"symlinks" description:"How to treat symlinks: follow hashes the targets and descends into linked directories, dedup also hashes every target once for all links to it, skip ignores them" choice:"follow" choice:"dedup" choice:"skip" default:"follow" env:"SDHASHER_SYMLINKS"`
+	MinSize            byteSize      `long:"min-size" description:"Skip files smaller than this size, K, M, G and T suffixes are supported" env:"SDHASHER_MIN_SIZE"`
+	MaxSize            byteSize      `long:"max-size" description:"Skip files larger than this size, K, M, G and T suffixes are supported" env:"SDHASHER_MAX_SIZE"`
+	Prefix             string        `long:"prefix" description:"Cache key prefix for the models directory" default:"checkpoint/" env:"SDHASHER_PREFIX"`
+	ExtraHashes        []string      `long:"hash" description:"Extra hash to compute in the same pass and store in the entries, can be repeated, model_hash is the old 8 character webui hash" choice:"blake3" choice:"sha1" choice:"sha512" choice:"md5" choice:"model_hash" env:"SDHASHER_HASH" env-delim:","`
+	AutoV2             bool          `long:"autov2" description:"Store the short AutoV2 hash used by the webui and Civitai in the entries" env:"SDHASHER_AUTOV2"`
+	Addnet             bool          `long:"addnet" description:"Also compute the legacy Additional Networks hashes of the safetensors files outside of the LoRA directories, the LoRA models always get them as the webui looks them up by these hashes" env:"SDHASHER_ADDNET"`
+	Metadata           bool          `long:"metadata" description:"Also store the safetensors header metadata in the safetensors-metadata section" env:"SDHASHER_METADATA"`
+	ReadSidecars       bool          `long:"read-sidecars" description:"Use the hashes from the <file>.sha256 files newer than the models instead of hashing them" env:"SDHASHER_READ_SIDECARS"`
+	SidecarSample      int           `long:"sidecar-sample" description:"Percentage of the files with sidecars to hash anyway and compare" env:"SDHASHER_SIDECAR_SAMPLE"`
+	WriteSidecars      bool          `long:"write-sidecars" description:"Write the <file>.sha256 file in the sha256sum format next to every hashed local model" env:"SDHASHER_WRITE_SIDECARS"`
+	Kohya              bool          `long:"kohya" description:"Store the base model hashes and name that kohya sd-scripts put in the LoRA metadata in the entries" env:"SDHASHER_KOHYA"`
+	GGUF               bool          `long:"gguf" description:"Store the architecture and the quantization type from the GGUF header in the entries of the .gguf files" env:"SDHASHER_GGUF"`
+	ModelType          bool          `long:"model-type" description:"Store the model type detected from the safetensors tensor names (sd1, sd2, sdxl, sd3, flux, lora, vae or text_encoder) in the entries and warn about the files in the directories of other types" env:"SDHASHER_MODEL_TYPE"`
+	Civitai            bool          `long:"civitai" description:"Look up the models on Civitai and save the missing .civitai.info files next to them, the models in the remote directories are skipped" env:"SDHASHER_CIVITAI"`
+	CivitaiPreview     bool          `long:"civitai-preview" description:"Download the first Civitai preview image for the models without a preview" env:"SDHASHER_CIVITAI_PREVIEW"`
+	SkipExisting       bool          `long:"skip-existing" description:"Don't download previews for the models that have a preview image of any supported name" env:"SDHASHER_SKIP_EXISTING"`
+	CivitaiDelay       time.Duration `long:"civitai-delay" description:"Delay between Civitai requests" default:"1s" env:"SDHASHER_CIVITAI_DELAY"`
+	CivitaiNotFoundTTL time.Duration `long:"civitai-not-found-ttl" description:"How long the models not found on Civitai aren't looked up again, the time of the lookup is stored in their entries" default:"168h" env:"SDHASHER_CIVITAI_NOT_FOUND_TTL"`
+	CivitaiURL         string        `long:"civitai-url" description:"Civitai API base URL" default:"https://civitai.com" env:"SDHASHER_CIVITAI_URL"`
+	Prune              string        `long:"prune" description:"Remove the entries of missing files, use never when some model directories may be temporarily unavailable" choice:"auto" choice:"never" default:"auto" env:"SDHASHER_PRUNE"`
+	FixCase            bool          `long:"fix-case" description:"For the cache keys that differ only in case and point to the same file keep only the key with the case of the file name on disk, for case-insensitive filesystems" env:"SDHASHER_FIX_CASE"`
+	Force              bool          `long:"force" description:"Rehash all files regardless of their modification time" env:"SDHASHER_FORCE"`
+	MTimeMode          string        `long:"mtime" description:"How the modification time is stored and compared, exact matches the webui, margin adds a second and tolerates small differences" choice:"margin" choice:"exact" default:"margin" env:"SDHASHER_MTIME"`
+	Stdin              bool          `long:"stdin" description:"Hash the files listed on stdin instead of walking the models directories, the paths should be under them" env:"SDHASHER_STDIN"`
+	Null               bool          `short:"0" long:"null" description:"The file names on stdin are separated by NUL instead of newlines" env:"SDHASHER_NULL"`
+	DryRun             bool          `long:"dry-run" description:"Only report the files that would be hashed and the entries that would be removed" env:"SDHASHER_DRY_RUN"`
+	Verify             bool          `long:"verify" description:"Rehash the files from the input cache (or only the keys matching the glob arguments) and report mismatches" env:"SDHASHER_VERIFY"`
+	MMap               bool          `long:"mmap" description:"Map the files into memory instead of reading them" env:"SDHASHER_MMAP"`
+	BufferSize         byteSize      `long:"buffer-size" description:"Size of the read buffer of each hasher, the total for all hashers is capped at 1G" default:"1M" env:"SDHASHER_BUFFER_SIZE"`
+	NoPipeline         bool          `long:"no-pipeline" description:"Read and hash the file chunks one after another instead of reading the next chunk while hashing the previous one" env:"SDHASHER_NO_PIPELINE"`
+	DirectIO           bool          `long:"direct-io" description:"Read the files with O_DIRECT bypassing the page cache, Linux only" env:"SDHASHER_DIRECT_IO"`
+	MaxReadRate        byteSize      `long:"max-read-rate" description:"Limit the total read rate of all hashers in bytes per second, K, M, G and T suffixes are supported" env:"SDHASHER_MAX_READ_RATE"`
+	Nice               int           `long:"nice" description:"Lower the CPU priority of the process to this niceness, 1 to 19" env:"SDHASHER_NICE"`
+	IONice             string        `long:"ionice" description:"I/O scheduling class of the process, Linux only" choice:"idle" choice:"best-effort" env:"SDHASHER_IONICE"`
+	Retries            int           `long:"retries" description:"Number of times to retry hashing a file after a read error" default:"2" env:"SDHASHER_RETRIES"`
+	RetryDelay         time.Duration `long:"retry-delay" description:"Delay before the first retry, doubled after every attempt" default:"1s" env:"SDHASHER_RETRY_DELAY"`
+	MaxErrors          int           `long:"max-errors" description:"Stop hashing after this many files failed, 0 for no limit" env:"SDHASHER_MAX_ERRORS"`
+	ResumeDir          string        `long:"resume-dir" description:"Periodically save the hashing state of the big files to this directory so that the interrupted files continue from where they stopped on the next run, not used with --mmap" env:"SDHASHER_RESUME_DIR"`
+	ResumeEvery        byteSize      `long:"resume-every" description:"Save the hashing state after reading this much of a file" default:"1G" env:"SDHASHER_RESUME_EVERY"`
+	TUI                bool          `long:"tui" description:"Show the live view of the hashing in the terminal instead of the log, p pauses, s skips the file selected with j and k, q stops and saves the results" env:"SDHASHER_TUI"`
+	Progress           time.Duration `long:"progress" description:"Interval between progress reports, 0 to disable" default:"10s" env:"SDHASHER_PROGRESS"`
+	Autosave           time.Duration `long:"autosave" description:"Save the cache during hashing at this interval, 0 to disable" env:"SDHASHER_AUTOSAVE"`
+	AutosaveFiles      int           `long:"autosave-files" description:"Save the cache during hashing after this many files, 0 to disable" env:"SDHASHER_AUTOSAVE_FILES"`
+	OnConflict         string        `long:"on-conflict" description:"Entry to keep when the output cache was changed by another program during the run and both changed the same key" choice:"newer" choice:"ours" choice:"theirs" default:"newer" env:"SDHASHER_ON_CONFLICT"`
+	Backups            int           `long:"backups" description:"Number of timestamped backups of the previous output file to keep" env:"SDHASHER_BACKUPS"`
+	Delta              string        `long:"delta" description:"Also write the entries added or changed by the run to this cache file, it can be merged into the copies of the cache on other machines with the merge command, the removed entries aren't included" env:"SDHASHER_DELTA"`
+	AuditLog           string        `long:"audit-log" description:"Append a JSON line with the time and the keys added, rehashed (with the old and new sha256) and removed to this file after every run that changed the cache" env:"SDHASHER_AUDIT_LOG"`
+	SummaryJSON        string        `long:"summary-json" description:"Write the run summary as JSON to this file, - for stdout" env:"SDHASHER_SUMMARY_JSON"`
+	Stream             string        `long:"stream" description:"Append a JSON line with the key, path, sha256, size and duration (or the error) of every file to this file as soon as it is hashed, - for stdout" env:"SDHASHER_STREAM"`
+	Events             string        `long:"events" description:"Write the progress events as JSON lines to this file, - for stderr or unix:PATH for the socket the front-end listens on: scan_started, file_queued, file_done with the hash and the progress of the run, run_finished with the summary, file_failed and verify_finished when verifying" env:"SDHASHER_EVENTS"`
+	Duplicates         string        `long:"duplicates" description:"Write the report of the files with the same content to this file, - for stdout" env:"SDHASHER_DUPLICATES"`
+	LogLevel           string        `long:"log-level" description:"Minimum level of the log messages" choice:"debug" choice:"info" choice:"warn" choice:"error" default:"info" env:"SDHASHER_LOG_LEVEL"`
+	LogFormat          string        `long:"log-format" description:"Format of the log messages, journal is the text without the time and with the syslog priority prefixes, it's used instead of text when the output goes to journald" choice:"text" choice:"json" choice:"journal" default:"text" env:"SDHASHER_LOG_FORMAT"`
+	Color              string        `long:"color" description:"Color the log lines: the new files green, the changed files yellow, the errors red, the warnings purple and the debug lines such as the up to date files dim, auto colors only the terminal unless NO_COLOR is set" choice:"never" choice:"auto" choice:"always" default:"never" env:"SDHASHER_COLOR"`
+	Quiet              bool          `short:"q" long:"quiet" description:"Only log warnings and errors and print the summary if anything changed, for cron jobs" env:"SDHASHER_QUIET"`
+	MetricsListen      string        `long:"metrics-listen" description:"Serve the Prometheus metrics on this address at /metrics and the status dashboard at /, its rescan button works in watch mode" env:"SDHASHER_METRICS_LISTEN"`
+	Watch              bool          `long:"watch" description:"Keep running and update the cache when files in the models directory change" env:"SDHASHER_WATCH"`
+	WatchPoll          time.Duration `long:"watch-poll" description:"Rescan interval for the platforms without filesystem notifications" default:"1m" env:"SDHASHER_WATCH_POLL"`
+	Schedule           string        `long:"schedule" description:"Also rescan everything at the times of this cron expression such as '0 3 * * *' or @daily in watch mode and with the serve command, covering the files changed while it was not running" env:"SDHASHER_SCHEDULE"`
+	Interval           time.Duration `long:"interval" description:"Also rescan everything this often, such as 6h, in watch mode and with the serve command" env:"SDHASHER_INTERVAL"`
+
+	Exec           string        `long:"exec" description:"Run this shell command for every hashed file with SDHASHER_FILE_PATH, SDHASHER_FILE_KEY, SDHASHER_FILE_SHA256, SDHASHER_FILE_SIZE and SDHASHER_FILE_PREFIX set" env:"SDHASHER_EXEC"`
+	WebuiURL       string        `long:"webui-url" description:"URL of the running webui started with --api, its model lists are refreshed after new files are hashed" env:"SDHASHER_WEBUI_URL"`
+	WebuiAuth      string        `long:"webui-auth" description:"Credentials for the webui API as user:password, see its --api-auth option" env:"SDHASHER_WEBUI_AUTH"`
+	NotifyURL      string        `long:"notify-url" description:"POST the JSON summary and the new hashes to this URL when a run finishes" env:"SDHASHER_NOTIFY_URL"`
+	S3Endpoint     string        `long:"s3-endpoint" description:"Endpoint of the S3 compatible storage for the s3://bucket/prefix models directories and cache files, the credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY (default: AWS S3)" env:"SDHASHER_S3_ENDPOINT"`
+	S3Region       string        `long:"s3-region" description:"S3 region, AWS_REGION or us-east-1 if not set" env:"SDHASHER_S3_REGION"`
+	S3Concurrency  int           `long:"s3-concurrency" description:"Number of the parts of an S3 object downloaded in parallel" default:"4" env:"SDHASHER_S3_CONCURRENCY"`
+	S3PartSize     byteSize      `long:"s3-part-size" description:"Size of the parts of an S3 object downloaded in parallel" default:"16M" env:"SDHASHER_S3_PART_SIZE"`
+	SSHConnections int           `long:"ssh-connections" description:"Number of the files streamed at the same time from each ssh:// or sftp://[user@]host[:port]/path models directory, the ssh:// hosts need a shell with GNU find and tail, sftp:// works with the SFTP-only accounts" default:"4" env:"SDHASHER_SSH_CONNECTIONS"`
+	SSHCommand     string        `long:"ssh-command" description:"SSH client used for the ssh:// and sftp:// models directories" default:"ssh" env:"SDHASHER_SSH_COMMAND"`
+	Agents         []string      `long:"agent" description:"URL of an sdhasher agent started with the agent command on another machine to send some of the files to, can be repeated, the agent finds the files by the cache keys in its own models directories" env:"SDHASHER_AGENT" env-delim:","`
+	AgentToken     string        `long:"agent-token" description:"Bearer token sent to the agents started with --token" env:"SDHASHER_AGENT_TOKEN"`
+	AgentJobs      int           `long:"agent-jobs" description:"Number of the files hashed at the same time by each agent" default:"2" env:"SDHASHER_AGENT_JOBS"`
+	HTTPProxy      string        `long:"http-proxy" description:"Proxy URL for remote requests" env:"SDHASHER_HTTP_PROXY"`
+	HTTPHeaders    []string      `long:"http-header" description:"Extra header for the remote requests to the host in the \"host=Name: value\" form, can be repeated, the headers set by sdhasher itself aren't replaced" env:"SDHASHER_HTTP_HEADER" env-delim:","`
+	HTTPTimeout    time.Duration `long:"http-timeout" description:"Timeout for connecting to the remote servers and waiting for their responses, the downloads themselves aren't limited" env:"SDHASHER_HTTP_TIMEOUT"`
+	HTTPInsecure   bool          `long:"http-insecure" description:"Don't verify TLS certificates of remote servers" env:"SDHASHER_HTTP_INSECURE"`
+}
+
+type task struct {
+	path string
+	key  string
+	size int64
+	d    fs.DirEntry
+	// opts are set for the tasks received from a coordinator, the local options are used otherwise
+	opts *hashOptions
+	// rehash is set for the files that were in the cache
+	rehash bool
 }
 
-type entry struct {
-	MTime  MTime  `json:"mtime"`
-	SHA256 string `json:"sha256"`
+func newTask(path, key string, d fs.DirEntry) *task {
+	t := &task{path: path, key: key, d: d}
+	if info, err := d.Info(); err == nil {
+		t.size = info.Size()
+	}
+	return t
+}
+
+type root struct {
 	path   string
+	prefix string
+	// fsys is the storage of the files, for the remote storage URLs it's set after listing the remote
+	fsys storage
+	// remote is set for the remote storage URLs
+	remote remoteFS
 }
 
-type cache struct {
-	Hashes       map[string]entry `json:"hashes"`
-	HashesAddnet map[string]entry `json:"hashes-addnet,omitempty"`
+var roots []root
+
+// scanState guards the storages of the roots and the name index replaced by the scan, serve uses the lock of its cache
+// so that the HTTP API doesn't see them changing
+var scanState sync.Locker = &sync.Mutex{}
+
+var extensions = map[string]struct{}{}
+
+func setupExtensions() {
+	for _, e := range params.Extensions {
+		for _, ext := range strings.Split(e, ",") {
+			ext = strings.ToLower(strings.TrimSpace(ext))
+			if ext == "" {
+				continue
+			}
+			if !strings.HasPrefix(ext, ".") {
+				ext = "." + ext
+			}
+			extensions[ext] = struct{}{}
+		}
+	}
 }
 
-type task struct {
-	path string
-	d    fs.DirEntry
+// baseDirs are the directories given on the command line
+var baseDirs []string
+
+func normalizePrefix(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+func setupRoots() {
+	params.Prefix = normalizePrefix(params.Prefix)
+	for _, p := range params.Paths {
+		path, prefix := p, params.Prefix
+		explicit := false
+		if i := strings.LastIndex(p, "="); i >= 0 {
+			path, prefix = p[:i], normalizePrefix(p[i+1:])
+			explicit = true
+		}
+		if isRemote(path) {
+			path = strings.TrimSuffix(path, "/")
+			scheme, _, _ := strings.Cut(path, "://")
+			remote, err := remoteSchemes[scheme](path)
+			if err != nil {
+				fatal("Error opening remote models directory", "path", path, "error", err)
+			}
+			path = withoutPassword(path)
+			baseDirs = append(baseDirs, path)
+			roots = append(roots, root{path: path, prefix: prefix, remote: remote})
+			continue
+		}
+		path = longPath(path)
+		baseDirs = append(baseDirs, path)
+		roots = append(roots, root{path: path, prefix: prefix, fsys: localStorage{dir: path}})
+		if !explicit {
+			setupLayout(path, prefix)
+		}
+	}
+}
+
+// setupLayout adds the webui model type directories found in the path as separate roots
+func setupLayout(path, defaultPrefix string) {
+	dirs, err := os.ReadDir(path)
+	if err != nil {
+		if params.AutoLayout {
+			fatal("Error reading models directory", "path", path, "error", err)
+		}
+		return
+	}
+	var unknown []string
+	found := 0
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		prefix, ok := sdhasher.LayoutPrefix(d.Name())
+		if !ok {
+			unknown = append(unknown, d.Name())
+			continue
+		}
+		dir := filepath.Join(path, d.Name())
+		roots = append(roots, root{path: dir, prefix: prefix, fsys: localStorage{dir: dir}})
+		found++
+	}
+	if found == 0 {
+		return
+	}
+	if !params.AutoLayout {
+		slog.Info("Detected webui models directory layout", "path", path)
+	}
+	for _, name := range unknown {
+		slog.Warn("Unrecognized directory, using default prefix", "path", filepath.Join(path, name),
+			"prefix", defaultPrefix)
+	}
 }
 
-type MTime float64
+// normalizeKeys moves the entries with the backslash separated keys written by the older versions on Windows to the
+// forward slash keys
+func normalizeKeys(c *sdhasher.Cache) {
+	if filepath.Separator != '\\' {
+		return
+	}
+	for _, k := range sortedKeys(c.Hashes) {
+		if key := filepath.ToSlash(k); key != k {
+			slog.Info("Normalized key", "key", k, "new_key", key)
+			c.Rename(k, key)
+		}
+	}
+}
 
-func (m MTime) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("%.7f", m)), nil
+// rootFor returns the most specific root containing the path
+func rootFor(path string) *root {
+	var result *root
+	for i := range roots {
+		r := &roots[i]
+		if r.remote != nil || isRemote(path) {
+			if r.remote == nil || path != r.path && !strings.HasPrefix(path, r.path+"/") {
+				continue
+			}
+		} else if rel, err := filepath.Rel(r.path, path); err != nil || rel == ".." ||
+			strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if result == nil || len(r.path) > len(result.path) {
+			result = r
+		}
+	}
+	return result
+}
+
+func keyFor(path string) (string, error) {
+	r := rootFor(path)
+	if r == nil {
+		return "", fmt.Errorf("%s is outside of the models directory", path)
+	}
+	if r.isNamed() {
+		return sdhasher.Key(r.prefix, r.rel(path)), nil
+	}
+	// the webui uses forward slashes on all platforms
+	return r.prefix + r.rel(path), nil
 }
 
-func worker(t task) (*entry, error) {
+// pathsFor returns the candidate file paths for the cache key, one for each root with a matching prefix
+func pathsFor(key string) []string {
+	var result []string
+	for i := range roots {
+		r := &roots[i]
+		if !strings.HasPrefix(key, r.prefix) {
+			continue
+		}
+		if r.isNamed() {
+			if path, ok := namedPath(r, strings.TrimPrefix(key, r.prefix)); ok {
+				result = append(result, path)
+			}
+			continue
+		}
+		if r.remote != nil {
+			// the entries of the roots that couldn't be listed are kept as is
+			path := r.path + "/" + strings.TrimPrefix(key, r.prefix)
+			if r.fsys != nil && rootFor(path).path == r.path {
+				result = append(result, path)
+			}
+			continue
+		}
+		path := filepath.Join(r.path, filepath.FromSlash(strings.TrimPrefix(key, r.prefix)))
+		if k, err := keyFor(path); err == nil && k == key {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// hashFunc hashes the file of the task, id is the worker number used in the log
+type hashFunc func(ctx context.Context, id int, t task) (*sdhasher.Entry, error)
+
+// worker hashes the file of the task locally
+func worker(ctx context.Context, id int, t task) (*sdhasher.Entry, error) {
+	started := time.Now()
 	info, err := t.d.Info()
-	mtime := float64(0)
 	if err != nil {
-		log.Printf("Error getting info for %s: %s", t.path, err)
+		slog.Error("Error getting file info", "path", t.path, "worker", id, "error", err)
 		return nil, err
-	} else {
-		mtime = float64(info.ModTime().UnixNano())/1e9 + 1 // add one second margin because floats suck
 	}
-	log.Printf("Hashing %s", t.path)
-	buf := [16384]byte{}
-	h := sha256.New()
-	h.Reset()
-	f, err := os.Open(t.path)
+	slog.Info("Hashing", "path", t.path, "bytes", t.size, "worker", id)
+	opts := t.opts
+	if opts == nil {
+		opts = optionsFor(t.path)
+	}
+	h := opts.hasher()
+	w, err := h.Digest(t.path, opts.Addnet)
 	if err != nil {
-		log.Printf("Error opening %s: %s", t.path, err)
 		return nil, err
 	}
-	defer f.Close()
-	n := 1
-	for n > 0 {
-		n, err = f.Read(buf[:])
-		if n != 0 && err != nil {
-			log.Printf("Error reading %s: %s", t.path, err)
+	var dst io.Writer = w
+	if ui != nil {
+		dst = uiWriter{w, id}
+	}
+	var bufs [][]byte
+	for i := 0; i < buffersPerHasher(); i++ {
+		bufp := getBuffer()
+		defer putBuffer(bufp)
+		bufs = append(bufs, *bufp)
+	}
+	fsys, name, err := storageFor(t.path)
+	if err != nil {
+		slog.Error("Error opening file", "path", t.path, "worker", id, "error", err)
+		return nil, err
+	}
+	// src is left nil when the file is mapped and already hashed
+	var src io.Reader
+	var offset int64
+	if _, local := fsys.(localStorage); local && params.MMap {
+		f, err := openFile(t.path)
+		if err != nil {
+			slog.Error("Error opening file", "path", t.path, "worker", id, "error", err)
 			return nil, err
 		}
-		_, err := h.Write(buf[:n])
+		defer f.Close()
+		if data, unmap, mapped := mapFile(f, info.Size()); mapped {
+			defer unmap()
+			if err := hashMapped(data, len(bufs[0]), dst); err != nil {
+				slog.Error("Error reading file", "path", t.path, "worker", id, "error", err)
+				return nil, err
+			}
+		} else {
+			src = f
+		}
+	} else {
+		offset = loadResume(t.path, info, w)
+		body, err := fsys.OpenAt(ctx, name, offset)
 		if err != nil {
-			log.Printf("Error hashing %s: %s", t.path, err)
+			slog.Error("Error opening file", "path", t.path, "worker", id, "error", err)
 			return nil, err
 		}
+		defer body.Close()
+		src = body
+	}
+	if src != nil {
+		if offset > 0 {
+			slog.Info("Resuming", "path", t.path, "offset", offset, "worker", id)
+		}
+		saved := offset
+		err := sdhasher.ReadChunks(throttledReader{src}, bufs, func(p []byte) error {
+			if _, err := dst.Write(p); err != nil {
+				return err
+			}
+			offset += int64(len(p))
+			if params.ResumeDir != "" && offset-saved >= int64(params.ResumeEvery) {
+				saveResume(t.path, info, offset, w)
+				saved = offset
+			}
+			return nil
+		})
+		if err != nil {
+			slog.Error("Error reading file", "path", t.path, "worker", id, "error", err)
+			return nil, err
+		}
+		removeResume(t.path)
+	}
+	result, err := h.Entry(w, t.path, info)
+	if err != nil {
+		slog.Warn("Error reading metadata", "path", t.path, "worker", id, "error", err)
 	}
-	hash := h.Sum(nil)
-	return &entry{MTime: MTime(mtime), SHA256: fmt.Sprintf("%x", hash), path: t.path}, nil
+	if opts.ModelType {
+		warnModelType(t.key, t.path, result.Extra[sdhasher.ModelTypeField])
+	}
+	result.Key = t.key
+	args := []any{"path", t.path, "sha256", result.SHA256, "bytes", result.Size, "duration", time.Since(started),
+		"worker", id}
+	if t.rehash {
+		args = append(args, "rehash", true)
+	}
+	slog.Info("Done", args...)
+	return result, nil
 }
 
-func main() {
-	_, err := flags.Parse(&params)
-	if err != nil {
-		os.Exit(1)
+// hashWithRetries hashes the file retrying after the errors with exponential backoff, the files that needed retrying
+// are counted in retried
+func hashWithRetries(ctx context.Context, id int, t task, hash hashFunc,
+	retried *atomic.Int64) (*sdhasher.Entry, error) {
+	delay := params.RetryDelay
+	for attempt := 1; ; attempt++ {
+		e, err := hash(ctx, id, t)
+		if attempt == 2 {
+			retried.Add(1)
+		}
+		// missing and inaccessible files won't appear by retrying
+		if err == nil || attempt > params.Retries || errors.Is(err, fs.ErrNotExist) ||
+			errors.Is(err, fs.ErrPermission) || errors.Is(err, errSkipped) {
+			return e, err
+		}
+		slog.Warn("Retrying file", "path", t.path, "attempt", attempt, "delay", delay, "worker", id)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(delay):
+		}
+		delay *= 2
 	}
-	result := cache{Hashes: map[string]entry{}}
-	if params.Input != "" {
-		inf, err := os.Open(params.Input)
-		if err != nil {
-			log.Fatalf("Error opening cache file: %s", err)
+}
+
+// hashMapped feeds the mapped file to the hashers in chunks, a fault caused by the file being truncated while it's
+// mapped is returned as an error
+func hashMapped(data []byte, chunk int, w io.Writer) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("error accessing mapped file: %v", r)
 		}
-		err = json.NewDecoder(inf).Decode(&result)
-		inf.Close()
-		if err != nil {
-			log.Fatalf("Error reading cache: %s", err)
+	}()
+	for len(data) > 0 {
+		n := min(chunk, len(data))
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
 		}
+		progress.read(n)
+		readLimiter.wait(n)
+		data = data[n:]
+	}
+	return nil
+}
+
+// mtimeMode returns how the modification times are stored and compared with --mtime
+func mtimeMode() sdhasher.MTimeMode {
+	if params.MTimeMode == "exact" {
+		return sdhasher.ExactMTime
+	}
+	return sdhasher.MarginMTime
+}
+
+func wantMetadata(path string) bool {
+	return params.Metadata && strings.ToLower(filepath.Ext(path)) == ".safetensors"
+}
+
+func wantKohya(path string) bool {
+	return params.Kohya && strings.ToLower(filepath.Ext(path)) == ".safetensors"
+}
+
+func wantModelType(path string) bool {
+	return params.ModelType && strings.ToLower(filepath.Ext(path)) == ".safetensors"
+}
+
+func wantGGUF(path string) bool {
+	return params.GGUF && strings.ToLower(filepath.Ext(path)) == ".gguf"
+}
+
+// wantAddnet reports whether the Additional Networks hash of the file is needed, the webui looks up the LoRA models
+// only by it so it's always computed for them
+func wantAddnet(path string) bool {
+	prefix := ""
+	if r := rootFor(path); r != nil {
+		prefix = r.prefix
 	}
-	log.Printf("Processing %s", params.Path)
+	return sdhasher.WantAddnet(prefix, path, params.Addnet)
+}
+
+// moveOrphans moves the entries of missing files to the keys of the new files with the same size and modification time
+// so that the moved files aren't hashed again, it returns the tasks left to hash and the number of moved entries
+func moveOrphans(c *sdhasher.Cache, orphans map[string]string, tasks []*task) ([]*task, int) {
+	if len(orphans) == 0 {
+		return tasks, 0
+	}
+	type fileID struct {
+		size  int64
+		mtime sdhasher.MTime
+	}
+	// the files with the same size and time are ambiguous and hashed as usual
+	candidates := map[fileID][]int{}
+	for i, t := range tasks {
+		if t.rehash {
+			continue
+		}
+		if fi, err := t.d.Info(); err == nil {
+			id := fileID{fi.Size(), mtimeMode().FileMTime(fi)}
+			candidates[id] = append(candidates[id], i)
+		}
+	}
+	moved := map[int]bool{}
+	for k := range orphans {
+		e := c.Hashes[k]
+		found := candidates[fileID{e.Size, e.MTime}]
+		if e.Size == 0 || len(found) != 1 || moved[found[0]] {
+			continue
+		}
+		t := tasks[found[0]]
+		slog.Info("File moved", "key", k, "new_key", t.key, "path", t.path, "dry_run", params.DryRun)
+		moved[found[0]] = true
+		delete(orphans, k)
+		if !params.DryRun {
+			c.Rename(k, t.key)
+		}
+	}
+	result := tasks[:0:0]
+	for i, t := range tasks {
+		if !moved[i] {
+			result = append(result, t)
+		}
+	}
+	return result, len(moved)
+}
+
+// repairKeys moves the entries of missing files to the keys of existing files with the same hash, the rest are removed
+func repairKeys(c *sdhasher.Cache, orphans map[string]string) int {
+	if len(orphans) == 0 {
+		return 0
+	}
+	byHash := map[string]string{}
+	for k, e := range c.Hashes {
+		if _, ok := orphans[k]; ok || len(pathsFor(k)) == 0 {
+			continue
+		}
+		byHash[e.SHA256] = k
+	}
+	changes := 0
+	for k, modelPath := range orphans {
+		if newKey, ok := byHash[c.Hashes[k].SHA256]; ok {
+			slog.Info("Repaired key", "key", k, "new_key", newKey)
+		} else if params.Prune == "never" {
+			// it's repeated on every run so it doesn't break --quiet
+			slog.Info("File not found, keeping cache entry", "path", modelPath, "key", k)
+			continue
+		} else {
+			slog.Warn("File not found, removing cache entry", "path", modelPath, "key", k)
+		}
+		c.Remove(k)
+		changes++
+	}
+	return changes
+}
+
+func totalSize(tasks []*task) (result int64) {
+	for _, t := range tasks {
+		result += t.size
+	}
+	return
+}
+
+// autosaveClock tells when the cache is due to be saved with --autosave and --autosave-files
+type autosaveClock struct {
+	last    time.Time
+	unsaved int
+}
+
+// done counts the hashed file and reports whether the cache is due to be saved at now, the count and the interval
+// restart when it is
+func (c *autosaveClock) done(now time.Time) bool {
+	c.unsaved++
+	due := params.AutosaveFiles > 0 && c.unsaved >= params.AutosaveFiles ||
+		params.Autosave > 0 && now.Sub(c.last) >= params.Autosave
+	if due {
+		c.last = now
+		c.unsaved = 0
+	}
+	return due
+}
+
+// hashTasks runs the tasks on the hashing workers largest first and returns the results of the successful ones,
+// autosave is called with the results so far after every file with --store, due is set when it's time to save the cache
+func hashTasks(ctx context.Context, tasks []*task,
+	autosave func(hashed []*sdhasher.Entry, due bool)) []*sdhasher.Entry {
+	// the biggest files go first so that the workers don't wait for a single big file at the end
+	tasks = append([]*task(nil), tasks...)
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].size > tasks[j].size })
+	progress.start(tasks)
+	defer progress.stop()
+	events.queued(tasks)
+	metrics.queueDepth.Store(int64(len(tasks)))
+	defer metrics.queueDepth.Store(0)
 	taskChan := make(chan *task, 100)
-	resultChan := make(chan *entry, 100)
+	resultChan := make(chan *sdhasher.Entry, 100)
 	wg := sync.WaitGroup{}
 	wgResult := sync.WaitGroup{}
-	if params.MaxHashers == 0 {
-		params.MaxHashers = runtime.NumCPU()
+	started := time.Now()
+	// the agents take the tasks from the same queue after the local hashers
+	hashers := make([]hashFunc, params.MaxHashers)
+	for i := range hashers {
+		hashers[i] = worker
+	}
+	for _, a := range agents {
+		for i := 0; i < params.AgentJobs; i++ {
+			hashers = append(hashers, a.hash)
+		}
 	}
-	for i := 0; i < params.MaxHashers; i++ {
+	busy := make([]time.Duration, len(hashers))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	startTUI(ctx, tasks, len(hashers))
+	var errorCount, retried atomic.Int64
+	for i, hash := range hashers {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
 			for t := range taskChan {
-				e, err := worker(*t)
+				if ctx.Err() != nil {
+					continue
+				}
+				taskStarted := time.Now()
+				ui.started(i, t)
+				e, err := hashWithRetries(ctx, i, *t, hash, &retried)
+				ui.finished(i)
+				stream.write(t, e, time.Since(taskStarted), err)
+				busy[i] += time.Since(taskStarted)
+				metrics.addBusy(i, time.Since(taskStarted))
+				metrics.queueDepth.Add(-1)
+				progress.fileDone()
+				events.fileDone(t, e, time.Since(taskStarted), err)
 				if err == nil {
+					metrics.filesHashed.Add(1)
 					resultChan <- e
+				} else {
+					metrics.errors.Add(1)
+					if n := errorCount.Add(1); params.MaxErrors > 0 && n == int64(params.MaxErrors) {
+						slog.Error("Too many errors, stopping", "errors", n)
+						cancel()
+					}
 				}
 			}
-		}()
+		}(i)
 	}
+	var hashed []*sdhasher.Entry
 	wgResult.Add(1)
 	go func() {
 		defer wgResult.Done()
+		clock := autosaveClock{last: time.Now()}
 		for e := range resultChan {
-			rel, err := filepath.Rel(params.Path, e.path)
-			if err != nil {
-				log.Printf("Error getting relative path: %s", err)
-				continue
+			hashed = append(hashed, e)
+			due := clock.done(time.Now())
+			// the store is updated after every file
+			if autosave != nil && (due || db != nil) {
+				autosave(hashed, due)
 			}
-			log.Printf("Done: %s | %s", e.path, e.SHA256)
-			rel = "checkpoint/" + rel
-			result.Hashes[rel] = *e
 		}
 	}()
+	for _, t := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		taskChan <- t
+		ui.dispatched()
+	}
+	close(taskChan)
+	wg.Wait()
+	ui.stop()
+	close(resultChan)
+	wgResult.Wait()
+	stats.hashingTime = time.Since(started)
+	stats.workerBusy = busy
+	stats.Errors = int(errorCount.Load())
+	stats.Retried = int(retried.Load())
+	stats.BytesRead = progress.doneBytes.Load()
+	return hashed
+}
+
+// statKey finds the file for the cache key, the path is empty if the key doesn't belong to any root
+func statKey(key string) (modelPath string, fi fs.FileInfo, err error) {
+	for _, modelPath = range pathsFor(key) {
+		var fsys storage
+		var name string
+		if fsys, name, err = storageFor(modelPath); err == nil {
+			fi, err = fs.Stat(fsys, name)
+		}
+		if err == nil {
+			break
+		}
+	}
+	return
+}
+
+// scan hashes new and changed files and removes the entries of missing files, it returns the number of changed entries
+func scan(ctx context.Context, result *sdhasher.Cache) int {
+	slog.Info("Processing", "paths", baseDirs)
+	dashboard.scanning.Store(true)
+	sdNotify("STATUS=Scanning")
+	defer dashboard.scanning.Store(false)
+	listRemotes(ctx)
+	stats = runStats{started: time.Now()}
+	events.scanStarted()
+	var tasks []*task
+	changes := renameNamedKeys(result)
 	knownFiles := map[string]struct{}{}
+	orphans := map[string]string{}
 	for p, e := range result.Hashes {
-		if !strings.HasPrefix(p, "checkpoint/") {
+		modelPath, fi, err := statKey(p)
+		if modelPath == "" {
 			continue
 		}
-		modelPath := filepath.Join(params.Path, strings.TrimPrefix(p, "checkpoint/"))
-		fi, err := os.Stat(modelPath)
 		if err != nil {
-			log.Printf("Error accessing file %s: %s, removing cache entry", modelPath, err)
-			delete(result.Hashes, p)
+			if params.RepairKeys {
+				orphans[p] = modelPath
+				continue
+			}
+			if params.Prune == "never" {
+				slog.Info("Error accessing file, keeping cache entry", "path", modelPath, "key", p, "error", err)
+				continue
+			}
+			slog.Warn("Error accessing file, removing cache entry", "path", modelPath, "key", p, "error", err)
+			result.Remove(p)
+			changes++
 			continue
 		}
-		if fi.ModTime().Sub(time.Unix(int64(e.MTime), 0)) > time.Second*2 {
-			log.Printf("File %s changed, rehashing...", modelPath)
-			taskChan <- &task{path: modelPath, d: fs.FileInfoToDirEntry(fi)}
+		want := optionsFor(modelPath).wanted()
+		if params.Force {
+			tasks = append(tasks, newTask(modelPath, p, fs.FileInfoToDirEntry(fi)))
+		} else if reason := result.Outdated(p, fi, want); reason != "" {
+			slog.Info(reason+", rehashing", "path", modelPath)
+			tasks = append(tasks, newTask(modelPath, p, fs.FileInfoToDirEntry(fi)))
+		} else {
+			slog.Debug("File is up to date", "path", modelPath)
+			stats.UpToDate++
+		}
+		if e.Size == 0 && fi.Size() != 0 {
+			e.Size = fi.Size()
+			result.Hashes[p] = e
 		}
 		knownFiles[modelPath] = struct{}{}
 	}
-	filepath.WalkDir(params.Path, func(path string, d fs.DirEntry, err error) error {
-		if d != nil && d.IsDir() {
-			return nil
+	rehashed := len(tasks)
+	for _, t := range tasks {
+		t.rehash = true
+	}
+	pruned := changes
+	visited := map[string]bool{}
+	for _, dir := range baseDirs {
+		r := rootFor(dir)
+		if r.fsys == nil {
+			continue
 		}
-		if err != nil {
-			log.Printf("Error visiting %s: %s", path, err)
-			return nil
+		opts := sdhasher.WalkOptions{Excludes: params.Excludes, SkipSymlinks: params.Symlinks == "skip",
+			Visited: visited}
+		if r.remote == nil {
+			// the storage follows the links when walking them
+			opts.Resolve = func(name string) (string, error) { return filepath.EvalSymlinks(r.join(name)) }
 		}
-		ext := strings.ToLower(filepath.Ext(path))
-		if ext != ".safetensors" && ext != ".ckpt" {
+		sdhasher.Walk(r.fsys, opts, func(name string, d fs.DirEntry, err error) error {
+			path := r.join(name)
+			if d != nil && d.IsDir() {
+				return nil
+			}
+			if err != nil {
+				slog.Error("Error visiting path", "path", path, "error", err)
+				return nil
+			}
+			fr := rootFor(path)
+			if !wantFile(fr, fr.rel(path)) {
+				return nil
+			}
+			if fr.isNamed() {
+				// the webui loads only one of the models with the same name
+				if p, _ := namedPath(fr, sdhasher.ModelName(fr.rel(path))); p != path {
+					return nil
+				}
+			}
+			if _, ok := knownFiles[path]; !ok {
+				key, err := keyFor(path)
+				if err != nil {
+					slog.Error("Error getting relative path", "path", path, "error", err)
+					return nil
+				}
+				t := newTask(path, key, d)
+				if t.size < int64(params.MinSize) || params.MaxSize > 0 && t.size > int64(params.MaxSize) {
+					return nil
+				}
+				if params.DryRun {
+					slog.Info("New file", "path", path, "bytes", t.size)
+				}
+				tasks = append(tasks, t)
+				knownFiles[path] = struct{}{}
+			}
 			return nil
+		})
+	}
+	tasks, moved := moveOrphans(result, orphans, tasks)
+	changes += moved
+	if params.DryRun {
+		slog.Info("Plan", "new", len(tasks)-rehashed, "new_bytes", totalSize(tasks[rehashed:]), "rehash", rehashed,
+			"rehash_bytes", totalSize(tasks[:rehashed]), "remove", pruned, "move", moved, "repair", len(orphans))
+		return 0
+	}
+	hashed := hashFiles(ctx, tasks, autosaver(result))
+	applyHashed(result, hashed)
+	changes += len(hashed)
+	repaired := repairKeys(result, orphans)
+	fixed := caseCollisions(result)
+	changes += repaired + fixed
+	stats.Hashed = len(hashed)
+	stats.hashed = hashed
+	runHooks(ctx, hashed)
+	stats.Pruned = pruned + repaired + fixed
+	stats.finish()
+	if params.AutoV2 {
+		// the entries cached without --autov2 get it too
+		sdhasher.AddAutoV2(result.Hashes)
+	}
+	return changes
+}
+
+// applyHashed stores the hashing results in the cache together with their AutoV2 hashes so that the autosaved and the
+// partial caches have them too
+func applyHashed(c *sdhasher.Cache, hashed []*sdhasher.Entry) {
+	if params.AutoV2 {
+		for _, e := range hashed {
+			sdhasher.SetAutoV2(e)
+		}
+	}
+	c.Apply(hashed)
+}
+
+// autosaver returns the function that saves the cache with the results hashed so far, the store gets every file as
+// soon as it's hashed
+func autosaver(result *sdhasher.Cache) func(hashed []*sdhasher.Entry, due bool) {
+	return func(hashed []*sdhasher.Entry, due bool) {
+		if db != nil {
+			if err := db.saveHashed(*result, hashed[len(hashed)-1:]); err != nil {
+				slog.Error("Error saving hashed file", "path", hashed[len(hashed)-1].Path, "error", err)
+			}
 		}
-		if _, ok := knownFiles[path]; !ok {
-			taskChan <- &task{path: path, d: d}
+		if !due || params.Output == "" || params.Output == "-" {
+			return // the cache can be written to stdout only once
 		}
+		snapshot := result.Clone()
+		applyHashed(&snapshot, hashed)
+		if err := writeCache(snapshot); err != nil {
+			slog.Error("Error autosaving cache", "error", err)
+			return
+		}
+		slog.Info("Autosaved cache", "hashed", len(hashed))
+	}
+}
+
+// postScan runs the optional actions that need the updated cache
+func postScan(ctx context.Context, result sdhasher.Cache) {
+	// the models not found on Civitai are marked in the cache saved before
+	if (params.Civitai || params.CivitaiPreview) && civitaiActions(ctx, result) > 0 &&
+		(params.Output != "" || db != nil) && params.Output != "-" {
+		if err := writeCache(result); err != nil {
+			slog.Error("Error writing cache", "error", err)
+		}
+	}
+	if params.Duplicates != "" {
+		reportDuplicates(result)
+	}
+}
+
+// backedUp is set after the previous cache has been backed up so that autosaves don't rotate the backups out
+var backedUp bool
+
+const backupTimeFormat = "20060102-150405"
+
+// backupCache copies the current output file to a timestamped backup and removes the oldest backups over the limit
+func backupCache() error {
+	src, err := os.Open(params.Output)
+	if errors.Is(err, fs.ErrNotExist) {
 		return nil
-	})
-	close(taskChan)
-	wg.Wait()
-	close(resultChan)
-	wgResult.Wait()
-	f, err := os.Create(params.Output)
+	}
 	if err != nil {
-		log.Fatalf("Error creating output file %s: %s", params.Output, err)
+		return err
 	}
-	defer f.Close()
-	enc := json.NewEncoder(f)
+	defer src.Close()
+	dst, err := os.Create(params.Output + "." + time.Now().Format(backupTimeFormat) + ".bak")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	backups, err := filepath.Glob(params.Output + ".*.bak")
+	if err != nil {
+		return err
+	}
+	sort.Strings(backups)
+	for len(backups) > params.Backups {
+		slog.Info("Removing old backup", "path", backups[0])
+		if err := os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// encodeCache writes the indented JSON of the cache compressed as the file at the path should be
+func encodeCache(w io.Writer, path string, result sdhasher.Cache) error {
+	cw, err := compressor(w, compressionFor(path))
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(cw)
 	enc.SetIndent("", "    ")
-	err = enc.Encode(result)
+	if err := enc.Encode(result); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+// writeDelta writes the entries added or changed since the original cache to --delta
+func writeDelta(original, result sdhasher.Cache) {
+	if params.Delta == "" {
+		return
+	}
+	delta := sdhasher.Delta(original, result)
+	if err := saveCache(params.Delta, delta); err != nil {
+		slog.Error("Error writing delta", "path", params.Delta, "error", err)
+		return
+	}
+	slog.Info("Delta written", "path", params.Delta, "entries", len(delta.Hashes))
+}
+
+// writeCache writes the result to the store and the output, merging it with the changes made to the output file by
+// other programs
+func writeCache(result sdhasher.Cache) error {
+	merged := output.rebase(result)
+	if err := saveStore(merged); err != nil {
+		return err
+	}
+	if params.Output == "" {
+		return nil
+	}
+	if err := saveCache(params.Output, merged); err != nil {
+		return err
+	}
+	output.saved(result, merged)
+	return nil
+}
+
+// saveCache writes the cache to the path atomically, the previous output file is backed up the first time
+func saveCache(path string, result sdhasher.Cache) error {
+	if path == "-" {
+		if err := encodeCache(os.Stdout, path, result); err != nil {
+			return fmt.Errorf("error writing result to stdout: %w", err)
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := encodeCache(&buf, path, result); err != nil {
+		return fmt.Errorf("error encoding result: %w", err)
+	}
+	if isS3(path) {
+		return writeS3(path, buf.Bytes())
+	}
+	// the encoding is deterministic, leaving the same file untouched keeps its mtime for the tools syncing it
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, buf.Bytes()) {
+		slog.Debug("Cache is unchanged", "path", path)
+		return nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
 	if err != nil {
-		log.Fatalf("Error encoding result: %s", err)
+		return fmt.Errorf("error creating temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(f.Name())
+	mode := fs.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	f.Chmod(mode)
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing %s: %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing %s: %w", f.Name(), err)
+	}
+	if path == params.Output && !backedUp && params.Backups > 0 {
+		if err := backupCache(); err != nil {
+			slog.Error("Error backing up cache", "path", path, "error", err)
+		}
+		backedUp = true
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error renaming %s to %s: %w", f.Name(), path, err)
+	}
+	return nil
+}
+
+// cacheLocation returns the cache file of the UI installation if the path is its directory, SD.Next keeps it in the
+// data directory
+func cacheLocation(path string) string {
+	if path == "" || path == "-" || isURL(path) {
+		return path
+	}
+	if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+		return path
+	}
+	if params.UI == "sdnext" {
+		return filepath.Join(path, "data", "cache.json")
+	}
+	return filepath.Join(path, "cache.json")
+}
+
+// statCache checks that the cache file exists
+func statCache(path string) error {
+	if isS3(path) {
+		return statS3(path)
+	}
+	_, err := os.Stat(path)
+	return err
+}
+
+// openCache opens the cache file, URL or S3 object, the compressed caches are decompressed
+func openCache(path string) (io.ReadCloser, error) {
+	var f io.ReadCloser
+	var err error
+	switch {
+	case isURL(path):
+		f, err = fetchCache(path)
+	case isS3(path):
+		f, err = readS3(path)
+	default:
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decompress(f)
+}
+
+func readCache(path string) (sdhasher.Cache, error) {
+	var result sdhasher.Cache
+	f, err := openCache(path)
+	if err != nil {
+		return result, err
+	}
+	defer f.Close()
+	if !params.Lenient {
+		err = json.NewDecoder(f).Decode(&result)
+		return result, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return result, err
+	}
+	result, problems, err := sdhasher.Validate(data)
+	for _, p := range problems {
+		slog.Warn("Invalid cache entry", "path", path, "problem", p.String())
+	}
+	return result, err
+}
+
+// stdoutConflict reports whether the cache is written to stdout together with another output or more than once
+func stdoutConflict() bool {
+	return params.Output == "-" &&
+		(params.Watch || params.SummaryJSON == "-" || params.Stream == "-" || params.Duplicates == "-")
+}
+
+func main() {
+	parser := newParser()
+	readConfig(parser)
+	args, err := parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	command := "hash"
+	if parser.Active != nil {
+		command = parser.Active.Name
+	}
+	if params.Version {
+		command = "version"
+	}
+	if command == "verify" {
+		params.Verify = true
+	}
+	setupLogging()
+	if err := setupHTTPClient(); err != nil {
+		fatal("Error configuring HTTP client", "error", err)
+	}
+	switch command {
+	case "merge":
+		mergeCaches(args)
+		return
+	case "diff":
+		diffCaches(args)
+		return
+	case "completion":
+		printCompletion(parser.Name, args)
+		return
+	case "version":
+		printVersion(parser.Name)
+		return
+	case "service":
+		if manageService(args) {
+			return
+		}
+		command = "hash"
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	tuiAbort = stop
+	startWatchdog()
+	go func() {
+		<-ctx.Done()
+		stop()
+		sdNotify("STOPPING=1")
+		slog.Warn("Interrupted, waiting for the current files to finish, interrupt again to abort")
+	}()
+	startService(stop)
+	defer stopService(0)
+	setupWebui()
+	params.Cache = cacheLocation(params.Cache)
+	params.Input = cacheLocation(params.Input)
+	params.Output = cacheLocation(params.Output)
+	newCache := false
+	if isURL(params.Cache) || isURL(params.Output) {
+		fatal("Only the input cache file can be a URL")
+	}
+	if params.Cache != "" {
+		if params.Input != "" || params.Output != "" {
+			fatal("The cache file can't be used together with the input and output files")
+		}
+		params.Input = params.Cache
+		params.Output = params.Cache
+		if err := statCache(params.Cache); errors.Is(err, fs.ErrNotExist) {
+			slog.Info("Cache file doesn't exist, creating a new one", "path", params.Cache)
+			newCache = true
+		}
+	}
+	if (params.Verify || command != "hash" && command != "agent") && params.Input == "" && params.Store == "" {
+		fatal("The input cache file is required")
+	}
+	if command == "validate" {
+		if !validateCache() {
+			exit(1)
+		}
+		return
+	}
+	if command == "export" {
+		var result sdhasher.Cache
+		if params.Store != "" {
+			result = loadStore(newCache)
+		} else if result, err = readCache(params.Input); err != nil {
+			fatal("Error reading cache", "path", params.Input, "error", err)
+		}
+		setupRoots()
+		if err := exportCache(result, args); err != nil {
+			fatal("Error exporting cache", "error", err)
+		}
+		return
+	}
+	if len(params.Paths) == 0 {
+		fatal("At least one models directory is required")
+	}
+	if needsOutput(command) && params.Output == "" && params.Store == "" {
+		fatal("Output cache file is required")
+	}
+	if params.Stdin && params.Watch {
+		fatal("The file list can't be used in watch mode")
+	}
+	if params.TUI && (params.Stdin || params.Output == "-" || params.Stream == "-") {
+		fatal("The TUI can't be used with the file list on stdin or the output to stdout")
+	}
+	schedule := setupSchedule(command)
+	if stdoutConflict() {
+		fatal("The cache can't be written to stdout in watch mode or together with the summary, the result stream " +
+			"or the duplicates report")
+	}
+	result := sdhasher.Cache{}
+	if params.Store != "" {
+		result = loadStore(newCache)
+	} else if params.Input != "" && !newCache {
+		if result, err = readCache(params.Input); err != nil {
+			fatal("Error reading cache", "path", params.Input, "error", err)
+		}
+	}
+	result.Init()
+	normalizeKeys(&result)
+	stampVersion(&result)
+	trackOutput(result)
+	if params.MaxHashers == 0 {
+		params.MaxHashers = runtime.NumCPU()
+	}
+	setupRoots()
+	setupExtensions()
+	setupReadLimiter()
+	setupBuffers()
+	setupPriority()
+	setupAgents()
+	setupStream()
+	setupEvents()
+	serveMetrics()
+	if params.Verify {
+		if !verify(ctx, result, args) || ctx.Err() != nil {
+			exit(1)
+		}
+		return
+	}
+	if params.Watch {
+		// the first scan can take hours, systemd would kill the service waiting for it
+		sdNotify("READY=1")
+	}
+	original := result.Clone()
+	switch {
+	case command == "lookup":
+		params.Civitai = true
+		postScan(ctx, result)
+		return
+	case command == "serve":
+		serve(ctx, result, schedule)
+		return
+	case command == "agent":
+		runAgent(ctx)
+		return
+	case command == "prune":
+		prune(&result)
+	case command == "dedupe":
+		scan(ctx, &result)
+		dedupe(&result)
+	case command == "rename":
+		scan(ctx, &result)
+		renameModels(ctx, &result)
+	case params.Stdin:
+		scanList(ctx, &result, os.Stdin)
+	default:
+		scan(ctx, &result)
+	}
+	if params.DryRun {
+		return
+	}
+	stats.report()
+	if err := writeCache(result); err != nil {
+		fatal("Error writing cache", "error", err)
+	}
+	writeDelta(original, result)
+	writeAudit(original, result)
+	sendWebhook()
+	refreshWebui()
+	postScan(ctx, result)
+	if ctx.Err() != nil {
+		slog.Warn("Partial results saved")
+	} else if params.Watch {
+		watch(ctx, &result, schedule)
+		return
 	}
+	exit(stats.exitCode())
 }
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestAutosaveClock(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		files    int
+		// done are the times of the hashed files since the start
+		done []time.Duration
+		want []bool
+	}{
+		{"disabled", 0, 0, []time.Duration{time.Hour, 2 * time.Hour}, []bool{false, false}},
+		{"files", 0, 2, []time.Duration{1, 2, 3, 4, 5}, []bool{false, true, false, true, false}},
+		{"interval", time.Minute, 0,
+			[]time.Duration{30 * time.Second, time.Minute, 90 * time.Second, 2 * time.Minute},
+			[]bool{false, true, false, true}},
+		// a long file restarts the interval from the save
+		{"interval after a long file", time.Minute, 0,
+			[]time.Duration{5 * time.Minute, 5*time.Minute + 30*time.Second, 6 * time.Minute},
+			[]bool{true, false, true}},
+		// the save by the interval restarts the count of the files too
+		{"both", time.Minute, 3, []time.Duration{time.Second, time.Minute, 61 * time.Second, 62 * time.Second,
+			63 * time.Second}, []bool{false, true, false, false, true}},
+	}
+	saved := params
+	t.Cleanup(func() { params = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params.Autosave, params.AutosaveFiles = tt.interval, tt.files
+			start := time.Now()
+			clock := autosaveClock{last: start}
+			for i, d := range tt.done {
+				if got := clock.done(start.Add(d)); got != tt.want[i] {
+					t.Errorf("file %d at %s: got due %v, want %v", i+1, d, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBackupCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   bool
+		existing int
+		limit    int
+		want     int
+	}{
+		{"no output", false, 0, 3, 0},
+		{"first", true, 0, 3, 1},
+		{"under the limit", true, 1, 3, 2},
+		{"rotated", true, 3, 3, 3},
+		{"limit lowered", true, 5, 2, 2},
+	}
+	saved := params
+	t.Cleanup(func() { params = saved })
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params.Output, params.Backups = filepath.Join(t.TempDir(), "cache.json"), tt.limit
+			if tt.output {
+				if err := os.WriteFile(params.Output, []byte("current"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// the older backups are named by the older times
+			for i := 0; i < tt.existing; i++ {
+				name := params.Output + "." + time.Date(2020, 1, i+1, 0, 0, 0, 0, time.UTC).Format(backupTimeFormat) +
+					".bak"
+				if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := backupCache(); err != nil {
+				t.Fatal(err)
+			}
+			backups, err := filepath.Glob(params.Output + ".*.bak")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(backups)
+			if len(backups) != tt.want {
+				t.Fatalf("got backups %v, want %d", backups, tt.want)
+			}
+			if tt.want == 0 {
+				return
+			}
+			if data, err := os.ReadFile(backups[len(backups)-1]); err != nil || string(data) != "current" {
+				t.Errorf("got the newest backup %q, %v, want the current cache", data, err)
+			}
+			// the oldest ones are removed
+			if removed := tt.existing + 1 - tt.want; removed > 0 {
+				oldest := time.Date(2020, 1, removed+1, 0, 0, 0, 0, time.UTC).Format(backupTimeFormat)
+				if !strings.Contains(backups[0], oldest) {
+					t.Errorf("got the oldest backup %s, want the one of %s", backups[0], oldest)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveCacheBacksUpOnce(t *testing.T) {
+	savedParams, savedBackedUp := params, backedUp
+	t.Cleanup(func() { params, backedUp = savedParams, savedBackedUp })
+	params.Output, params.Backups, backedUp = filepath.Join(t.TempDir(), "cache.json"), 2, false
+	if err := os.WriteFile(params.Output, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the autosaves of the run don't rotate the backup of the previous cache out
+	for _, hash := range []string{"first", "second", "third"} {
+		c := sdhasher.Cache{Hashes: map[string]sdhasher.Entry{"checkpoint/model": {SHA256: hash}}}
+		if err := saveCache(params.Output, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := filepath.Glob(params.Output + ".*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got backups %v, want one", backups)
+	}
+	if data, err := os.ReadFile(backups[0]); err != nil || string(data) != "previous" {
+		t.Errorf("got backup %q, %v, want the previous cache", data, err)
+	}
+	if c, err := readCache(params.Output); err != nil || c.Hashes["checkpoint/model"].SHA256 != "third" {
+		t.Errorf("got cache %v, %v, want the last one", c.Hashes, err)
+	}
+}
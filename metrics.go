@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metricsCollector keeps the totals of all scans for the Prometheus endpoint
+type metricsCollector struct {
+	filesHashed atomic.Int64
+	bytesRead   atomic.Int64
+	errors      atomic.Int64
+	queueDepth  atomic.Int64
+	sync.Mutex
+	workerBusy       []time.Duration
+	lastScanDuration float64
+	lastScanTime     time.Time
+}
+
+var metrics metricsCollector
+
+func (m *metricsCollector) addBusy(worker int, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	for len(m.workerBusy) <= worker {
+		m.workerBusy = append(m.workerBusy, 0)
+	}
+	m.workerBusy[worker] += d
+}
+
+func (m *metricsCollector) scanFinished(duration float64) {
+	m.Lock()
+	defer m.Unlock()
+	m.lastScanDuration = duration
+	m.lastScanTime = time.Now()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *metricsCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("sdhasher_files_hashed_total", "counter", "Files hashed successfully.")
+	fmt.Fprintf(w, "sdhasher_files_hashed_total %d\n", m.filesHashed.Load())
+	metric("sdhasher_bytes_read_total", "counter", "Bytes read from the model files.")
+	fmt.Fprintf(w, "sdhasher_bytes_read_total %d\n", m.bytesRead.Load())
+	metric("sdhasher_errors_total", "counter", "Files that couldn't be hashed.")
+	fmt.Fprintf(w, "sdhasher_errors_total %d\n", m.errors.Load())
+	metric("sdhasher_queue_depth", "gauge", "Files waiting to be hashed.")
+	fmt.Fprintf(w, "sdhasher_queue_depth %d\n", m.queueDepth.Load())
+	m.Lock()
+	defer m.Unlock()
+	metric("sdhasher_worker_busy_seconds_total", "counter", "Time the hashing workers spent hashing.")
+	for i, b := range m.workerBusy {
+		fmt.Fprintf(w, "sdhasher_worker_busy_seconds_total{worker=\"%d\"} %g\n", i, b.Seconds())
+	}
+	metric("sdhasher_last_scan_duration_seconds", "gauge", "Duration of the last scan.")
+	fmt.Fprintf(w, "sdhasher_last_scan_duration_seconds %g\n", m.lastScanDuration)
+	metric("sdhasher_last_scan_timestamp_seconds", "gauge", "Time the last scan finished.")
+	if !m.lastScanTime.IsZero() {
+		fmt.Fprintf(w, "sdhasher_last_scan_timestamp_seconds %d\n", m.lastScanTime.Unix())
+	}
+}
+
+// serveMetrics exposes the metrics and the dashboard on the separate address, the serve command has them on its own
+// address
+func serveMetrics() {
+	if params.MetricsListen == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", &metrics)
+	if params.Watch {
+		mux.HandleFunc("POST /scan", func(w http.ResponseWriter, r *http.Request) {
+			notify(rescanRequests)
+			w.WriteHeader(http.StatusAccepted)
+		})
+	}
+	dashboard.register(mux, params.Watch)
+	go func() {
+		if err := http.ListenAndServe(params.MetricsListen, mux); err != nil {
+			slog.Error("Error serving metrics", "address", params.MetricsListen, "error", err)
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+func mapFile(f *os.File, size int64) (data []byte, unmap func(), ok bool) {
+	return nil, nil, false
+}
@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// mapFile maps the whole file into memory, ok is false if the file can't be mapped and should be read instead
+func mapFile(f *os.File, size int64) (data []byte, unmap func(), ok bool) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, false
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, false
+	}
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return data, func() { unix.Munmap(data) }, true
+}
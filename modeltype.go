@@ -0,0 +1,28 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// expectedModelTypes are the detected model types that belong to the directories of the key prefixes
+var expectedModelTypes = map[string][]string{
+	"checkpoint/": {"sd1", "sd2", "sdxl", "sd3", "flux"},
+	"lora/":       {"lora"},
+	"vae/":        {"vae"},
+}
+
+// warnModelType reports the file whose detected type doesn't belong to the directory it's in
+func warnModelType(key, path, modelType string) {
+	if modelType == sdhasher.UnknownModelType {
+		return
+	}
+	for prefix, types := range expectedModelTypes {
+		if strings.HasPrefix(key, prefix) && !slices.Contains(types, modelType) {
+			slog.Warn("Model type doesn't match its directory", "path", path, "key", key, "type", modelType)
+		}
+	}
+}
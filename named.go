@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+var (
+	// nameIndex maps the model names to their files for every root of the named models, it's rebuilt on every scan
+	nameIndex   = map[string]map[string]string{}
+	nameIndexMu sync.Mutex
+)
+
+// isNamed reports whether the models of the root are keyed by their names
+func (r *root) isNamed() bool {
+	return sdhasher.Named(r.prefix)
+}
+
+// namedPath returns the file of the model name in the root, the first file in the walk order is used when
+// several files have the same name
+func namedPath(r *root, name string) (string, bool) {
+	nameIndexMu.Lock()
+	defer nameIndexMu.Unlock()
+	index, ok := nameIndex[r.path]
+	if !ok {
+		index = map[string]string{}
+		if r.fsys != nil {
+			fs.WalkDir(r.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() || !wantFile(r, p) {
+					return nil
+				}
+				n := sdhasher.ModelName(p)
+				if other, ok := index[n]; ok {
+					slog.Info("Duplicate model name, only the first file is hashed", "path", r.join(p), "first", other)
+					return nil
+				}
+				index[n] = r.join(p)
+				return nil
+			})
+		}
+		nameIndex[r.path] = index
+	}
+	p, ok := index[name]
+	return p, ok
+}
+
+func resetNames() {
+	nameIndexMu.Lock()
+	nameIndex = map[string]map[string]string{}
+	nameIndexMu.Unlock()
+}
+
+// wantFile reports whether the file with the storage name in the root is hashed by its extension
+func wantFile(r *root, name string) bool {
+	return sdhasher.ModelFile(r.prefix, name, extensions)
+}
+
+// renameNamedKeys moves the entries of the named models stored by the file path like the other models to the name keys
+func renameNamedKeys(c *sdhasher.Cache) int {
+	changes := 0
+	for _, k := range sortedKeys(c.Hashes) {
+		for i := range roots {
+			r := &roots[i]
+			if !r.isNamed() || !strings.HasPrefix(k, r.prefix) {
+				continue
+			}
+			name := strings.TrimPrefix(k, r.prefix)
+			if _, ok := namedPath(r, name); ok {
+				break
+			}
+			key := r.prefix + sdhasher.ModelName(name)
+			p, ok := namedPath(r, sdhasher.ModelName(name))
+			if !ok || p != r.join(name) {
+				continue
+			}
+			if _, exists := c.Hashes[key]; exists {
+				slog.Info("Removed model key of the file path", "key", k, "new_key", key)
+				c.Remove(k)
+			} else {
+				slog.Info("Renamed model key", "key", k, "new_key", key)
+				c.Rename(k, key)
+			}
+			changes++
+			break
+		}
+	}
+	return changes
+}
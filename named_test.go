@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestRenameNamedKeys(t *testing.T) {
+	savedRoots, savedExtensions := roots, extensions
+	t.Cleanup(func() {
+		roots, extensions = savedRoots, savedExtensions
+		resetNames()
+	})
+	extensions = map[string]struct{}{".safetensors": {}}
+	// want maps the keys to the keys their entries came from
+	tests := []struct {
+		name    string
+		files   []string
+		keys    []string
+		want    map[string]string
+		changes int
+	}{
+		{"path key", []string{"style/foo.safetensors"}, []string{"lora/style/foo.safetensors"},
+			map[string]string{"lora/foo": "lora/style/foo.safetensors"}, 1},
+		// the first file in the walk order gets the name, the key of the other one is left for pruning
+		{"collision across directories", []string{"a/foo.safetensors", "b/foo.safetensors"},
+			[]string{"lora/a/foo.safetensors", "lora/b/foo.safetensors"},
+			map[string]string{"lora/foo": "lora/a/foo.safetensors", "lora/b/foo.safetensors": "lora/b/foo.safetensors"},
+			1},
+		{"mixed case extension", []string{"Foo.SafeTensors"}, []string{"lora/Foo.SafeTensors"},
+			map[string]string{"lora/Foo": "lora/Foo.SafeTensors"}, 1},
+		{"name key", []string{"foo.safetensors"}, []string{"lora/foo"}, map[string]string{"lora/foo": "lora/foo"}, 0},
+		{"name key with a dot", []string{"v1.5-foo.safetensors", "v1.safetensors"}, []string{"lora/v1.5-foo"},
+			map[string]string{"lora/v1.5-foo": "lora/v1.5-foo"}, 0},
+		{"name key of a missing file", []string{"v1.safetensors"}, []string{"lora/v1.5-gone"},
+			map[string]string{"lora/v1.5-gone": "lora/v1.5-gone"}, 0},
+		// the name key is written by the webui itself, so it's kept over the path key
+		{"both keys", []string{"foo.safetensors"}, []string{"lora/foo", "lora/foo.safetensors"},
+			map[string]string{"lora/foo": "lora/foo"}, 1},
+		{"other prefix", []string{"foo.safetensors"}, []string{"checkpoint/foo.safetensors"},
+			map[string]string{"checkpoint/foo.safetensors": "checkpoint/foo.safetensors"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				path := filepath.Join(dir, filepath.FromSlash(f))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			roots = []root{{path: dir, prefix: "lora/", fsys: localStorage{dir: dir}}}
+			resetNames()
+			var c sdhasher.Cache
+			c.Init()
+			for _, k := range tt.keys {
+				c.Hashes[k] = sdhasher.Entry{SHA256: k}
+				c.HashesAddnet[k] = sdhasher.Entry{SHA256: k}
+			}
+			if changes := renameNamedKeys(&c); changes != tt.changes {
+				t.Errorf("got %d changes, want %d", changes, tt.changes)
+			}
+			for name, section := range map[string]map[string]sdhasher.Entry{"hashes": c.Hashes,
+				"hashes-addnet": c.HashesAddnet} {
+				got := map[string]string{}
+				for k, e := range section {
+					got[k] = e.SHA256
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s: got %v, want %v", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "io"
+
+// throttledReader reports the progress and applies the read rate limit
+type throttledReader struct {
+	io.Reader
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	progress.read(n)
+	readLimiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,318 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"time"
+)
+
+// MTime is the modification time in seconds since the epoch
+type MTime float64
+
+// MarshalJSON writes the time with 7 decimals unless they lose precision, such as with the small times, then with the
+// shortest representation that round-trips, same as Python's repr, so that the exact times are compared the same
+func (m MTime) MarshalJSON() ([]byte, error) {
+	b := []byte(fmt.Sprintf("%.7f", m))
+	if v, err := strconv.ParseFloat(string(b), 64); err == nil && v == float64(m) {
+		return b, nil
+	}
+	return strconv.AppendFloat(nil, float64(m), 'f', -1, 64), nil
+}
+
+// MTimeMode is how the modification times are stored and compared
+type MTimeMode int
+
+const (
+	// MarginMTime adds a second to the stored times and tolerates the small differences, such as of the file systems
+	// storing the times in whole seconds
+	MarginMTime MTimeMode = iota
+	// ExactMTime stores and compares the times exactly like the webui does
+	ExactMTime
+)
+
+// Entry is the cached hash of a file
+type Entry struct {
+	MTime  MTime             `json:"mtime"`
+	SHA256 string            `json:"sha256"`
+	Size   int64             `json:"size,omitempty"`
+	Extra  map[string]string `json:"-"`
+	// Path, Key, Addnet and Metadata are only set for the freshly hashed files
+	Path     string          `json:"-"`
+	Key      string          `json:"-"`
+	Addnet   string          `json:"-"`
+	Metadata json.RawMessage `json:"-"`
+	other    map[string]json.RawMessage
+}
+
+// CivitaiNotFoundField is the Extra field with the time the model was last looked up on Civitai and not found
+const CivitaiNotFoundField = "civitai_not_found"
+
+// MarshalJSON stores the extra hashes and the preserved unknown fields as additional fields of the Entry
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type plain Entry
+	b, err := json.Marshal(plain(e))
+	if err != nil || len(e.Extra) == 0 && len(e.other) == 0 {
+		return b, err
+	}
+	fields := map[string]json.RawMessage{}
+	for k, v := range e.other {
+		fields[k] = v
+	}
+	for k, v := range e.Extra {
+		if fields[k], err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return appendFields(b, fields)
+}
+
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	type plain Entry
+	if err := json.Unmarshal(data, (*plain)(e)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	delete(fields, "mtime")
+	delete(fields, "sha256")
+	delete(fields, "size")
+	// the fields derived from the file are kept in Extra so that they're replaced when the file is rehashed
+	names := append([]string{ModelTypeField, CivitaiNotFoundField, AutoV2Field}, GGUFFields...)
+	names = append(names, KohyaFields...)
+	for name := range ExtraHashes {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		var value string
+		if raw, ok := fields[name]; ok && json.Unmarshal(raw, &value) == nil {
+			if e.Extra == nil {
+				e.Extra = map[string]string{}
+			}
+			e.Extra[name] = value
+			delete(fields, name)
+		}
+	}
+	if len(fields) > 0 {
+		e.other = fields
+	}
+	return nil
+}
+
+// MetadataEntry is the format the webui uses for the cached data other than hashes
+type MetadataEntry struct {
+	MTime MTime           `json:"mtime"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Cache is the webui cache.json file
+type Cache struct {
+	Hashes              map[string]Entry         `json:"hashes"`
+	HashesAddnet        map[string]Entry         `json:"hashes-addnet,omitempty"`
+	SafetensorsMetadata map[string]MetadataEntry `json:"safetensors-metadata,omitempty"`
+	// other keeps the sections written by the webui and its extensions that we don't touch
+	other map[string]json.RawMessage
+}
+
+// MarshalJSON writes the sections and the entries sorted by their keys so that the same cache always produces the same
+// file
+func (c Cache) MarshalJSON() ([]byte, error) {
+	fields := make(map[string]json.RawMessage, len(c.other)+3)
+	for k, v := range c.other {
+		fields[k] = v
+	}
+	var err error
+	if fields["hashes"], err = json.Marshal(c.Hashes); err != nil {
+		return nil, err
+	}
+	if len(c.HashesAddnet) > 0 {
+		if fields["hashes-addnet"], err = json.Marshal(c.HashesAddnet); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.SafetensorsMetadata) > 0 {
+		if fields["safetensors-metadata"], err = json.Marshal(c.SafetensorsMetadata); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fields)
+}
+
+func (c *Cache) UnmarshalJSON(data []byte) error {
+	type plain Cache
+	if err := json.Unmarshal(data, (*plain)(c)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	delete(fields, "hashes")
+	delete(fields, "hashes-addnet")
+	delete(fields, "safetensors-metadata")
+	if len(fields) > 0 {
+		c.other = fields
+	}
+	return nil
+}
+
+// appendFields adds the fields to the encoded JSON object
+func appendFields(object []byte, fields map[string]json.RawMessage) ([]byte, error) {
+	extra, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(object) == 2 {
+		return extra, nil
+	}
+	return append(append(object[:len(object)-1], ','), extra[1:]...), nil
+}
+
+// Init creates the missing sections
+func (c *Cache) Init() {
+	if c.Hashes == nil {
+		c.Hashes = map[string]Entry{}
+	}
+	if c.HashesAddnet == nil {
+		c.HashesAddnet = map[string]Entry{}
+	}
+	if c.SafetensorsMetadata == nil {
+		c.SafetensorsMetadata = map[string]MetadataEntry{}
+	}
+}
+
+// Clone returns a copy of the cache that can be changed independently
+func (c Cache) Clone() Cache {
+	result := Cache{other: c.other}
+	result.Init()
+	for k, e := range c.Hashes {
+		result.Hashes[k] = e
+	}
+	for k, e := range c.HashesAddnet {
+		result.HashesAddnet[k] = e
+	}
+	for k, e := range c.SafetensorsMetadata {
+		result.SafetensorsMetadata[k] = e
+	}
+	return result
+}
+
+// SetSection stores the value as a section next to the webui ones, such as the version of the program that wrote the
+// cache
+func (c *Cache) SetSection(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// the sections may be shared with the clones
+	other := map[string]json.RawMessage{name: data}
+	for k, v := range c.other {
+		if k != name {
+			other[k] = v
+		}
+	}
+	c.other = other
+	return nil
+}
+
+// Remove deletes the key from all sections
+func (c *Cache) Remove(key string) {
+	delete(c.Hashes, key)
+	delete(c.HashesAddnet, key)
+	delete(c.SafetensorsMetadata, key)
+}
+
+// Rename moves the entries of the key in all sections to the new key
+func (c *Cache) Rename(from, to string) {
+	if e, ok := c.Hashes[from]; ok {
+		c.Hashes[to] = e
+	}
+	if e, ok := c.HashesAddnet[from]; ok {
+		c.HashesAddnet[to] = e
+	}
+	if e, ok := c.SafetensorsMetadata[from]; ok {
+		c.SafetensorsMetadata[to] = e
+	}
+	c.Remove(from)
+}
+
+// Apply stores the hashing results in the cache, the unknown fields of the replaced entries are kept
+func (c *Cache) Apply(hashed []*Entry) {
+	for _, e := range hashed {
+		entry := *e
+		if previous, ok := c.Hashes[e.Key]; ok && entry.other == nil {
+			entry.other = previous.other
+		}
+		c.Hashes[e.Key] = entry
+		if e.Addnet != "" {
+			c.HashesAddnet[e.Key] = Entry{MTime: e.MTime, SHA256: e.Addnet}
+		}
+		if e.Metadata != nil {
+			c.SafetensorsMetadata[e.Key] = MetadataEntry{MTime: e.MTime, Value: e.Metadata}
+		}
+	}
+}
+
+// pythonMTime returns the modification time the same way os.path.getmtime does
+func pythonMTime(t time.Time) float64 {
+	return float64(t.Unix()) + float64(t.Nanosecond())*1e-9
+}
+
+// FileMTime returns the modification time to store for the file
+func (m MTimeMode) FileMTime(fi fs.FileInfo) MTime {
+	if m == ExactMTime {
+		return MTime(pythonMTime(fi.ModTime()))
+	}
+	return MTime(float64(fi.ModTime().UnixNano())/1e9 + 1) // add one second margin because floats suck
+}
+
+// Modified checks if the file has changed since the entry was hashed
+func (m MTimeMode) Modified(fi fs.FileInfo, e Entry) bool {
+	if m == ExactMTime {
+		return pythonMTime(fi.ModTime()) > float64(e.MTime)
+	}
+	return fi.ModTime().Sub(time.Unix(int64(e.MTime), 0)) > time.Second*2
+}
+
+// Wanted are the optional parts of the entries, the entries without them are rehashed
+type Wanted struct {
+	Metadata    bool
+	Addnet      bool
+	ModelType   bool
+	GGUF        bool
+	ExtraHashes []string
+	// MTime is how the modification time of the entry is compared
+	MTime MTimeMode
+}
+
+// Outdated returns why the entry of the key has to be rehashed for the file, it's empty if the entry is up to date
+func (c Cache) Outdated(key string, fi fs.FileInfo, want Wanted) string {
+	e := c.Hashes[key]
+	if want.MTime.Modified(fi, e) {
+		return "File changed"
+	}
+	if e.Size != 0 && e.Size != fi.Size() {
+		return "File size changed"
+	}
+	if _, ok := c.SafetensorsMetadata[key]; !ok && want.Metadata {
+		return "File has no metadata"
+	}
+	if _, ok := c.HashesAddnet[key]; !ok && want.Addnet {
+		return "File has no addnet hash"
+	}
+	if _, ok := e.Extra[ModelTypeField]; !ok && want.ModelType {
+		return "File has no model type"
+	}
+	if _, ok := e.Extra[GGUFFields[0]]; !ok && want.GGUF {
+		return "File has no GGUF fields"
+	}
+	for _, name := range want.ExtraHashes {
+		if _, ok := e.Extra[name]; !ok {
+			return "File has no " + name + " hash"
+		}
+	}
+	return ""
+}
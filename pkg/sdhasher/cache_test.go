@@ -0,0 +1,108 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyKeepsUnknownFields(t *testing.T) {
+	var c Cache
+	if err := json.Unmarshal([]byte(`{"hashes": {"checkpoint/foo.safetensors": `+
+		`{"mtime": 1, "sha256": "old", "extension_field": "value"}}}`), &c); err != nil {
+		t.Fatal(err)
+	}
+	c.Apply([]*Entry{{Key: "checkpoint/foo.safetensors", MTime: 2, SHA256: "new"}})
+	data, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"extension_field":"value"`) {
+		t.Errorf("unknown field was dropped: %s", data)
+	}
+	if e := c.Hashes["checkpoint/foo.safetensors"]; e.SHA256 != "new" || e.MTime != 2 {
+		t.Errorf("entry wasn't replaced: %+v", e)
+	}
+}
+
+func TestApplyReplacesDerivedFields(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		stale string
+		extra map[string]string
+		want  string
+	}{
+		{"autov2", `"autov2":"0123456789"`, nil, ""},
+		{"autov2 rehashed", `"autov2":"0123456789"`, map[string]string{"autov2": "abcdefabcd"},
+			`"autov2":"abcdefabcd"`},
+		{"kohya", `"ss_sd_model_hash":"oldbase"`, nil, ""},
+		{"kohya rehashed", `"ss_sd_model_name":"old.ckpt"`, map[string]string{"ss_sd_model_name": "new.ckpt"},
+			`"ss_sd_model_name":"new.ckpt"`},
+	} {
+		var c Cache
+		if err := json.Unmarshal([]byte(`{"hashes": {"lora/foo": `+
+			`{"mtime": 1, "sha256": "old", "extension_field": "value", `+tc.stale+`}}}`), &c); err != nil {
+			t.Fatal(err)
+		}
+		c.Apply([]*Entry{{Key: "lora/foo", MTime: 2, SHA256: "new", Extra: tc.extra}})
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), tc.stale) {
+			t.Errorf("%s: the stale field is kept: %s", tc.name, data)
+		}
+		if !strings.Contains(string(data), tc.want) || !strings.Contains(string(data), `"extension_field":"value"`) {
+			t.Errorf("%s: got %s, want %s and the unknown field", tc.name, data, tc.want)
+		}
+	}
+}
+
+func TestMTimeMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Unix(1700000000, 500000000)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		mode     MTimeMode
+		want     MTime
+		modified map[MTime]bool
+	}{
+		{"margin", MarginMTime, 1700000001.5,
+			map[MTime]bool{1700000001.5: false, 1700000000: false, 1699999997: true}},
+		{"exact", ExactMTime, 1700000000.5,
+			map[MTime]bool{1700000000.5: false, 1700000001: false, 1700000000.25: true}},
+	} {
+		if got := tc.mode.FileMTime(fi); got != tc.want {
+			t.Errorf("%s: got mtime %v, want %v", tc.name, got, tc.want)
+		}
+		for mtime, want := range tc.modified {
+			if got := tc.mode.Modified(fi, Entry{MTime: mtime}); got != want {
+				t.Errorf("%s: got modified %v for the entry of %v, want %v", tc.name, got, mtime, want)
+			}
+		}
+	}
+	// the times are written without losing precision
+	for _, m := range []MTime{1, 1700000000.5, 1700000000.123456789, 0.1 + 0.2} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got MTime
+		if err := json.Unmarshal(data, &got); err != nil || got != m {
+			t.Errorf("got %v from %s, want %v", got, data, m)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package sdhasher
+
+import (
+	"io"
+	"sync"
+)
+
+// ReadChunks reads r to the end and calls hash for every chunk, with more than one buffer the next chunk is read in
+// a separate goroutine while the previous one is being hashed
+func ReadChunks(r io.Reader, bufs [][]byte, hash func([]byte) error) error {
+	if len(bufs) == 1 {
+		for {
+			n, err := r.Read(bufs[0])
+			if n > 0 {
+				if err := hash(bufs[0][:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	type chunk struct {
+		buf int
+		n   int
+		err error
+	}
+	free := make(chan int, len(bufs))
+	for i := range bufs {
+		free <- i
+	}
+	// every buffer can be in the channel along with the final error
+	full := make(chan chunk, len(bufs)+1)
+	done := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	// the reader must be stopped before the buffers are reused
+	defer wg.Wait()
+	defer close(done)
+	go func() {
+		defer wg.Done()
+		defer close(full)
+		for {
+			var i int
+			select {
+			case i = <-free:
+			case <-done:
+				return
+			}
+			n, err := r.Read(bufs[i])
+			if n > 0 {
+				full <- chunk{buf: i, n: n}
+			} else {
+				free <- i
+			}
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				full <- chunk{err: err}
+				return
+			}
+		}
+	}()
+	for c := range full {
+		if c.err != nil {
+			return c.err
+		}
+		if err := hash(bufs[c.buf][:c.n]); err != nil {
+			return err
+		}
+		free <- c.buf
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package sdhasher
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"testing/iotest"
+)
+
+// emptyReads returns no data every other read
+type emptyReads struct {
+	io.Reader
+	empty bool
+}
+
+func (r *emptyReads) Read(p []byte) (int, error) {
+	if r.empty = !r.empty; r.empty {
+		return 0, nil
+	}
+	return r.Reader.Read(p)
+}
+
+// endless returns the same byte forever
+type endless struct{}
+
+func (endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 1
+	}
+	return len(p), nil
+}
+
+func TestReadChunks(t *testing.T) {
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	tests := []struct {
+		name   string
+		reader func() io.Reader
+		err    error
+	}{
+		{"plain", func() io.Reader { return bytes.NewReader(data) }, nil},
+		{"one byte", func() io.Reader { return iotest.OneByteReader(bytes.NewReader(data)) }, nil},
+		{"half", func() io.Reader { return iotest.HalfReader(bytes.NewReader(data)) }, nil},
+		{"data with EOF", func() io.Reader { return iotest.DataErrReader(bytes.NewReader(data)) }, nil},
+		{"empty reads", func() io.Reader { return &emptyReads{Reader: bytes.NewReader(data)} }, nil},
+		{"error", func() io.Reader { return iotest.TimeoutReader(bytes.NewReader(data)) }, iotest.ErrTimeout},
+	}
+	for _, tt := range tests {
+		for _, n := range []int{1, 2, 4} {
+			bufs := make([][]byte, n)
+			for i := range bufs {
+				bufs[i] = make([]byte, 1000)
+			}
+			var got bytes.Buffer
+			err := ReadChunks(tt.reader(), bufs, func(p []byte) error {
+				chunk := bytes.Clone(p)
+				// the buffer must not be refilled until it's hashed
+				runtime.Gosched()
+				if !bytes.Equal(p, chunk) {
+					t.Errorf("%s with %d buffers: buffer changed while hashing", tt.name, n)
+				}
+				got.Write(chunk)
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s with %d buffers: got error %v, want %v", tt.name, n, err, tt.err)
+			}
+			if tt.err == nil && !bytes.Equal(got.Bytes(), data) {
+				t.Errorf("%s with %d buffers: got %d bytes, want the %d bytes of data", tt.name, n, got.Len(),
+					len(data))
+			}
+		}
+	}
+}
+
+func TestReadChunksHashError(t *testing.T) {
+	errHash := errors.New("hash error")
+	for _, n := range []int{1, 2, 4} {
+		bufs := make([][]byte, n)
+		for i := range bufs {
+			bufs[i] = make([]byte, 100)
+		}
+		chunks := 0
+		// the reading stops after the error, ReadChunks would never return otherwise
+		err := ReadChunks(endless{}, bufs, func(p []byte) error {
+			if chunks++; chunks == 3 {
+				return errHash
+			}
+			return nil
+		})
+		if !errors.Is(err, errHash) {
+			t.Errorf("%d buffers: got error %v, want %v", n, err, errHash)
+		}
+		if chunks != 3 {
+			t.Errorf("%d buffers: hashed %d chunks after the error, want 3", n, chunks)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package sdhasher
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+// Change is an entry whose hash differs between two caches
+type Change struct {
+	Key string `json:"key"`
+	Old string `json:"old"`
+	New string `json:"new"`
+}
+
+// CacheDiff lists the keys added, removed and changed between two caches
+type CacheDiff struct {
+	Added   map[string]string `json:"added"`
+	Removed map[string]string `json:"removed"`
+	Changed []Change          `json:"changed"`
+}
+
+// Empty returns true if there are no differences
+func (d CacheDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff compares the sha256 hashes of two caches
+func Diff(old, updated Cache) CacheDiff {
+	result := CacheDiff{Added: map[string]string{}, Removed: map[string]string{}, Changed: []Change{}}
+	for k, e := range updated.Hashes {
+		o, ok := old.Hashes[k]
+		if !ok {
+			result.Added[k] = e.SHA256
+		} else if o.SHA256 != e.SHA256 {
+			result.Changed = append(result.Changed, Change{Key: k, Old: o.SHA256, New: e.SHA256})
+		}
+	}
+	for k, e := range old.Hashes {
+		if _, ok := updated.Hashes[k]; !ok {
+			result.Removed[k] = e.SHA256
+		}
+	}
+	sort.Slice(result.Changed, func(i, j int) bool { return result.Changed[i].Key < result.Changed[j].Key })
+	return result
+}
+
+// Delta returns the entries of updated that are new or different from old in all sections, the removed entries can't
+// be represented, so merging the delta into old gives updated without its removals
+func Delta(old, updated Cache) Cache {
+	result := Cache{}
+	result.Init()
+	for k, e := range updated.Hashes {
+		if o, ok := old.Hashes[k]; !ok || !sameEntry(o, e) {
+			result.Hashes[k] = e
+		}
+	}
+	for k, e := range updated.HashesAddnet {
+		if o, ok := old.HashesAddnet[k]; !ok || !sameEntry(o, e) {
+			result.HashesAddnet[k] = e
+		}
+	}
+	for k, m := range updated.SafetensorsMetadata {
+		if o, ok := old.SafetensorsMetadata[k]; !ok || o.MTime != m.MTime || !bytes.Equal(o.Value, m.Value) {
+			result.SafetensorsMetadata[k] = m
+		}
+	}
+	return result
+}
+
+// sameEntry compares the entries with all their fields
+func sameEntry(a, b Entry) bool {
+	ja, erra := json.Marshal(a)
+	jb, errb := json.Marshal(b)
+	return erra == nil && errb == nil && bytes.Equal(ja, jb)
+}
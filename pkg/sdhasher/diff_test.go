@@ -0,0 +1,95 @@
+package sdhasher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	old := Cache{Hashes: map[string]Entry{
+		"checkpoint/same.safetensors":    {MTime: 1, SHA256: "same"},
+		"checkpoint/touched.safetensors": {MTime: 1, SHA256: "touched"},
+		"checkpoint/changed.safetensors": {MTime: 1, SHA256: "old"},
+		"checkpoint/removed.safetensors": {MTime: 1, SHA256: "removed"},
+		"checkpoint/b.safetensors":       {MTime: 1, SHA256: "a"},
+	}}
+	updated := Cache{Hashes: map[string]Entry{
+		"checkpoint/same.safetensors":    {MTime: 1, SHA256: "same"},
+		"checkpoint/touched.safetensors": {MTime: 2, SHA256: "touched"},
+		"checkpoint/changed.safetensors": {MTime: 2, SHA256: "new"},
+		"checkpoint/added.safetensors":   {MTime: 2, SHA256: "added"},
+		"checkpoint/b.safetensors":       {MTime: 2, SHA256: "b"},
+	}}
+	want := CacheDiff{
+		Added:   map[string]string{"checkpoint/added.safetensors": "added"},
+		Removed: map[string]string{"checkpoint/removed.safetensors": "removed"},
+		Changed: []Change{
+			{Key: "checkpoint/b.safetensors", Old: "a", New: "b"},
+			{Key: "checkpoint/changed.safetensors", Old: "old", New: "new"},
+		},
+	}
+	if d := Diff(old, updated); !reflect.DeepEqual(d, want) {
+		t.Errorf("got %+v, want %+v", d, want)
+	}
+	if d := Diff(updated, updated); !d.Empty() {
+		t.Errorf("diff of the same cache isn't empty: %+v", d)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	const key = "checkpoint/foo.safetensors"
+	tests := []struct {
+		name    string
+		old     Entry
+		updated Entry
+		want    bool
+	}{
+		{"unchanged", Entry{MTime: 1, SHA256: "a", Size: 1}, Entry{MTime: 1, SHA256: "a", Size: 1}, false},
+		{"mtime", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "a"}, true},
+		{"hash", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 1, SHA256: "b"}, true},
+		{"size", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 1, SHA256: "a", Size: 1}, true},
+		{"extra field", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 1, SHA256: "a", Extra: map[string]string{
+			"blake3": "b3"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := Cache{Hashes: map[string]Entry{key: tt.old, "checkpoint/removed.safetensors": {SHA256: "removed"}},
+				HashesAddnet: map[string]Entry{key: tt.old}}
+			updated := Cache{
+				Hashes:       map[string]Entry{key: tt.updated, "checkpoint/added.safetensors": {SHA256: "added"}},
+				HashesAddnet: map[string]Entry{key: tt.updated},
+			}
+			d := Delta(old, updated)
+			if _, ok := d.Hashes[key]; ok != tt.want {
+				t.Errorf("entry in the delta: got %v, want %v", ok, tt.want)
+			}
+			if _, ok := d.HashesAddnet[key]; ok != tt.want {
+				t.Errorf("addnet entry in the delta: got %v, want %v", ok, tt.want)
+			}
+			if _, ok := d.Hashes["checkpoint/added.safetensors"]; !ok {
+				t.Error("added entry isn't in the delta")
+			}
+			if _, ok := d.Hashes["checkpoint/removed.safetensors"]; ok {
+				t.Error("removed entry is in the delta")
+			}
+		})
+	}
+}
+
+func TestDeltaMetadata(t *testing.T) {
+	old := Cache{SafetensorsMetadata: map[string]MetadataEntry{
+		"lora/same.safetensors":    {MTime: 1, Value: []byte(`{"a":1}`)},
+		"lora/changed.safetensors": {MTime: 1, Value: []byte(`{"a":1}`)},
+	}}
+	updated := Cache{SafetensorsMetadata: map[string]MetadataEntry{
+		"lora/same.safetensors":    {MTime: 1, Value: []byte(`{"a":1}`)},
+		"lora/changed.safetensors": {MTime: 1, Value: []byte(`{"a":2}`)},
+	}}
+	d := Delta(old, updated)
+	if len(d.SafetensorsMetadata) != 1 {
+		t.Fatalf("got %d metadata entries, want 1: %v", len(d.SafetensorsMetadata), d.SafetensorsMetadata)
+	}
+	if _, ok := d.SafetensorsMetadata["lora/changed.safetensors"]; !ok {
+		t.Error("changed metadata isn't in the delta")
+	}
+}
@@ -0,0 +1,98 @@
+package sdhasher
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Digest computes sha256 and the optional hashes and metadata of a file in a single pass over its data
+type Digest struct {
+	io.Writer
+	sha256 hash.Hash
+	addnet *addnetWriter
+	header *headerWriter
+	gguf   *ggufWriter
+	extra  map[string]hash.Hash
+}
+
+// NewDigest creates the digest computing the extra hashes from ExtraHashes, the Additional Networks hash, the
+// safetensors metadata and the GGUF architecture and quantization type
+func NewDigest(extra []string, addnet, metadata, gguf bool) (*Digest, error) {
+	d := &Digest{sha256: sha256.New(), extra: map[string]hash.Hash{}}
+	writers := []io.Writer{d.sha256}
+	if addnet {
+		d.addnet = newAddnetWriter()
+		writers = append(writers, d.addnet)
+	}
+	if metadata {
+		d.header = &headerWriter{}
+		writers = append(writers, d.header)
+	}
+	if gguf {
+		d.gguf = &ggufWriter{}
+		writers = append(writers, d.gguf)
+	}
+	for _, name := range extra {
+		newHash, ok := ExtraHashes[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown hash %s", name)
+		}
+		d.extra[name] = newHash()
+		writers = append(writers, d.extra[name])
+	}
+	d.Writer = d.sha256
+	if len(writers) > 1 {
+		d.Writer = io.MultiWriter(writers...)
+	}
+	return d, nil
+}
+
+// KohyaFields are the metadata fields kohya sd-scripts store in the LoRA files to identify the base model
+var KohyaFields = []string{"sshs_model_hash", "sshs_legacy_hash", "ss_sd_model_hash", "ss_new_sd_model_hash",
+	"ss_sd_model_name"}
+
+// MetadataFields returns the string values of the safetensors metadata fields, the digest should be created with
+// metadata enabled
+func (d *Digest) MetadataFields(names []string) map[string]string {
+	if d.header == nil {
+		return nil
+	}
+	return d.header.fields(names)
+}
+
+// Entry returns the hashes of the data written so far, the error is about the invalid metadata in which case it's
+// stored empty so that the file isn't rehashed every time, the GGUF fields are stored in Extra
+func (d *Digest) Entry() (*Entry, error) {
+	var err error
+	result := &Entry{SHA256: fmt.Sprintf("%x", d.sha256.Sum(nil))}
+	if d.addnet != nil {
+		result.Addnet = fmt.Sprintf("%x", d.addnet.Sum(nil))
+	}
+	if d.header != nil {
+		if result.Metadata, err = d.header.metadata(); err != nil {
+			result.Metadata = json.RawMessage("{}")
+		}
+	}
+	if len(d.extra) > 0 {
+		result.Extra = map[string]string{}
+		for name, h := range d.extra {
+			result.Extra[name] = fmt.Sprintf("%x", h.Sum(nil))
+		}
+	}
+	if d.gguf != nil {
+		fields, ggufErr := d.gguf.fields()
+		for name, value := range fields {
+			if result.Extra == nil {
+				result.Extra = map[string]string{}
+			}
+			result.Extra[name] = value
+		}
+		if ggufErr != nil {
+			err = fmt.Errorf("invalid GGUF header: %w", ggufErr)
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,6 @@
+// Package sdhasher hashes Stable Diffusion models and reads and writes the webui cache.json file.
+//
+// Scan walks the models directories and returns the cache with the hashes of all model files, only the new and
+// changed files are hashed if the previous cache is given. Hasher hashes single files, Digest computes all the hashes
+// in a single pass over any data.
+package sdhasher
@@ -0,0 +1,212 @@
+package sdhasher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxGGUFHeader limits the GGUF header kept in memory, the LLM headers include the tokenizer vocabulary and take a
+// few megabytes
+const maxGGUFHeader = 64 * 1024 * 1024
+
+// maxGGUFNesting limits the nesting of the arrays so that the crafted headers can't exhaust the stack
+const maxGGUFNesting = 16
+
+var ggufMagic = []byte("GGUF")
+
+// GGUFFields are the Extra fields of the GGUF model entries
+var GGUFFields = []string{"gguf_architecture", "gguf_quant"}
+
+// ggufWriter captures the beginning of a GGUF file to read its metadata and tensor types
+type ggufWriter struct {
+	buf  []byte
+	skip bool
+}
+
+func (w *ggufWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if w.skip {
+		return n, nil
+	}
+	if rest := maxGGUFHeader - len(w.buf); rest < len(p) {
+		p = p[:rest]
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= len(ggufMagic) && !bytes.Equal(w.buf[:len(ggufMagic)], ggufMagic) || len(w.buf) == maxGGUFHeader {
+		w.skip = true
+	}
+	return n, nil
+}
+
+// ggmlTypes are the names of the GGML tensor types
+var ggmlTypes = map[uint32]string{0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 6: "Q5_0", 7: "Q5_1", 8: "Q8_0", 9: "Q8_1",
+	10: "Q2_K", 11: "Q3_K", 12: "Q4_K", 13: "Q5_K", 14: "Q6_K", 15: "Q8_K", 16: "IQ2_XXS", 17: "IQ2_XS", 18: "IQ3_XXS",
+	19: "IQ1_S", 20: "IQ4_NL", 21: "IQ3_S", 22: "IQ2_S", 23: "IQ4_XS", 24: "I8", 25: "I16", 26: "I32", 27: "I64",
+	28: "F64", 29: "IQ1_M", 30: "BF16", 34: "TQ1_0", 35: "TQ2_0"}
+
+// ggufFileTypes are the names of the general.file_type values, the quantization the file was made with
+var ggufFileTypes = map[uint32]string{0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M", 16: "Q5_K_S", 17: "Q5_K_M",
+	18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S", 22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL",
+	26: "IQ3_S", 27: "IQ3_M", 28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16"}
+
+// fields returns the architecture and the quantization type of the model, the quantization is taken from
+// general.file_type or is the type of most of the tensor elements when it isn't set. The architecture of the invalid
+// files is unknown so that they aren't rehashed every time
+func (w *ggufWriter) fields() (map[string]string, error) {
+	unknown := map[string]string{GGUFFields[0]: "unknown"}
+	if len(w.buf) < len(ggufMagic) || !bytes.Equal(w.buf[:len(ggufMagic)], ggufMagic) {
+		return unknown, errors.New("not a GGUF file")
+	}
+	r := &ggufReader{r: bytes.NewReader(w.buf[len(ggufMagic):])}
+	version := r.u32()
+	if r.err == nil && version < 2 {
+		return unknown, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+	tensors, kvs := r.u64(), r.u64()
+	arch, quant := "", ""
+	for i := uint64(0); i < kvs && r.err == nil; i++ {
+		key, typ := r.str(), r.u32()
+		switch {
+		case key == "general.architecture" && typ == ggufString:
+			arch = r.str()
+		case key == "general.file_type" && typ == ggufUint32:
+			if name, ok := ggufFileTypes[r.u32()]; ok {
+				quant = name
+			}
+		default:
+			r.skipValue(typ, 0)
+		}
+	}
+	if quant == "" {
+		elements := map[uint32]uint64{}
+		for i := uint64(0); i < tensors && r.err == nil; i++ {
+			r.str()
+			count := uint64(1)
+			for dims := r.u32(); dims > 0 && r.err == nil; dims-- {
+				count *= r.u64()
+			}
+			typ := r.u32()
+			r.u64()
+			if r.err == nil {
+				elements[typ] += count
+			}
+		}
+		types := make([]uint32, 0, len(elements))
+		for t := range elements {
+			types = append(types, t)
+		}
+		sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+		var most uint64
+		for _, t := range types {
+			if elements[t] > most {
+				most, quant = elements[t], ggmlTypes[t]
+			}
+		}
+	}
+	if arch == "" && quant == "" {
+		if r.err == nil {
+			r.err = errors.New("no architecture and quantization in the GGUF header")
+		}
+		return unknown, r.err
+	}
+	if arch == "" {
+		arch = "unknown"
+	}
+	result := map[string]string{GGUFFields[0]: arch}
+	if quant != "" {
+		result[GGUFFields[1]] = quant
+	}
+	return result, nil
+}
+
+const (
+	ggufUint32 = 4
+	ggufString = 8
+	ggufArray  = 9
+)
+
+// ggufSizes are the sizes of the fixed size GGUF value types
+var ggufSizes = map[uint32]int64{0: 1, 1: 1, 2: 2, 3: 2, 4: 4, 5: 4, 6: 4, 7: 1, 10: 8, 11: 8, 12: 8}
+
+// ggufReader reads the little endian GGUF values keeping the first error
+type ggufReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (r *ggufReader) read(v any) {
+	if r.err == nil {
+		r.err = binary.Read(r.r, binary.LittleEndian, v)
+	}
+}
+
+func (r *ggufReader) u32() (v uint32) {
+	r.read(&v)
+	return
+}
+
+func (r *ggufReader) u64() (v uint64) {
+	r.read(&v)
+	return
+}
+
+func (r *ggufReader) str() string {
+	n := r.u64()
+	if r.err != nil {
+		return ""
+	}
+	if n > uint64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	b := make([]byte, n)
+	r.read(b)
+	return string(b)
+}
+
+func (r *ggufReader) skip(n int64) {
+	if r.err != nil {
+		return
+	}
+	// the lengths above the int64 range are negative and would move the reader back
+	if n < 0 || n > int64(r.r.Len()) {
+		r.err = io.ErrUnexpectedEOF
+		return
+	}
+	r.r.Seek(n, io.SeekCurrent)
+}
+
+func (r *ggufReader) skipValue(typ uint32, depth int) {
+	switch typ {
+	case ggufString:
+		r.skip(int64(r.u64()))
+	case ggufArray:
+		if depth == maxGGUFNesting && r.err == nil {
+			r.err = errors.New("GGUF arrays nested too deep")
+			return
+		}
+		elem, count := r.u32(), r.u64()
+		if size, ok := ggufSizes[elem]; ok {
+			if count > uint64(r.r.Len())/uint64(size) {
+				r.err = io.ErrUnexpectedEOF
+				return
+			}
+			r.skip(int64(count) * size)
+			return
+		}
+		for i := uint64(0); i < count && r.err == nil; i++ {
+			r.skipValue(elem, depth+1)
+		}
+	default:
+		size, ok := ggufSizes[typ]
+		if !ok && r.err == nil {
+			r.err = fmt.Errorf("unknown GGUF value type %d", typ)
+		}
+		r.skip(size)
+	}
+}
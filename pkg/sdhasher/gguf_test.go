@@ -0,0 +1,111 @@
+package sdhasher
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// gguf builds a GGUF header
+type gguf []byte
+
+func (g gguf) u32(v uint32) gguf { return binary.LittleEndian.AppendUint32(g, v) }
+func (g gguf) u64(v uint64) gguf { return binary.LittleEndian.AppendUint64(g, v) }
+func (g gguf) str(s string) gguf { return append(g.u64(uint64(len(s))), s...) }
+
+// header returns the start of the header with the tensor and key-value counts
+func header(tensors, kvs uint64) gguf {
+	return gguf("GGUF").u32(3).u64(tensors).u64(kvs)
+}
+
+// ggufFields returns the fields of the header written in one part
+func ggufFields(data []byte) (map[string]string, error) {
+	w := &ggufWriter{}
+	w.Write(data)
+	return w.fields()
+}
+
+func TestGGUF(t *testing.T) {
+	valid := header(2, 4).
+		str("general.name").u32(ggufString).str("test").
+		// an array of strings and a nested array are skipped
+		str("tokenizer.ggml.tokens").u32(ggufArray).u32(ggufString).u64(2).str("a").str("b").
+		str("nested").u32(ggufArray).u32(ggufArray).u64(1).u32(ggufUint32).u64(2).u32(1).u32(2).
+		str("general.architecture").u32(ggufString).str("llama").
+		str("blk.0.attn_q.weight").u32(2).u64(64).u64(64).u32(12).u64(0).
+		str("output.weight").u32(1).u64(16).u32(0).u64(4096)
+	tests := []struct {
+		name string
+		data []byte
+		want map[string]string
+		err  bool
+	}{
+		{"valid", valid, map[string]string{"gguf_architecture": "llama", "gguf_quant": "Q4_K"}, false},
+		{"file type", header(0, 2).str("general.architecture").u32(ggufString).str("flux").
+			str("general.file_type").u32(ggufUint32).u32(15),
+			map[string]string{"gguf_architecture": "flux", "gguf_quant": "Q4_K_M"}, false},
+		{"not gguf", []byte("PK\x03\x04"), map[string]string{"gguf_architecture": "unknown"}, true},
+		{"old version", gguf("GGUF").u32(1), map[string]string{"gguf_architecture": "unknown"}, true},
+		{"empty", header(0, 0), map[string]string{"gguf_architecture": "unknown"}, true},
+		{"huge key count", header(0, math.MaxUint64), map[string]string{"gguf_architecture": "unknown"}, true},
+		{"huge string", header(0, 1).str("general.architecture").u32(ggufString).u64(math.MaxUint64),
+			map[string]string{"gguf_architecture": "unknown"}, true},
+		// the length is negative as int64 and must not move the reader back to the start of the key
+		{"negative string skip", header(0, math.MaxUint64).str("a").u32(ggufString).u64(math.MaxUint64 - 20),
+			map[string]string{"gguf_architecture": "unknown"}, true},
+		{"huge array", header(0, 1).str("a").u32(ggufArray).u32(ggufUint32).u64(math.MaxUint64),
+			map[string]string{"gguf_architecture": "unknown"}, true},
+		{"huge string array", header(0, 1).str("a").u32(ggufArray).u32(ggufString).u64(math.MaxUint64).str("x"),
+			map[string]string{"gguf_architecture": "unknown"}, true},
+		{"unknown type", header(0, 1).str("a").u32(99), map[string]string{"gguf_architecture": "unknown"}, true},
+		{"huge dimensions", header(1, 0).str("w").u32(math.MaxUint32).u64(math.MaxUint64),
+			map[string]string{"gguf_architecture": "unknown"}, true},
+	}
+	for _, tt := range tests {
+		got, err := ggufFields(tt.data)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.err)
+		}
+		if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestGGUFTruncated(t *testing.T) {
+	valid := header(1, 2).
+		str("general.name").u32(ggufString).str("test").
+		str("general.architecture").u32(ggufString).str("llama").
+		str("blk.0.attn_q.weight").u32(2).u64(64).u64(64).u32(12).u64(0)
+	// the header is cut at the size limit, the fields read before the cut are kept
+	for i := 0; i < len(valid); i++ {
+		got, err := ggufFields(valid[:i])
+		if arch := got["gguf_architecture"]; arch != "llama" && (arch != "unknown" || err == nil) {
+			t.Errorf("cut at %d: got %v, %v", i, got, err)
+		}
+	}
+}
+
+func TestGGUFNestedArrays(t *testing.T) {
+	data := header(0, 1).str("a").u32(ggufArray)
+	for i := 0; i < 100000; i++ {
+		data = data.u32(ggufArray).u64(1)
+	}
+	if _, err := ggufFields(data.u32(ggufUint32).u64(0)); err == nil {
+		t.Error("no error for the deeply nested arrays")
+	}
+}
+
+func TestGGUFAllocations(t *testing.T) {
+	data := header(math.MaxUint64, 1).str("a").u32(ggufArray).u32(ggufString).u64(math.MaxUint64).
+		str("x").str("y")
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	ggufFields(data)
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes for a %d byte header", allocated, len(data))
+	}
+}
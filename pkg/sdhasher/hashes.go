@@ -0,0 +1,205 @@
+package sdhasher
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// ExtraHashes are the hashes that can be computed alongside sha256 in the same read pass
+var ExtraHashes = map[string]func() hash.Hash{
+	"blake3": newBLAKE3,
+	"sha1":   sha1.New,
+	"sha512": sha512.New,
+	"md5":    md5.New,
+
+	"model_hash": newLegacyHasher,
+}
+
+// addnetWriter hashes the safetensors payload after the header the same way the Additional Networks extension does
+type addnetWriter struct {
+	hash.Hash
+	header []byte
+	skip   uint64
+}
+
+func newAddnetWriter() *addnetWriter {
+	return &addnetWriter{Hash: sha256.New()}
+}
+
+func (w *addnetWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(w.header) < 8 {
+		c := 8 - len(w.header)
+		if c > len(p) {
+			c = len(p)
+		}
+		w.header = append(w.header, p[:c]...)
+		p = p[c:]
+		if len(w.header) < 8 {
+			return n, nil
+		}
+		w.skip = binary.LittleEndian.Uint64(w.header)
+	}
+	if w.skip > 0 {
+		c := w.skip
+		if c > uint64(len(p)) {
+			c = uint64(len(p))
+		}
+		w.skip -= c
+		p = p[c:]
+	}
+	w.Hash.Write(p)
+	return n, nil
+}
+
+const (
+	legacyOffset = 0x100000
+	legacyLength = 0x10000
+)
+
+// legacyHasher computes the old 8 character webui model hash from the 64 KiB read at 1 MiB offset
+type legacyHasher struct {
+	hash.Hash
+	offset int64
+}
+
+func newLegacyHasher() hash.Hash {
+	return &legacyHasher{Hash: sha256.New()}
+}
+
+func (w *legacyHasher) Write(p []byte) (int, error) {
+	n := int64(len(p))
+	start, end := legacyOffset-w.offset, legacyOffset+legacyLength-w.offset
+	if start < 0 {
+		start = 0
+	}
+	if end > n {
+		end = n
+	}
+	if start < end {
+		w.Hash.Write(p[start:end])
+	}
+	w.offset += n
+	return int(n), nil
+}
+
+func (w *legacyHasher) Sum(b []byte) []byte {
+	return append(b, w.Hash.Sum(nil)[:w.Size()]...)
+}
+
+func (w *legacyHasher) Size() int {
+	return 4
+}
+
+func (w *legacyHasher) Reset() {
+	w.Hash.Reset()
+	w.offset = 0
+}
+
+// AutoV2Field is the entry field of the AutoV2 hash
+const AutoV2Field = "autov2"
+
+// AddAutoV2 stores the first 10 characters of sha256 that the webui and Civitai show as the AutoV2 hash
+func AddAutoV2(entries map[string]Entry) {
+	for k, e := range entries {
+		SetAutoV2(&e)
+		entries[k] = e
+	}
+}
+
+// SetAutoV2 stores the AutoV2 hash in the entry
+func SetAutoV2(e *Entry) {
+	if len(e.SHA256) < 10 {
+		return
+	}
+	if e.Extra == nil {
+		e.Extra = map[string]string{}
+	}
+	e.Extra[AutoV2Field] = e.SHA256[:10]
+}
+
+// maxHeaderSize limits the safetensors header kept in memory, real headers are a few megabytes at most
+const maxHeaderSize = 100 * 1024 * 1024
+
+// headerWriter captures the JSON header of a safetensors file
+type headerWriter struct {
+	buf  []byte
+	size uint64
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(w.buf) < 8 {
+		c := 8 - len(w.buf)
+		if c > len(p) {
+			c = len(p)
+		}
+		w.buf = append(w.buf, p[:c]...)
+		p = p[c:]
+		if len(w.buf) < 8 {
+			return n, nil
+		}
+		w.size = binary.LittleEndian.Uint64(w.buf)
+	}
+	if w.size > maxHeaderSize {
+		return n, nil
+	}
+	if rest := 8 + int(w.size) - len(w.buf); rest > 0 {
+		if rest > len(p) {
+			rest = len(p)
+		}
+		w.buf = append(w.buf, p[:rest]...)
+	}
+	return n, nil
+}
+
+// fields returns the string values of the __metadata__ header fields
+func (w *headerWriter) fields(names []string) map[string]string {
+	if len(w.buf) < 8 || w.size > maxHeaderSize || len(w.buf) < 8+int(w.size) {
+		return nil
+	}
+	var header struct {
+		Metadata map[string]any `json:"__metadata__"`
+	}
+	if json.Unmarshal(w.buf[8:], &header) != nil {
+		return nil
+	}
+	result := map[string]string{}
+	for _, name := range names {
+		if s, ok := header.Metadata[name].(string); ok && s != "" {
+			result[name] = s
+		}
+	}
+	return result
+}
+
+// metadata returns the __metadata__ header field with the JSON strings decoded the same way the webui does
+func (w *headerWriter) metadata() (json.RawMessage, error) {
+	if len(w.buf) < 8 || w.size > maxHeaderSize || len(w.buf) < 8+int(w.size) {
+		return nil, fmt.Errorf("invalid safetensors header")
+	}
+	var header struct {
+		Metadata map[string]any `json:"__metadata__"`
+	}
+	if err := json.Unmarshal(w.buf[8:], &header); err != nil {
+		return nil, err
+	}
+	result := map[string]any{}
+	for k, v := range header.Metadata {
+		result[k] = v
+		if s, ok := v.(string); ok && strings.HasPrefix(s, "{") {
+			var decoded any
+			if json.Unmarshal([]byte(s), &decoded) == nil {
+				result[k] = decoded
+			}
+		}
+	}
+	return json.Marshal(result)
+}
@@ -0,0 +1,81 @@
+package sdhasher
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// pattern returns the test data that doesn't repeat at the powers of two
+func pattern(n int) []byte {
+	result := make([]byte, n)
+	for i := range result {
+		result[i] = byte(i % 251)
+	}
+	return result
+}
+
+// writeParts writes the data to the digest in the parts of the given size
+func writeParts(t *testing.T, d *Digest, data []byte, size int) *Entry {
+	t.Helper()
+	for p := data; len(p) > 0; {
+		n := min(len(p), size)
+		d.Write(p[:n])
+		p = p[n:]
+	}
+	e, err := d.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestAddnet(t *testing.T) {
+	header := []byte(`{"__metadata__":{"ss_sd_model_name":"test"},` +
+		`"w":{"dtype":"U8","shape":[3000],"data_offsets":[0,3000]}}`)
+	data := binary.LittleEndian.AppendUint64(nil, uint64(len(header)))
+	data = append(append(data, header...), pattern(3000)...)
+	const (
+		sha256 = "36956ea60238e1cebe5f3aaf241420aef9ef6ce498ccb774380b8b07d57713a6"
+		addnet = "e8ca4bf83f56152c01649f88bd7c91b15ae8137d9a709572e04fae55894ea75e"
+	)
+	// the parts split the length and the header in different places
+	for _, size := range []int{1, 5, 8, 100, 110, 4096} {
+		d, err := NewDigest(nil, true, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := writeParts(t, d, data, size)
+		if e.SHA256 != sha256 {
+			t.Errorf("parts of %d: got sha256 %s, want %s", size, e.SHA256, sha256)
+		}
+		if e.Addnet != addnet {
+			t.Errorf("parts of %d: got addnet %s, want %s", size, e.Addnet, addnet)
+		}
+	}
+}
+
+func TestLegacyHash(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want string
+	}{
+		{"whole window", legacyOffset + legacyLength + 100, "15ffd73b"},
+		{"partial window", legacyOffset + 100, "19966557"},
+		// the webui hashes the empty read of the short files
+		{"short file", 1000, "e3b0c442"},
+	}
+	for _, tt := range tests {
+		data := pattern(tt.size)
+		// the parts don't line up with the window
+		for _, size := range []int{1000, 4096, 1 << 20, len(data)} {
+			d, err := NewDigest([]string{"model_hash"}, false, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := writeParts(t, d, data, size).Extra["model_hash"]; got != tt.want {
+				t.Errorf("%s in parts of %d: got %s, want %s", tt.name, size, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package sdhasher
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files with the ignore rules of their directories
+const IgnoreFile = ".sdhasherignore"
+
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// parseIgnoreRule parses a line of the ignore file, the syntax is the same as in .gitignore
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, "\\")
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globToRegexp(line)
+	if !anchored {
+		expr = "(?:.*/)?" + expr
+	}
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return ignoreRule{}, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			sb.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// ignorer tracks the ignore rules of the directories visited during the walk, the names are relative to the
+// walked storage
+type ignorer struct {
+	rules map[string][]ignoreRule
+}
+
+// newIgnorer returns the ignorer with the excludes applied to the whole walk
+func newIgnorer(excludes []string) *ignorer {
+	result := &ignorer{rules: map[string][]ignoreRule{}}
+	for _, e := range excludes {
+		if rule, ok := parseIgnoreRule(e); ok {
+			result.rules["."] = append(result.rules["."], rule)
+		}
+	}
+	return result
+}
+
+// load reads the ignore file of the directory if it exists
+func (ig *ignorer) load(fsys fs.FS, dir string) error {
+	f, err := fsys.Open(path.Join(dir, IgnoreFile))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if rule, ok := parseIgnoreRule(s.Text()); ok {
+			ig.rules[dir] = append(ig.rules[dir], rule)
+		}
+	}
+	return s.Err()
+}
+
+// ignored checks the name against the rules of all parent directories, the deepest matching rule wins
+func (ig *ignorer) ignored(name string, isDir bool) bool {
+	var dirs []string
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == "." {
+			break
+		}
+	}
+	result := false
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel := name
+		if dirs[i] != "." {
+			rel = strings.TrimPrefix(name, dirs[i]+"/")
+		}
+		for _, rule := range ig.rules[dirs[i]] {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				result = !rule.negate
+			}
+		}
+	}
+	return result
+}
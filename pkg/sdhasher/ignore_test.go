@@ -0,0 +1,96 @@
+package sdhasher
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestIgnoreRule(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		isDir, want   bool
+	}{
+		{"*.ckpt", "a.ckpt", false, true},
+		{"*.ckpt", "sub/a.ckpt", false, true},
+		{"*.ckpt", "a.safetensors", false, false},
+		{"/top.ckpt", "top.ckpt", false, true},
+		{"/top.ckpt", "sub/top.ckpt", false, false},
+		{"sub/*.ckpt", "sub/a.ckpt", false, true},
+		{"sub/*.ckpt", "other/sub/a.ckpt", false, false},
+		{"sub/*.ckpt", "sub/deep/a.ckpt", false, false},
+		{"tmp/", "tmp", true, true},
+		{"tmp/", "tmp", false, false},
+		{"tmp/", "sub/tmp", true, true},
+		{"**/old/*.ckpt", "old/a.ckpt", false, true},
+		{"**/old/*.ckpt", "x/y/old/a.ckpt", false, true},
+		{"old/**", "old/a/b.ckpt", false, true},
+		{"old/**", "old", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"model?.ckpt", "model1.ckpt", false, true},
+		{"model?.ckpt", "model10.ckpt", false, false},
+		{"[ab].ckpt", "a.ckpt", false, true},
+		{"[ab].ckpt", "c.ckpt", false, false},
+		{"[!ab].ckpt", "c.ckpt", false, true},
+		{"[!ab].ckpt", "a.ckpt", false, false},
+		{"[ab.ckpt", "[ab.ckpt", false, true},
+		{`\#notes.txt`, "#notes.txt", false, true},
+		{`\!important.ckpt`, "!important.ckpt", false, true},
+		{"a.ckpt   ", "a.ckpt", false, true},
+		{"a+b (1).ckpt", "a+b (1).ckpt", false, true},
+	}
+	for _, tt := range tests {
+		rule, ok := parseIgnoreRule(tt.pattern)
+		if !ok {
+			t.Errorf("%q: not parsed", tt.pattern)
+			continue
+		}
+		got := !(rule.dirOnly && !tt.isDir) && rule.re.MatchString(tt.name)
+		if got != tt.want {
+			t.Errorf("%q matching %q (dir %v): got %v, want %v", tt.pattern, tt.name, tt.isDir, got, tt.want)
+		}
+	}
+	for _, line := range []string{"", "   ", "# comment"} {
+		if _, ok := parseIgnoreRule(line); ok {
+			t.Errorf("%q: parsed as a rule", line)
+		}
+	}
+}
+
+func TestIgnorer(t *testing.T) {
+	fsys := fstest.MapFS{
+		IgnoreFile:          {Data: []byte("# the root rules\n*.ckpt\n!keep.ckpt\ncache/\n")},
+		"sub/" + IgnoreFile: {Data: []byte("keep.ckpt\n!other.ckpt\n/local.safetensors\n")},
+	}
+	ig := newIgnorer([]string{"*.tmp"})
+	for _, dir := range []string{".", "sub", "missing"} {
+		if err := ig.load(fsys, dir); err != nil {
+			t.Errorf("%s: %v", dir, err)
+		}
+	}
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"a.ckpt", false, true},
+		{"keep.ckpt", false, false},
+		{"a.safetensors", false, false},
+		{"download.tmp", false, true},
+		{"sub/download.tmp", false, true},
+		{"cache", true, true},
+		{"sub/cache", true, true},
+		{"sub/a.ckpt", false, true},
+		// the rules of the deeper directory override the parent ones
+		{"sub/keep.ckpt", false, true},
+		{"sub/other.ckpt", false, false},
+		{"sub/local.safetensors", false, true},
+		{"sub/deep/local.safetensors", false, false},
+		{"local.safetensors", false, false},
+	}
+	for _, tt := range tests {
+		if got := ig.ignored(tt.name, tt.isDir); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
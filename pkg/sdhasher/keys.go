@@ -0,0 +1,80 @@
+package sdhasher
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LayoutDirs maps the standard webui and ComfyUI model directories to their cache key prefixes
+var LayoutDirs = map[string]string{
+	"Stable-diffusion": "checkpoint/",
+	"Lora":             "lora/",
+	"embeddings":       "textual_inversion/",
+	"VAE":              "vae/",
+	"LyCORIS":          "lora/",
+	"checkpoints":      "checkpoint/",
+	"loras":            "lora/",
+	"hypernetworks":    "hypernet/",
+	"ControlNet":       "controlnet/",
+}
+
+// LayoutPrefix returns the cache key prefix of the model directory name, the names are matched case-insensitively
+func LayoutPrefix(dir string) (string, bool) {
+	for name, prefix := range LayoutDirs {
+		if strings.EqualFold(dir, name) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// NamedModels are the model types the webui keys by the file name without the directories and the extension, mapped
+// to the formats it loads, they're hashed along with the extensions of the other models
+var NamedModels = map[string]map[string]struct{}{
+	"textual_inversion/": {".pt": {}, ".bin": {}, ".safetensors": {}},
+	"hypernet/":          {".pt": {}},
+	"controlnet/":        {".pth": {}, ".pt": {}, ".bin": {}, ".ckpt": {}, ".safetensors": {}},
+	"lora/":              {".pt": {}, ".ckpt": {}, ".safetensors": {}},
+}
+
+// Named reports whether the models under the prefix are keyed by their names
+func Named(prefix string) bool {
+	_, ok := NamedModels[prefix]
+	return ok
+}
+
+// ModelName returns the name of the model stored in the file with the slash separated path
+func ModelName(name string) string {
+	base := path.Base(name)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// Key returns the cache key of the file with the slash separated path relative to the root with the prefix, the
+// named models are keyed by their names
+func Key(prefix, name string) string {
+	if Named(prefix) {
+		return prefix + ModelName(name)
+	}
+	return prefix + name
+}
+
+// ModelFile reports whether the file under the prefix is hashed by its extension, the named models are also hashed in
+// all formats the webui loads for them
+func ModelFile(prefix, name string, extensions map[string]struct{}) bool {
+	ext := strings.ToLower(path.Ext(name))
+	if _, ok := extensions[ext]; ok {
+		return true
+	}
+	_, ok := NamedModels[prefix][ext]
+	return ok
+}
+
+// WantAddnet reports whether the Additional Networks hash of the file under the prefix is needed, the webui looks up
+// the LoRA models only by it so it's always computed for them
+func WantAddnet(prefix, path string, addnet bool) bool {
+	if strings.ToLower(filepath.Ext(path)) != ".safetensors" {
+		return false
+	}
+	return addnet || prefix == "lora/"
+}
@@ -0,0 +1,89 @@
+package sdhasher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// MergeStrategy decides which entry is kept when the merged caches have different entries for the same key
+type MergeStrategy string
+
+const (
+	PreferNewer    MergeStrategy = "prefer-newer-mtime"
+	PreferFirst    MergeStrategy = "prefer-first"
+	FailOnConflict MergeStrategy = "fail-on-conflict"
+)
+
+// Merge combines the caches into one, the sections unknown to sdhasher are taken from the first cache that has them
+func Merge(caches []Cache, strategy MergeStrategy) (Cache, error) {
+	result := Cache{}
+	result.Init()
+	for _, c := range caches {
+		for k, e := range c.Hashes {
+			old, ok := result.Hashes[k]
+			if !ok {
+				result.Hashes[k] = e
+				continue
+			}
+			keep, err := resolve(k, old.MTime, e.MTime, old.SHA256 == e.SHA256, strategy)
+			if err != nil {
+				return result, err
+			}
+			if !keep {
+				result.Hashes[k] = e
+			}
+		}
+		for k, e := range c.HashesAddnet {
+			old, ok := result.HashesAddnet[k]
+			if !ok {
+				result.HashesAddnet[k] = e
+				continue
+			}
+			keep, err := resolve(k, old.MTime, e.MTime, old.SHA256 == e.SHA256, strategy)
+			if err != nil {
+				return result, err
+			}
+			if !keep {
+				result.HashesAddnet[k] = e
+			}
+		}
+		for k, e := range c.SafetensorsMetadata {
+			old, ok := result.SafetensorsMetadata[k]
+			if !ok {
+				result.SafetensorsMetadata[k] = e
+				continue
+			}
+			keep, err := resolve(k, old.MTime, e.MTime, bytes.Equal(old.Value, e.Value), strategy)
+			if err != nil {
+				return result, err
+			}
+			if !keep {
+				result.SafetensorsMetadata[k] = e
+			}
+		}
+		for name, section := range c.other {
+			if result.other == nil {
+				result.other = map[string]json.RawMessage{}
+			}
+			if _, ok := result.other[name]; !ok {
+				result.other[name] = section
+			}
+		}
+	}
+	return result, nil
+}
+
+// resolve returns true if the already merged entry should be kept
+func resolve(key string, oldMTime, newMTime MTime, same bool, strategy MergeStrategy) (bool, error) {
+	if same {
+		return oldMTime >= newMTime, nil
+	}
+	switch strategy {
+	case PreferFirst:
+		return true, nil
+	case FailOnConflict:
+		return false, fmt.Errorf("conflicting entries for %s", key)
+	}
+	return oldMTime >= newMTime, nil
+}
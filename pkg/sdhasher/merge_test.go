@@ -0,0 +1,89 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	const key = "checkpoint/foo.safetensors"
+	tests := []struct {
+		name     string
+		first    Entry
+		second   Entry
+		strategy MergeStrategy
+		want     Entry
+		err      bool
+	}{
+		{"same hash newer second", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "a"}, PreferFirst,
+			Entry{MTime: 2, SHA256: "a"}, false},
+		{"different newer second", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "b"}, PreferNewer,
+			Entry{MTime: 2, SHA256: "b"}, false},
+		{"different older second", Entry{MTime: 2, SHA256: "a"}, Entry{MTime: 1, SHA256: "b"}, PreferNewer,
+			Entry{MTime: 2, SHA256: "a"}, false},
+		{"different same mtime", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 1, SHA256: "b"}, PreferNewer,
+			Entry{MTime: 1, SHA256: "a"}, false},
+		{"prefer first newer second", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "b"}, PreferFirst,
+			Entry{MTime: 1, SHA256: "a"}, false},
+		{"prefer first older second", Entry{MTime: 2, SHA256: "a"}, Entry{MTime: 1, SHA256: "b"}, PreferFirst,
+			Entry{MTime: 2, SHA256: "a"}, false},
+		{"fail same hash", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "a"}, FailOnConflict,
+			Entry{MTime: 2, SHA256: "a"}, false},
+		{"fail different", Entry{MTime: 1, SHA256: "a"}, Entry{MTime: 2, SHA256: "b"}, FailOnConflict,
+			Entry{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Merge([]Cache{
+				{Hashes: map[string]Entry{key: tt.first, "checkpoint/first.safetensors": {SHA256: "first"}}},
+				{Hashes: map[string]Entry{key: tt.second, "checkpoint/second.safetensors": {SHA256: "second"}}},
+			}, tt.strategy)
+			if tt.err {
+				if err == nil {
+					t.Error("no error for the conflicting entries")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e := result.Hashes[key]; e.MTime != tt.want.MTime || e.SHA256 != tt.want.SHA256 {
+				t.Errorf("got %+v, want %+v", e, tt.want)
+			}
+			if len(result.Hashes) != 3 {
+				t.Errorf("got %d entries, want 3: %v", len(result.Hashes), result.Hashes)
+			}
+		})
+	}
+}
+
+func TestMergeSections(t *testing.T) {
+	var first, second Cache
+	if err := json.Unmarshal([]byte(`{"hashes": {}, "hashes-addnet": {"lora/foo.safetensors": `+
+		`{"mtime": 1, "sha256": "old"}}, "safetensors-metadata": {"lora/foo.safetensors": {"mtime": 2, "value": 1}}, `+
+		`"extension": "first"}`), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(`{"hashes": {}, "hashes-addnet": {"lora/foo.safetensors": `+
+		`{"mtime": 2, "sha256": "new"}}, "safetensors-metadata": {"lora/foo.safetensors": {"mtime": 1, "value": 2}}, `+
+		`"extension": "second", "other": "second"}`), &second); err != nil {
+		t.Fatal(err)
+	}
+	result, err := Merge([]Cache{first, second}, PreferNewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha := result.HashesAddnet["lora/foo.safetensors"].SHA256; sha != "new" {
+		t.Errorf("got addnet hash %s, want new", sha)
+	}
+	if v := string(result.SafetensorsMetadata["lora/foo.safetensors"].Value); v != "1" {
+		t.Errorf("got metadata %s, want 1", v)
+	}
+	// the unknown sections are taken from the first cache that has them
+	if v := string(result.other["extension"]); v != `"first"` {
+		t.Errorf("got extension section %s, want first", v)
+	}
+	if v := string(result.other["other"]); v != `"second"` {
+		t.Errorf("got other section %s, want second", v)
+	}
+}
@@ -0,0 +1,75 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ModelTypeField is the Extra field of the model type detected by ModelType
+const ModelTypeField = "model_type"
+
+// UnknownModelType is stored for the files whose type isn't recognized so that they aren't rehashed every time
+const UnknownModelType = "unknown"
+
+// ModelType detects the model type from the tensor names of the safetensors header: sd1, sd2, sdxl, sd3, flux, lora,
+// vae or text_encoder, the digest should be created with metadata enabled
+func (d *Digest) ModelType() string {
+	if d.header == nil {
+		return UnknownModelType
+	}
+	names := d.header.tensorNames()
+	if len(names) == 0 {
+		return UnknownModelType
+	}
+	return detectModelType(names)
+}
+
+// tensorNames returns the names of the tensors in the safetensors header
+func (w *headerWriter) tensorNames() []string {
+	if len(w.buf) < 8 || w.size > maxHeaderSize || len(w.buf) < 8+int(w.size) {
+		return nil
+	}
+	var header map[string]json.RawMessage
+	if json.Unmarshal(w.buf[8:], &header) != nil {
+		return nil
+	}
+	result := make([]string, 0, len(header))
+	for name := range header {
+		if name != "__metadata__" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
+
+func detectModelType(names []string) string {
+	has := func(parts ...string) bool {
+		for _, n := range names {
+			for _, p := range parts {
+				if strings.Contains(n, p) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case has("lora_up.", "lora_down.", "lora_A.", "lora_B.", ".hada_w1_", ".lokr_w1"):
+		return "lora"
+	case has("double_blocks.") && has("single_blocks."):
+		return "flux"
+	case has("joint_blocks."):
+		return "sd3"
+	case has("conditioner.embedders.", "diffusion_model.label_emb."):
+		return "sdxl"
+	case has("cond_stage_model.model.transformer."):
+		return "sd2"
+	case has("diffusion_model.input_blocks."):
+		return "sd1"
+	case has("decoder.conv_in.") && has("encoder.conv_in."):
+		return "vae"
+	case has("text_model.encoder.layers.", "encoder.block.", "transformer.resblocks."):
+		return "text_encoder"
+	}
+	return UnknownModelType
+}
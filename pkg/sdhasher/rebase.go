@@ -0,0 +1,57 @@
+package sdhasher
+
+import "bytes"
+
+// ConflictPolicy decides which entry Rebase keeps for a key changed on both sides
+type ConflictPolicy string
+
+const (
+	KeepNewer  ConflictPolicy = "newer"
+	KeepOurs   ConflictPolicy = "ours"
+	KeepTheirs ConflictPolicy = "theirs"
+)
+
+// Rebase applies the changes made from base to ours on top of theirs, the copy of base changed by someone else in the
+// meantime. The entries added, changed and removed only by us or only by them are all kept, the keys changed on both
+// sides are resolved with the policy. The other sections are taken from theirs
+func Rebase(base, ours, theirs Cache, policy ConflictPolicy) Cache {
+	result := Cache{other: theirs.other}
+	result.Hashes = rebaseSection(base.Hashes, ours.Hashes, theirs.Hashes, sameEntry,
+		func(e Entry) MTime { return e.MTime }, policy)
+	result.HashesAddnet = rebaseSection(base.HashesAddnet, ours.HashesAddnet, theirs.HashesAddnet, sameEntry,
+		func(e Entry) MTime { return e.MTime }, policy)
+	result.SafetensorsMetadata = rebaseSection(base.SafetensorsMetadata, ours.SafetensorsMetadata,
+		theirs.SafetensorsMetadata, func(a, b MetadataEntry) bool {
+			return a.MTime == b.MTime && bytes.Equal(a.Value, b.Value)
+		}, func(e MetadataEntry) MTime { return e.MTime }, policy)
+	return result
+}
+
+func rebaseSection[E any](base, ours, theirs map[string]E, same func(a, b E) bool, mtime func(E) MTime,
+	policy ConflictPolicy) map[string]E {
+	result := make(map[string]E, len(theirs))
+	for k, e := range theirs {
+		result[k] = e
+	}
+	for k, e := range ours {
+		b, inBase := base[k]
+		if inBase && same(b, e) {
+			continue
+		}
+		t, inTheirs := theirs[k]
+		conflict := inBase && !inTheirs || inTheirs && !(inBase && same(b, t)) && !same(t, e)
+		if !conflict || policy == KeepOurs || policy == KeepNewer && (!inTheirs || mtime(e) >= mtime(t)) {
+			result[k] = e
+		}
+	}
+	for k, b := range base {
+		if _, ok := ours[k]; ok {
+			continue
+		}
+		// the entries changed by them after we removed them are kept
+		if t, ok := theirs[k]; ok && same(t, b) {
+			delete(result, k)
+		}
+	}
+	return result
+}
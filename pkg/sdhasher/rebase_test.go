@@ -0,0 +1,97 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var policies = []ConflictPolicy{KeepNewer, KeepOurs, KeepTheirs}
+
+// samePolicies is the expected entry of a key that isn't in conflict
+func samePolicies(e *Entry) map[ConflictPolicy]*Entry {
+	return map[ConflictPolicy]*Entry{KeepNewer: e, KeepOurs: e, KeepTheirs: e}
+}
+
+func TestRebase(t *testing.T) {
+	const key = "checkpoint/foo.safetensors"
+	a := &Entry{MTime: 1, SHA256: "a"}
+	b := &Entry{MTime: 2, SHA256: "b"}
+	c := &Entry{MTime: 3, SHA256: "c"}
+	newerB := &Entry{MTime: 4, SHA256: "b"}
+	// nil is the missing entry
+	tests := []struct {
+		name               string
+		base, ours, theirs *Entry
+		want               map[ConflictPolicy]*Entry
+	}{
+		{"added by us", nil, b, nil, samePolicies(b)},
+		{"added by them", nil, nil, c, samePolicies(c)},
+		{"added the same", nil, b, b, samePolicies(b)},
+		{"added different", nil, b, c, map[ConflictPolicy]*Entry{KeepNewer: c, KeepOurs: b, KeepTheirs: c}},
+		{"added different newer ours", nil, newerB, c,
+			map[ConflictPolicy]*Entry{KeepNewer: newerB, KeepOurs: newerB, KeepTheirs: c}},
+		{"changed by us", a, b, a, samePolicies(b)},
+		{"changed by them", a, a, c, samePolicies(c)},
+		{"changed the same", a, b, b, samePolicies(b)},
+		{"changed different", a, b, c, map[ConflictPolicy]*Entry{KeepNewer: c, KeepOurs: b, KeepTheirs: c}},
+		{"changed different newer ours", a, newerB, c,
+			map[ConflictPolicy]*Entry{KeepNewer: newerB, KeepOurs: newerB, KeepTheirs: c}},
+		{"removed by us", a, nil, a, samePolicies(nil)},
+		{"removed by us changed by them", a, nil, c, samePolicies(c)},
+		{"removed by them", a, a, nil, samePolicies(nil)},
+		{"removed by them changed by us", a, b, nil, map[ConflictPolicy]*Entry{KeepNewer: b, KeepOurs: b}},
+		{"removed by both", a, nil, nil, samePolicies(nil)},
+	}
+	section := func(e *Entry) map[string]Entry {
+		if e == nil {
+			return map[string]Entry{}
+		}
+		return map[string]Entry{key: *e}
+	}
+	for _, tt := range tests {
+		for _, policy := range policies {
+			t.Run(tt.name+"/"+string(policy), func(t *testing.T) {
+				result := Rebase(Cache{Hashes: section(tt.base), HashesAddnet: section(tt.base)},
+					Cache{Hashes: section(tt.ours), HashesAddnet: section(tt.ours)},
+					Cache{Hashes: section(tt.theirs), HashesAddnet: section(tt.theirs)}, policy)
+				want := tt.want[policy]
+				for name, s := range map[string]map[string]Entry{"hashes": result.Hashes,
+					"hashes-addnet": result.HashesAddnet} {
+					e, ok := s[key]
+					switch {
+					case want == nil && ok:
+						t.Errorf("%s: got %+v, want no entry", name, e)
+					case want != nil && !ok:
+						t.Errorf("%s: got no entry, want %+v", name, *want)
+					case want != nil && (e.MTime != want.MTime || e.SHA256 != want.SHA256):
+						t.Errorf("%s: got %+v, want %+v", name, e, *want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRebaseSections(t *testing.T) {
+	var base, ours, theirs Cache
+	for data, c := range map[string]*Cache{
+		`{"hashes": {}, "safetensors-metadata": {"lora/foo.safetensors": {"mtime": 1, "value": 1}}, ` +
+			`"extension": "base"}`: &base,
+		`{"hashes": {}, "safetensors-metadata": {"lora/foo.safetensors": {"mtime": 2, "value": 2}}, ` +
+			`"extension": "ours"}`: &ours,
+		`{"hashes": {}, "safetensors-metadata": {"lora/foo.safetensors": {"mtime": 1, "value": 1}}, ` +
+			`"extension": "theirs"}`: &theirs,
+	} {
+		if err := json.Unmarshal([]byte(data), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := Rebase(base, ours, theirs, KeepTheirs)
+	if v := string(result.SafetensorsMetadata["lora/foo.safetensors"].Value); v != "2" {
+		t.Errorf("got metadata %s, want 2", v)
+	}
+	// the sections unknown to sdhasher are written by the webui so they're taken from theirs
+	if v := string(result.other["extension"]); v != `"theirs"` {
+		t.Errorf("got extension section %s, want theirs", v)
+	}
+}
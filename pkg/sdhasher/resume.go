@@ -0,0 +1,105 @@
+package sdhasher
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// ErrNotResumable is returned when the state of some of the digest hashes can't be saved
+var ErrNotResumable = errors.New("the digest state can't be saved")
+
+// MarshalBinary saves the state of the digest so that hashing can be continued with UnmarshalBinary, only sha256, the
+// addnet hash and the extra hashes implementing encoding.BinaryMarshaler are supported
+func (d *Digest) MarshalBinary() ([]byte, error) {
+	if d.header != nil || d.gguf != nil {
+		return nil, ErrNotResumable
+	}
+	hashes := map[string]hash.Hash{"sha256": d.sha256}
+	if d.addnet != nil {
+		hashes["addnet"] = d.addnet
+	}
+	for name, h := range d.extra {
+		hashes["extra:"+name] = h
+	}
+	state := map[string][]byte{}
+	for name, h := range hashes {
+		m, ok := h.(encoding.BinaryMarshaler)
+		if !ok {
+			return nil, ErrNotResumable
+		}
+		b, err := m.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		state[name] = b
+	}
+	return json.Marshal(state)
+}
+
+// UnmarshalBinary restores the state saved by MarshalBinary, the digest should be created with the same options
+func (d *Digest) UnmarshalBinary(data []byte) error {
+	var state map[string][]byte
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	hashes := map[string]hash.Hash{"sha256": d.sha256}
+	if d.addnet != nil {
+		hashes["addnet"] = d.addnet
+	}
+	for name, h := range d.extra {
+		hashes["extra:"+name] = h
+	}
+	if d.header != nil || d.gguf != nil || len(state) != len(hashes) {
+		return errors.New("the saved state doesn't match the digest")
+	}
+	for name, h := range hashes {
+		u, ok := h.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return ErrNotResumable
+		}
+		b, ok := state[name]
+		if !ok {
+			return fmt.Errorf("no saved state for %s", name)
+		}
+		if err := u.UnmarshalBinary(b); err != nil {
+			return fmt.Errorf("error restoring %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// addnetState is the saved state of addnetWriter
+type addnetState struct {
+	Hash   []byte `json:"hash"`
+	Header []byte `json:"header"`
+	Skip   uint64 `json:"skip"`
+}
+
+func (w *addnetWriter) MarshalBinary() ([]byte, error) {
+	m, ok := w.Hash.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, ErrNotResumable
+	}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(addnetState{Hash: b, Header: w.header, Skip: w.skip})
+}
+
+func (w *addnetWriter) UnmarshalBinary(data []byte) error {
+	var state addnetState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	u, ok := w.Hash.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return ErrNotResumable
+	}
+	w.header = state.Header
+	w.skip = state.Skip
+	return u.UnmarshalBinary(state.Hash)
+}
@@ -0,0 +1,74 @@
+package sdhasher
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestDigestResume(t *testing.T) {
+	header := []byte(`{"__metadata__":{"ss_sd_model_name":"test"},` +
+		`"w":{"dtype":"U8","shape":[3000],"data_offsets":[0,3000]}}`)
+	data := binary.LittleEndian.AppendUint64(nil, uint64(len(header)))
+	data = append(append(data, header...), pattern(3000)...)
+	full, err := NewDigest([]string{"sha512"}, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := writeParts(t, full, data, len(data))
+	if want.Addnet == "" || want.Extra["sha512"] == "" {
+		t.Fatalf("no addnet or sha512 hash: %+v", want)
+	}
+	// the hashing is interrupted inside the length, the header and the tensor data
+	for _, offset := range []int{0, 5, 8, 50, len(header) + 8, 2000, len(data)} {
+		d, err := NewDigest([]string{"sha512"}, true, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Write(data[:offset])
+		state, err := d.MarshalBinary()
+		if err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		resumed, err := NewDigest([]string{"sha512"}, true, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := resumed.UnmarshalBinary(state); err != nil {
+			t.Fatalf("offset %d: %v", offset, err)
+		}
+		got := writeParts(t, resumed, data[offset:], 1000)
+		if got.SHA256 != want.SHA256 || got.Addnet != want.Addnet || got.Extra["sha512"] != want.Extra["sha512"] {
+			t.Errorf("offset %d: got %s %s %s, want %s %s %s", offset, got.SHA256, got.Addnet, got.Extra["sha512"],
+				want.SHA256, want.Addnet, want.Extra["sha512"])
+		}
+	}
+}
+
+func TestDigestResumeMismatch(t *testing.T) {
+	d, err := NewDigest(nil, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Write(pattern(100))
+	state, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the state of the addnet hash has nowhere to go
+	plain, err := NewDigest(nil, false, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.UnmarshalBinary(state); err == nil {
+		t.Error("no error restoring the state into a digest without the addnet hash")
+	}
+	// the metadata is parsed from the whole header and can't be saved
+	metadata, err := NewDigest(nil, true, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := metadata.MarshalBinary(); !errors.Is(err, ErrNotResumable) {
+		t.Errorf("got %v, want ErrNotResumable", err)
+	}
+}
@@ -0,0 +1,296 @@
+package sdhasher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Hasher hashes single files
+type Hasher struct {
+	// ExtraHashes are the names of ExtraHashes to compute alongside sha256
+	ExtraHashes []string
+	// Addnet enables the Additional Networks hash of the safetensors files
+	Addnet bool
+	// Metadata enables reading the metadata of the safetensors files
+	Metadata bool
+	// GGUF enables storing the architecture and the quantization type of the GGUF files in Extra
+	GGUF bool
+	// Kohya enables storing the base model fields kohya sd-scripts write to the safetensors files in Extra
+	Kohya bool
+	// ModelType enables storing the model type detected from the tensor names of the safetensors files in Extra
+	ModelType bool
+	// BufferSize is the read buffer size, 1 MiB if not set
+	BufferSize int
+	// Buffers is the number of read buffers, with more than one the next chunk is read while the previous one is
+	// hashed, 2 if not set
+	Buffers int
+	// MTime is how the modification times are stored and compared, MarginMTime if not set
+	MTime MTimeMode
+}
+
+// HashFile hashes the file, the key of the returned entry isn't set
+func (h *Hasher) HashFile(ctx context.Context, path string) (*Entry, error) {
+	return h.hashFile(ctx, path, WantAddnet("", path, h.Addnet))
+}
+
+// hashFile hashes the file with the Additional Networks hash if addnet is set, it's always computed for the LoRA
+// models found by Scan
+func (h *Hasher) hashFile(ctx context.Context, path string, addnet bool) (*Entry, error) {
+	d, err := h.Digest(path, addnet)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := h.BufferSize
+	if size <= 0 {
+		size = 1 << 20
+	}
+	n := h.Buffers
+	if n <= 0 {
+		n = 2
+	}
+	bufs := make([][]byte, n)
+	for i := range bufs {
+		bufs[i] = make([]byte, size)
+	}
+	err = ReadChunks(f, bufs, func(p []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		_, err := d.Write(p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	result, _ := h.Entry(d, path, fi) // invalid metadata isn't an error for the caller
+	return result, nil
+}
+
+// Digest creates the digest of the file computing the hashes and reading the fields enabled in the Hasher, addnet
+// enables the Additional Networks hash. It's for hashing the files read by the caller, HashFile reads them itself.
+func (h *Hasher) Digest(path string, addnet bool) (*Digest, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	header := ext == ".safetensors" && (h.Metadata || h.Kohya || h.ModelType)
+	return NewDigest(h.ExtraHashes, addnet, header, h.GGUF && ext == ".gguf")
+}
+
+// Entry returns the entry of the file with the content written to the digest, the error is about the invalid metadata
+// in which case it's stored empty
+func (h *Hasher) Entry(d *Digest, path string, fi fs.FileInfo) (*Entry, error) {
+	result, err := d.Entry()
+	safetensors := strings.ToLower(filepath.Ext(path)) == ".safetensors"
+	if h.Kohya && safetensors {
+		for name, value := range d.MetadataFields(KohyaFields) {
+			if result.Extra == nil {
+				result.Extra = map[string]string{}
+			}
+			result.Extra[name] = value
+		}
+	}
+	if h.ModelType && safetensors {
+		if result.Extra == nil {
+			result.Extra = map[string]string{}
+		}
+		result.Extra[ModelTypeField] = d.ModelType()
+	}
+	// the header is also read for the other fields
+	if !h.Metadata {
+		result.Metadata = nil
+	}
+	result.MTime = h.MTime.FileMTime(fi)
+	result.Size = fi.Size()
+	result.Path = path
+	return result, err
+}
+
+// Root is a models directory, the cache keys of its files start with Prefix. The models with the prefixes in
+// NamedModels are keyed by their names like the webui does, the rest by their paths relative to the root. The
+// standard model directories and their prefixes are in LayoutDirs.
+type Root struct {
+	Path   string
+	Prefix string
+}
+
+// Options configure Scan
+type Options struct {
+	Hasher
+	Roots []Root
+	// Extensions of the model files, .safetensors, .ckpt and .gguf if not set, the named models are also hashed in
+	// the formats the webui loads for them
+	Extensions []string
+	// Excludes are the patterns of the files to skip in the .gitignore syntax, the .sdhasherignore files of the
+	// directories are also read
+	Excludes []string
+	// SkipSymlinks skips the symlinks, the linked files are hashed and the linked directories are walked otherwise
+	SkipSymlinks bool
+	// Workers is the number of files hashed in parallel, the number of CPUs if not set
+	Workers int
+	// Previous is the existing cache, the unchanged files aren't rehashed
+	Previous *Cache
+}
+
+type scanTask struct {
+	path   string
+	key    string
+	addnet bool
+}
+
+// wanted returns the optional parts of the entry of the file under the prefix
+func (h *Hasher) wanted(prefix, path string) Wanted {
+	ext := strings.ToLower(filepath.Ext(path))
+	return Wanted{
+		Metadata:    h.Metadata && ext == ".safetensors",
+		Addnet:      WantAddnet(prefix, path, h.Addnet),
+		ModelType:   h.ModelType && ext == ".safetensors",
+		GGUF:        h.GGUF && ext == ".gguf",
+		ExtraHashes: h.ExtraHashes,
+		MTime:       h.MTime,
+	}
+}
+
+// Scan hashes the model files in the roots and returns the cache containing them, the entries of the missing files
+// under the prefixes of the roots are dropped and the rest of the previous entries are kept. The files are rehashed
+// if they changed or their entries lack the hashes and fields requested by the Hasher. The files of the nested roots
+// are only hashed by the innermost one, of the named models with the same name the first file in the lexical order
+// is hashed like the webui loads it. The errors of the files that couldn't be hashed are returned together with the
+// rest of the cache.
+func Scan(ctx context.Context, opts Options) (Cache, error) {
+	result := Cache{}
+	if opts.Previous != nil {
+		result = opts.Previous.Clone()
+	}
+	result.Init()
+	extensions := map[string]struct{}{".safetensors": {}, ".ckpt": {}, ".gguf": {}}
+	if len(opts.Extensions) > 0 {
+		extensions = map[string]struct{}{}
+		for _, e := range opts.Extensions {
+			extensions[strings.ToLower(e)] = struct{}{}
+		}
+	}
+	rootDirs := map[string]bool{}
+	for _, r := range opts.Roots {
+		rootDirs[filepath.Clean(r.Path)] = true
+	}
+	var tasks []scanTask
+	var errs []error
+	found := map[string]bool{}
+	visited := map[string]bool{}
+	// the entries of the roots that couldn't be read are kept
+	var prefixes []string
+	for _, r := range opts.Roots {
+		if _, err := os.Stat(r.Path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		prefixes = append(prefixes, r.Prefix)
+		rootPath := filepath.Clean(r.Path)
+		walkOpts := WalkOptions{Excludes: opts.Excludes, SkipSymlinks: opts.SkipSymlinks, Visited: visited,
+			Resolve: func(name string) (string, error) {
+				return filepath.EvalSymlinks(filepath.Join(rootPath, filepath.FromSlash(name)))
+			}}
+		err := Walk(os.DirFS(rootPath), walkOpts, func(name string, d fs.DirEntry, err error) error {
+			path := filepath.Join(rootPath, filepath.FromSlash(name))
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if d.IsDir() {
+				// the nested roots are walked on their own
+				if name != "." && rootDirs[path] {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			if !ModelFile(r.Prefix, name, extensions) {
+				return nil
+			}
+			// the keys of the webui use forward slashes on all platforms like the names of fs.FS
+			key := Key(r.Prefix, name)
+			if found[key] {
+				return nil
+			}
+			found[key] = true
+			fi, err := d.Info()
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			if _, ok := result.Hashes[key]; ok && result.Outdated(key, fi, opts.wanted(r.Prefix, path)) == "" {
+				return nil
+			}
+			tasks = append(tasks, scanTask{path: path, key: key, addnet: WantAddnet(r.Prefix, path, opts.Addnet)})
+			return ctx.Err()
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	for k := range result.Hashes {
+		if !found[k] && underPrefix(k, prefixes) {
+			result.Remove(k)
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	taskChan := make(chan scanTask)
+	var mu sync.Mutex
+	var hashed []*Entry
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range taskChan {
+				e, err := opts.hashFile(ctx, t.path, t.addnet)
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("error hashing %s: %w", t.path, err))
+				} else {
+					e.Key = t.key
+					hashed = append(hashed, e)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
+		taskChan <- t
+	}
+	close(taskChan)
+	wg.Wait()
+	result.Apply(hashed)
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	return result, errors.Join(errs...)
+}
+
+func underPrefix(key string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package sdhasher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeModel(t *testing.T, path, content string) string {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	models, loras := filepath.Join(dir, "models"), filepath.Join(dir, "loras")
+	foo := writeModel(t, filepath.Join(models, "foo.safetensors"), "foo")
+	bar := writeModel(t, filepath.Join(models, "sub", "bar.ckpt"), "bar")
+	writeModel(t, filepath.Join(models, "notes.txt"), "notes")
+	baz := writeModel(t, filepath.Join(loras, "baz.safetensors"), "baz")
+	result, err := Scan(context.Background(), Options{Roots: []Root{
+		{Path: models, Prefix: "checkpoint/"},
+		{Path: loras, Prefix: "lora/"},
+	}, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"checkpoint/foo.safetensors": foo,
+		"checkpoint/sub/bar.ckpt":    bar,
+		// the LoRA models are keyed by their names like the webui does
+		"lora/baz": baz,
+	}
+	if len(result.Hashes) != len(want) {
+		t.Fatalf("got %d entries, want %d: %v", len(result.Hashes), len(want), result.Hashes)
+	}
+	for k, sha := range want {
+		e, ok := result.Hashes[k]
+		if !ok {
+			t.Errorf("no entry for %s", k)
+			continue
+		}
+		if e.SHA256 != sha {
+			t.Errorf("%s: got sha256 %s, want %s", k, e.SHA256, sha)
+		}
+		if e.Size != 3 {
+			t.Errorf("%s: got size %d, want 3", k, e.Size)
+		}
+	}
+	// the webui looks up the LoRA models only by the addnet hash
+	if _, ok := result.HashesAddnet["lora/baz"]; !ok {
+		t.Error("no addnet hash for the LoRA model")
+	}
+	if _, ok := result.HashesAddnet["checkpoint/foo.safetensors"]; ok {
+		t.Error("addnet hash for the checkpoint without Addnet")
+	}
+}
+
+func TestScanKeys(t *testing.T) {
+	dir := t.TempDir()
+	models := filepath.Join(dir, "models")
+	checkpoint := writeModel(t, filepath.Join(models, "Stable-diffusion", "foo.safetensors"), "checkpoint")
+	first := writeModel(t, filepath.Join(models, "Lora", "a", "style.safetensors"), "first")
+	writeModel(t, filepath.Join(models, "Lora", "b", "style.safetensors"), "second")
+	embedding := writeModel(t, filepath.Join(models, "embeddings", "sub", "face.pt"), "embedding")
+	writeModel(t, filepath.Join(models, "Stable-diffusion", "notes.pt"), "notes")
+	other := writeModel(t, filepath.Join(models, "other.ckpt"), "other")
+	roots := []Root{{Path: models}}
+	for _, name := range []string{"Stable-diffusion", "Lora", "embeddings"} {
+		prefix, ok := LayoutPrefix(strings.ToLower(name))
+		if !ok {
+			t.Fatalf("no prefix for %s", name)
+		}
+		roots = append(roots, Root{Path: filepath.Join(models, name), Prefix: prefix})
+	}
+	result, err := Scan(context.Background(), Options{Roots: roots})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for k, e := range result.Hashes {
+		got[k] = e.SHA256
+	}
+	// the files of the nested roots are keyed only by them, of the LoRA models with the same name the first one is
+	// hashed and the .pt files are only hashed as embeddings
+	want := map[string]string{
+		"checkpoint/foo.safetensors": checkpoint,
+		"lora/style":                 first,
+		"textual_inversion/face":     embedding,
+		"other.ckpt":                 other,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScanRehash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.safetensors")
+	sha := writeModel(t, path, "foo")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := Entry{MTime: MarginMTime.FileMTime(fi), SHA256: "cached", Size: fi.Size()}
+	tests := []struct {
+		name   string
+		hasher Hasher
+		entry  Entry
+		want   string
+	}{
+		{"up to date", Hasher{}, cached, "cached"},
+		{"size changed", Hasher{}, Entry{MTime: cached.MTime, SHA256: "cached", Size: 1}, sha},
+		{"modified", Hasher{}, Entry{MTime: cached.MTime - 10, SHA256: "cached", Size: fi.Size()}, sha},
+		{"no extra hash", Hasher{ExtraHashes: []string{"sha512"}}, cached, sha},
+		{"no addnet hash", Hasher{Addnet: true}, cached, sha},
+		{"no metadata", Hasher{Metadata: true}, cached, sha},
+	}
+	for _, tt := range tests {
+		previous := Cache{Hashes: map[string]Entry{"foo.safetensors": tt.entry}}
+		result, err := Scan(context.Background(), Options{Roots: []Root{{Path: dir}}, Hasher: tt.hasher,
+			Previous: &previous})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := result.Hashes["foo.safetensors"].SHA256; got != tt.want {
+			t.Errorf("%s: got sha256 %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanPrevious(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "foo.safetensors")
+	writeModel(t, path, "foo")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := Cache{Hashes: map[string]Entry{
+		// the unchanged file isn't rehashed so its entry is kept as is
+		"checkpoint/foo.safetensors":  {MTime: MarginMTime.FileMTime(fi), SHA256: "cached", Size: fi.Size()},
+		"checkpoint/gone.safetensors": {SHA256: "gone"},
+		"lora/other.safetensors":      {SHA256: "other"},
+	}}
+	result, err := Scan(context.Background(), Options{Roots: []Root{{Path: dir, Prefix: "checkpoint/"}},
+		Previous: &previous})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha := result.Hashes["checkpoint/foo.safetensors"].SHA256; sha != "cached" {
+		t.Errorf("unchanged file was rehashed, got sha256 %s", sha)
+	}
+	if _, ok := result.Hashes["checkpoint/gone.safetensors"]; ok {
+		t.Error("entry of the missing file wasn't removed")
+	}
+	if _, ok := result.Hashes["lora/other.safetensors"]; !ok {
+		t.Error("entry outside of the scanned roots was removed")
+	}
+	if _, ok := previous.Hashes["checkpoint/gone.safetensors"]; !ok {
+		t.Error("previous cache was modified")
+	}
+}
+
+func TestScanMissingRoot(t *testing.T) {
+	previous := Cache{Hashes: map[string]Entry{"checkpoint/foo.safetensors": {SHA256: "foo"}}}
+	result, err := Scan(context.Background(), Options{
+		Roots:    []Root{{Path: filepath.Join(t.TempDir(), "missing"), Prefix: "checkpoint/"}},
+		Previous: &previous,
+	})
+	if err == nil {
+		t.Error("no error for the missing root")
+	}
+	if _, ok := result.Hashes["checkpoint/foo.safetensors"]; !ok {
+		t.Error("entry of the missing root was removed")
+	}
+}
+
+func TestHashFileFields(t *testing.T) {
+	header := `{"__metadata__":{"ss_sd_model_name":"base.ckpt"},` +
+		`"w":{"dtype":"U8","shape":[3],"data_offsets":[0,3]}}`
+	path := filepath.Join(t.TempDir(), "lora.safetensors")
+	writeModel(t, path, string(binary.LittleEndian.AppendUint64(nil, uint64(len(header))))+header+"abc")
+	tests := []struct {
+		name      string
+		hasher    Hasher
+		metadata  bool
+		kohya     bool
+		modelType bool
+	}{
+		{"none", Hasher{}, false, false, false},
+		{"metadata", Hasher{Metadata: true}, true, false, false},
+		// the header is read for the fields without storing the metadata
+		{"kohya", Hasher{Kohya: true}, false, true, false},
+		{"model type", Hasher{ModelType: true}, false, false, true},
+		{"all", Hasher{Metadata: true, Kohya: true, ModelType: true}, true, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := tt.hasher.HashFile(context.Background(), path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (e.Metadata != nil) != tt.metadata {
+				t.Errorf("got metadata %s", e.Metadata)
+			}
+			if got := e.Extra["ss_sd_model_name"]; (got == "base.ckpt") != tt.kohya {
+				t.Errorf("got kohya model name %q", got)
+			}
+			if _, ok := e.Extra[ModelTypeField]; ok != tt.modelType {
+				t.Errorf("got model type %v", ok)
+			}
+			if e.Path != path || e.Size != int64(8+len(header)+3) {
+				t.Errorf("got path %s and size %d", e.Path, e.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package sdhasher
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Problem is an issue found by Validate
+type Problem struct {
+	Section string `json:"section"`
+	Key     string `json:"key,omitempty"`
+	Message string `json:"message"`
+	// Fixed is set when the entry was repaired, the entries that can't be repaired are dropped
+	Fixed bool `json:"fixed"`
+}
+
+func (p Problem) String() string {
+	action := "dropped"
+	if p.Fixed {
+		action = "fixed"
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s (%s)", p.Section, p.Message, action)
+	}
+	return fmt.Sprintf("%s[%s]: %s (%s)", p.Section, p.Key, p.Message, action)
+}
+
+// Validate parses the cache file leniently, the entries that don't have the expected shape are repaired when possible
+// and dropped otherwise, only a file that isn't a JSON object is an error. The entries with a broken mtime get 0 so
+// that their files are rehashed
+func Validate(data []byte) (Cache, []Problem, error) {
+	var result Cache
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return result, nil, err
+	}
+	if sections == nil {
+		return result, nil, fmt.Errorf("the cache isn't a JSON object")
+	}
+	var problems []Problem
+	for _, name := range []string{"hashes", "hashes-addnet", "safetensors-metadata"} {
+		raw, ok := sections[name]
+		if !ok {
+			continue
+		}
+		var entries map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &entries); err != nil || entries == nil {
+			problems = append(problems, Problem{Section: name, Message: "the section isn't an object"})
+			delete(sections, name)
+			continue
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			raw := entries[key]
+			var messages []string
+			var err error
+			if key == "" {
+				err = fmt.Errorf("empty key")
+			} else if name == "safetensors-metadata" {
+				entries[key], messages, err = validateMetadata(raw)
+			} else {
+				entries[key], messages, err = validateEntry(raw)
+			}
+			if err != nil {
+				problems = append(problems, Problem{Section: name, Key: key, Message: err.Error()})
+				delete(entries, key)
+				continue
+			}
+			for _, m := range messages {
+				problems = append(problems, Problem{Section: name, Key: key, Message: m, Fixed: true})
+			}
+		}
+		data, err := json.Marshal(entries)
+		if err != nil {
+			return result, nil, err
+		}
+		sections[name] = data
+	}
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return result, nil, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, nil, err
+	}
+	result.Init()
+	return result, problems, nil
+}
+
+// validateEntry checks a hash entry, the messages describe the repaired fields
+func validateEntry(raw json.RawMessage) (json.RawMessage, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, nil, fmt.Errorf("the entry isn't an object")
+	}
+	var hash string
+	if err := json.Unmarshal(fields["sha256"], &hash); err != nil {
+		return nil, nil, fmt.Errorf("missing sha256")
+	}
+	var messages []string
+	if fixed := strings.ToLower(strings.TrimSpace(hash)); fixed != hash {
+		messages = append(messages, fmt.Sprintf("sha256 %q isn't lowercase hex", hash))
+		hash = fixed
+	}
+	if !isSHA256(hash) {
+		return nil, nil, fmt.Errorf("invalid sha256 %q", hash)
+	}
+	fields["sha256"], _ = json.Marshal(hash)
+	if m := fixMTime(fields); m != "" {
+		messages = append(messages, m)
+	}
+	if raw, ok := fields["size"]; ok {
+		var size int64
+		if err := json.Unmarshal(raw, &size); err != nil || size < 0 {
+			messages = append(messages, fmt.Sprintf("invalid size %s, removed", raw))
+			delete(fields, "size")
+		}
+	}
+	result, err := json.Marshal(fields)
+	return result, messages, err
+}
+
+// validateMetadata checks a safetensors metadata entry
+func validateMetadata(raw json.RawMessage) (json.RawMessage, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil || fields == nil {
+		return nil, nil, fmt.Errorf("the entry isn't an object")
+	}
+	if _, ok := fields["value"]; !ok {
+		return nil, nil, fmt.Errorf("missing value")
+	}
+	var messages []string
+	if m := fixMTime(fields); m != "" {
+		messages = append(messages, m)
+	}
+	result, err := json.Marshal(fields)
+	return result, messages, err
+}
+
+// fixMTime converts the mtime given as a string to a number and replaces the missing or invalid one with 0
+func fixMTime(fields map[string]json.RawMessage) string {
+	raw, ok := fields["mtime"]
+	var mtime float64
+	if ok && string(raw) != "null" && json.Unmarshal(raw, &mtime) == nil && mtime >= 0 {
+		return ""
+	}
+	message := "missing mtime, the file will be rehashed"
+	if ok {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			if v, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil && v >= 0 && !math.IsInf(v, 0) &&
+				!math.IsNaN(v) {
+				fields["mtime"], _ = json.Marshal(v)
+				return fmt.Sprintf("mtime %s is a string", raw)
+			}
+		}
+		message = fmt.Sprintf("invalid mtime %s, the file will be rehashed", raw)
+	}
+	fields["mtime"] = json.RawMessage("0")
+	return message
+}
+
+func isSHA256(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
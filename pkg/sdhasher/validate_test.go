@@ -0,0 +1,130 @@
+package sdhasher
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const (
+		key  = "checkpoint/foo.safetensors"
+		hash = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	)
+	tests := []struct {
+		name  string
+		entry string
+		// want is nil for the dropped entries
+		want  *Entry
+		fixed bool
+	}{
+		{"valid", `{"mtime": 1.5, "sha256": "` + hash + `", "size": 4}`, &Entry{MTime: 1.5, SHA256: hash, Size: 4},
+			false},
+		{"uppercase sha256", `{"mtime": 1, "sha256": " ` + strings.ToUpper(hash) + `"}`,
+			&Entry{MTime: 1, SHA256: hash}, true},
+		{"short sha256", `{"mtime": 1, "sha256": "9f86d081"}`, nil, false},
+		{"non-hex sha256", `{"mtime": 1, "sha256": "` + strings.Repeat("g", 64) + `"}`, nil, false},
+		{"missing sha256", `{"mtime": 1}`, nil, false},
+		{"numeric sha256", `{"mtime": 1, "sha256": 1}`, nil, false},
+		{"not an object", `"` + hash + `"`, nil, false},
+		{"null", `null`, nil, false},
+		{"string mtime", `{"mtime": "12.5", "sha256": "` + hash + `"}`, &Entry{MTime: 12.5, SHA256: hash}, true},
+		{"missing mtime", `{"sha256": "` + hash + `"}`, &Entry{SHA256: hash}, true},
+		{"null mtime", `{"mtime": null, "sha256": "` + hash + `"}`, &Entry{SHA256: hash}, true},
+		{"negative mtime", `{"mtime": -1, "sha256": "` + hash + `"}`, &Entry{SHA256: hash}, true},
+		{"infinite mtime", `{"mtime": "inf", "sha256": "` + hash + `"}`, &Entry{SHA256: hash}, true},
+		{"invalid mtime", `{"mtime": "yesterday", "sha256": "` + hash + `"}`, &Entry{SHA256: hash}, true},
+		{"negative size", `{"mtime": 1, "sha256": "` + hash + `", "size": -1}`, &Entry{MTime: 1, SHA256: hash},
+			true},
+		{"string size", `{"mtime": 1, "sha256": "` + hash + `", "size": "4"}`, &Entry{MTime: 1, SHA256: hash},
+			true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, section := range []string{"hashes", "hashes-addnet"} {
+				c, problems, err := Validate([]byte(`{"` + section + `": {"` + key + `": ` + tt.entry + `}}`))
+				if err != nil {
+					t.Fatal(err)
+				}
+				entries := c.Hashes
+				if section == "hashes-addnet" {
+					entries = c.HashesAddnet
+				}
+				e, ok := entries[key]
+				switch {
+				case tt.want == nil && ok:
+					t.Errorf("%s: got %+v, want no entry", section, e)
+				case tt.want != nil && !ok:
+					t.Errorf("%s: got no entry, want %+v", section, *tt.want)
+				case tt.want != nil && (e.MTime != tt.want.MTime || e.SHA256 != tt.want.SHA256 ||
+					e.Size != tt.want.Size):
+					t.Errorf("%s: got %+v, want %+v", section, e, *tt.want)
+				}
+				wantProblems := 0
+				if tt.want == nil || tt.fixed {
+					wantProblems = 1
+				}
+				if len(problems) != wantProblems {
+					t.Fatalf("%s: got problems %v, want %d", section, problems, wantProblems)
+				}
+				if wantProblems > 0 && (problems[0].Fixed != (tt.want != nil) || problems[0].Key != key ||
+					problems[0].Section != section) {
+					t.Errorf("%s: got problem %+v", section, problems[0])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	c, problems, err := Validate([]byte(`{"safetensors-metadata": {"lora/valid.safetensors": ` +
+		`{"mtime": 1, "value": {"a": 1}}, "lora/no-value.safetensors": {"mtime": 1}, ` +
+		`"lora/string-mtime.safetensors": {"mtime": "2", "value": {}}, "": {"mtime": 1, "value": {}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(c.SafetensorsMetadata["lora/valid.safetensors"].Value); v != `{"a":1}` {
+		t.Errorf("got value %s, want {\"a\":1}", v)
+	}
+	if m := c.SafetensorsMetadata["lora/string-mtime.safetensors"].MTime; m != 2 {
+		t.Errorf("got mtime %v, want 2", m)
+	}
+	if len(c.SafetensorsMetadata) != 2 {
+		t.Errorf("got %d entries, want 2: %v", len(c.SafetensorsMetadata), c.SafetensorsMetadata)
+	}
+	// the problems are sorted by the keys
+	want := []Problem{
+		{Section: "safetensors-metadata", Message: "empty key"},
+		{Section: "safetensors-metadata", Key: "lora/no-value.safetensors", Message: "missing value"},
+		{Section: "safetensors-metadata", Key: "lora/string-mtime.safetensors", Message: `mtime "2" is a string`,
+			Fixed: true},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("got problems %v, want %v", problems, want)
+	}
+	for i := range want {
+		if problems[i] != want[i] {
+			t.Errorf("got problem %+v, want %+v", problems[i], want[i])
+		}
+	}
+}
+
+func TestValidateCache(t *testing.T) {
+	for _, data := range []string{`[]`, `null`, `"cache"`, `{"hashes": `} {
+		if _, _, err := Validate([]byte(data)); err == nil {
+			t.Errorf("%s: no error", data)
+		}
+	}
+	c, problems, err := Validate([]byte(`{"hashes": [], "hashes-addnet": {}, "extension": {"kept": true}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Section != "hashes" || problems[0].Fixed {
+		t.Errorf("got problems %v, want the dropped hashes section", problems)
+	}
+	if c.Hashes == nil || len(c.Hashes) != 0 {
+		t.Errorf("got hashes %v, want an empty section", c.Hashes)
+	}
+	if v := string(c.other["extension"]); v != `{"kept":true}` {
+		t.Errorf("got extension section %s, want it kept", v)
+	}
+}
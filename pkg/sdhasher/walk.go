@@ -0,0 +1,85 @@
+package sdhasher
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// WalkOptions configure Walk
+type WalkOptions struct {
+	// Excludes are the patterns of the files to skip in the .gitignore syntax, the IgnoreFile of every directory adds
+	// the rules for its files
+	Excludes []string
+	// SkipSymlinks skips the symlinks instead of walking the files and the directories they point to
+	SkipSymlinks bool
+	// Resolve returns the target of the linked directory so that every directory is walked once and the link loops
+	// end, the linked directories are skipped if it's not set
+	Resolve func(name string) (string, error)
+	// Visited are the resolved directories already walked, it's shared by the walks of several directories so that
+	// the links between them are walked once
+	Visited map[string]bool
+}
+
+// Walk walks fsys like fs.WalkDir, the ignored files and directories are skipped and the symlinks are followed
+// according to the options. The files linked by the symlinks are passed with the entries of their targets. The errors
+// of reading the ignore files and following the symlinks are passed to fn with a nil entry.
+func Walk(fsys fs.FS, opts WalkOptions, fn fs.WalkDirFunc) error {
+	if opts.Visited == nil {
+		opts.Visited = map[string]bool{}
+	}
+	if opts.Resolve != nil {
+		if resolved, err := opts.Resolve("."); err == nil {
+			opts.Visited[resolved] = true
+		}
+	}
+	return walkDir(fsys, ".", opts, newIgnorer(opts.Excludes), fn)
+}
+
+func walkDir(fsys fs.FS, dir string, opts WalkOptions, ig *ignorer, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if d != nil && d.IsDir() {
+			if err != nil {
+				return fn(name, d, err)
+			}
+			if name != dir && ig.ignored(name, true) {
+				return fs.SkipDir
+			}
+			if err := fn(name, d, nil); err != nil {
+				return err
+			}
+			if err := ig.load(fsys, name); err != nil {
+				return fn(path.Join(name, IgnoreFile), nil, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fn(name, d, err)
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			if opts.SkipSymlinks {
+				return nil
+			}
+			fi, err := fs.Stat(fsys, name)
+			if err != nil {
+				return fn(name, nil, fmt.Errorf("error following symlink: %w", err))
+			}
+			if fi.IsDir() {
+				// fs.WalkDir doesn't follow the links, the resolved paths prevent the loops
+				if opts.Resolve == nil || ig.ignored(name, true) {
+					return nil
+				}
+				if resolved, err := opts.Resolve(name); err == nil && !opts.Visited[resolved] {
+					opts.Visited[resolved] = true
+					return walkDir(fsys, name, opts, ig, fn)
+				}
+				return nil
+			}
+			d = fs.FileInfoToDirEntry(fi)
+		}
+		if ig.ignored(name, false) {
+			return nil
+		}
+		return fn(name, d, nil)
+	})
+}
@@ -0,0 +1,91 @@
+package sdhasher
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+	writeModel(t, filepath.Join(dir, "models", "a.safetensors"), "a")
+	writeModel(t, filepath.Join(dir, "models", "sub", "b.safetensors"), "b")
+	writeModel(t, filepath.Join(dir, "models", "sub", "skip.tmp"), "tmp")
+	writeModel(t, filepath.Join(dir, "models", "ignored", "c.safetensors"), "c")
+	writeModel(t, filepath.Join(dir, "models", IgnoreFile), "ignored/\n")
+	writeModel(t, filepath.Join(dir, "other", "d.safetensors"), "d")
+	for link, target := range map[string]string{
+		"models/linked.safetensors": "a.safetensors",
+		"models/other":              "../other",
+		// the loop is walked once
+		"models/sub/loop": "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skip("symlinks aren't supported:", err)
+		}
+	}
+	root := filepath.Join(dir, "models")
+	resolve := func(name string) (string, error) {
+		return filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(name)))
+	}
+	tests := []struct {
+		name string
+		opts WalkOptions
+		want []string
+	}{
+		{"follow", WalkOptions{Excludes: []string{"*.tmp"}, Resolve: resolve},
+			[]string{"a.safetensors", "linked.safetensors", "other/d.safetensors", "sub/b.safetensors"}},
+		{"no resolve", WalkOptions{Excludes: []string{"*.tmp"}},
+			[]string{"a.safetensors", "linked.safetensors", "sub/b.safetensors"}},
+		{"skip", WalkOptions{Excludes: []string{"*.tmp"}, SkipSymlinks: true, Resolve: resolve},
+			[]string{"a.safetensors", "sub/b.safetensors"}},
+		{"no excludes", WalkOptions{SkipSymlinks: true},
+			[]string{"a.safetensors", "sub/b.safetensors", "sub/skip.tmp"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := Walk(os.DirFS(root), tt.opts, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				t.Errorf("%s: %s: %v", tt.name, name, err)
+				return nil
+			}
+			if d.Type()&fs.ModeSymlink != 0 {
+				t.Errorf("%s: %s: got the entry of the link", tt.name, name)
+			}
+			if !d.IsDir() && name != IgnoreFile {
+				got = append(got, name)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWalkBrokenLink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Symlink("missing.safetensors", filepath.Join(dir, "broken.safetensors")); err != nil {
+		t.Skip("symlinks aren't supported:", err)
+	}
+	var errs []string
+	Walk(os.DirFS(dir), WalkOptions{}, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil {
+				t.Errorf("%s: got an entry with the error", name)
+			}
+			errs = append(errs, name)
+		}
+		return nil
+	})
+	if !reflect.DeepEqual(errs, []string{"broken.safetensors"}) {
+		t.Errorf("got errors for %v, want for the broken link", errs)
+	}
+}
@@ -0,0 +1,5 @@
+// Package sdhasherpb is the gRPC API of the serve command generated from sdhasher.proto, the clients dial the address
+// of --grpc-listen and use NewHasherClient.
+package sdhasherpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sdhasher.proto
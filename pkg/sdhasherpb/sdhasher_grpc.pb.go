@@ -0,0 +1,257 @@
+// the gRPC API of the serve command, it's enabled with --grpc-listen
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: sdhasher.proto
+
+package sdhasherpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Hasher_Scan_FullMethodName   = "/sdhasher.v1.Hasher/Scan"
+	Hasher_Verify_FullMethodName = "/sdhasher.v1.Hasher/Verify"
+	Hasher_Lookup_FullMethodName = "/sdhasher.v1.Hasher/Lookup"
+)
+
+// HasherClient is the client API for Hasher service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Hasher drives the serve command, only one scan or verification runs at a time and the others fail with ABORTED
+type HasherClient interface {
+	// Scan hashes the new and changed files and saves the cache, the progress events of the scan are streamed until
+	// the run_finished event with the summary
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (Hasher_ScanClient, error)
+	// Verify rehashes the cached files and streams the progress events, the files that are missing or don't match are
+	// reported with the file_failed events and the last one is verify_finished
+	Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (Hasher_VerifyClient, error)
+	// Lookup returns the cached entries by the key, the path or the hash
+	Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error)
+}
+
+type hasherClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewHasherClient(cc grpc.ClientConnInterface) HasherClient {
+	return &hasherClient{cc}
+}
+
+func (c *hasherClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (Hasher_ScanClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Hasher_ServiceDesc.Streams[0], Hasher_Scan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hasherScanClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Hasher_ScanClient interface {
+	Recv() (*ProgressEvent, error)
+	grpc.ClientStream
+}
+
+type hasherScanClient struct {
+	grpc.ClientStream
+}
+
+func (x *hasherScanClient) Recv() (*ProgressEvent, error) {
+	m := new(ProgressEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *hasherClient) Verify(ctx context.Context, in *VerifyRequest, opts ...grpc.CallOption) (Hasher_VerifyClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Hasher_ServiceDesc.Streams[1], Hasher_Verify_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &hasherVerifyClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Hasher_VerifyClient interface {
+	Recv() (*ProgressEvent, error)
+	grpc.ClientStream
+}
+
+type hasherVerifyClient struct {
+	grpc.ClientStream
+}
+
+func (x *hasherVerifyClient) Recv() (*ProgressEvent, error) {
+	m := new(ProgressEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *hasherClient) Lookup(ctx context.Context, in *LookupRequest, opts ...grpc.CallOption) (*LookupResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LookupResponse)
+	err := c.cc.Invoke(ctx, Hasher_Lookup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// HasherServer is the server API for Hasher service.
+// All implementations must embed UnimplementedHasherServer
+// for forward compatibility
+//
+// Hasher drives the serve command, only one scan or verification runs at a time and the others fail with ABORTED
+type HasherServer interface {
+	// Scan hashes the new and changed files and saves the cache, the progress events of the scan are streamed until
+	// the run_finished event with the summary
+	Scan(*ScanRequest, Hasher_ScanServer) error
+	// Verify rehashes the cached files and streams the progress events, the files that are missing or don't match are
+	// reported with the file_failed events and the last one is verify_finished
+	Verify(*VerifyRequest, Hasher_VerifyServer) error
+	// Lookup returns the cached entries by the key, the path or the hash
+	Lookup(context.Context, *LookupRequest) (*LookupResponse, error)
+	mustEmbedUnimplementedHasherServer()
+}
+
+// UnimplementedHasherServer must be embedded to have forward compatible implementations.
+type UnimplementedHasherServer struct {
+}
+
+func (UnimplementedHasherServer) Scan(*ScanRequest, Hasher_ScanServer) error {
+	return status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedHasherServer) Verify(*VerifyRequest, Hasher_VerifyServer) error {
+	return status.Errorf(codes.Unimplemented, "method Verify not implemented")
+}
+func (UnimplementedHasherServer) Lookup(context.Context, *LookupRequest) (*LookupResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lookup not implemented")
+}
+func (UnimplementedHasherServer) mustEmbedUnimplementedHasherServer() {}
+
+// UnsafeHasherServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to HasherServer will
+// result in compilation errors.
+type UnsafeHasherServer interface {
+	mustEmbedUnimplementedHasherServer()
+}
+
+func RegisterHasherServer(s grpc.ServiceRegistrar, srv HasherServer) {
+	s.RegisterService(&Hasher_ServiceDesc, srv)
+}
+
+func _Hasher_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HasherServer).Scan(m, &hasherScanServer{ServerStream: stream})
+}
+
+type Hasher_ScanServer interface {
+	Send(*ProgressEvent) error
+	grpc.ServerStream
+}
+
+type hasherScanServer struct {
+	grpc.ServerStream
+}
+
+func (x *hasherScanServer) Send(m *ProgressEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Hasher_Verify_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VerifyRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(HasherServer).Verify(m, &hasherVerifyServer{ServerStream: stream})
+}
+
+type Hasher_VerifyServer interface {
+	Send(*ProgressEvent) error
+	grpc.ServerStream
+}
+
+type hasherVerifyServer struct {
+	grpc.ServerStream
+}
+
+func (x *hasherVerifyServer) Send(m *ProgressEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Hasher_Lookup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HasherServer).Lookup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Hasher_Lookup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HasherServer).Lookup(ctx, req.(*LookupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Hasher_ServiceDesc is the grpc.ServiceDesc for Hasher service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Hasher_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sdhasher.v1.Hasher",
+	HandlerType: (*HasherServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lookup",
+			Handler:    _Hasher_Lookup_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _Hasher_Scan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Verify",
+			Handler:       _Hasher_Verify_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sdhasher.proto",
+}
@@ -0,0 +1,56 @@
+//go:build linux
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// setupPriority applies the priorities to all threads of the process, the threads started later inherit them
+func setupPriority() {
+	if params.Nice == 0 && params.IONice == "" {
+		return
+	}
+	threads, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		slog.Error("Error listing threads", "error", err)
+		return
+	}
+	ioprio := 0
+	switch params.IONice {
+	case "idle":
+		ioprio = ioprioClassIdle << ioprioClassShift
+	case "best-effort":
+		ioprio = ioprioClassBE<<ioprioClassShift | 7 // the lowest priority within the class
+	}
+	for _, t := range threads {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if params.Nice != 0 {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, params.Nice); err != nil {
+				slog.Error("Error setting CPU priority", "nice", params.Nice, "error", err)
+				return
+			}
+		}
+		if ioprio != 0 {
+			_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio))
+			if errno != 0 {
+				slog.Error("Error setting I/O priority", "class", params.IONice, "error", errno)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "log/slog"
+
+func setupPriority() {
+	if params.Nice != 0 || params.IONice != "" {
+		slog.Warn("Process priority is not supported on this platform")
+	}
+}
@@ -0,0 +1,19 @@
+//go:build unix && !linux
+
+package main
+
+import (
+	"log/slog"
+	"syscall"
+)
+
+func setupPriority() {
+	if params.IONice != "" {
+		slog.Warn("I/O priority is not supported on this platform")
+	}
+	if params.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, params.Nice); err != nil {
+			slog.Error("Error setting CPU priority", "nice", params.Nice, "error", err)
+		}
+	}
+}
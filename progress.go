@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// progressReporter counts the hashed files and bytes, the counters are read by the dashboard, the events and the TUI
+// while the workers update them
+type progressReporter struct {
+	totalFiles atomic.Int64
+	totalBytes atomic.Int64
+	doneFiles  atomic.Int64
+	doneBytes  atomic.Int64
+	// started is the start time in Unix nanoseconds
+	started atomic.Int64
+	quit    chan struct{}
+}
+
+var progress progressReporter
+
+func (p *progressReporter) start(tasks []*task) {
+	total := totalSize(tasks)
+	p.doneFiles.Store(0)
+	p.doneBytes.Store(0)
+	p.totalFiles.Store(int64(len(tasks)))
+	p.totalBytes.Store(total)
+	p.started.Store(time.Now().UnixNano())
+	if len(tasks) == 0 {
+		return
+	}
+	slog.Info("Hashing files", "files", len(tasks), "bytes", total, "total", formatBytes(total))
+	if params.Progress <= 0 {
+		return
+	}
+	p.quit = make(chan struct{})
+	go func(quit chan struct{}) {
+		ticker := time.NewTicker(params.Progress)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.report()
+			case <-quit:
+				return
+			}
+		}
+	}(p.quit)
+}
+
+func (p *progressReporter) stop() {
+	if p.quit != nil {
+		close(p.quit)
+		p.quit = nil
+	}
+}
+
+func (p *progressReporter) read(n int) {
+	p.doneBytes.Add(int64(n))
+	metrics.bytesRead.Add(int64(n))
+}
+
+func (p *progressReporter) fileDone() {
+	p.doneFiles.Add(1)
+}
+
+// status returns the current counters
+func (p *progressReporter) status() *progressStatus {
+	return &progressStatus{Files: p.doneFiles.Load(), TotalFiles: p.totalFiles.Load(), Bytes: p.doneBytes.Load(),
+		TotalBytes: p.totalBytes.Load()}
+}
+
+// elapsed returns the time since the start
+func (p *progressReporter) elapsed() time.Duration {
+	return time.Since(time.Unix(0, p.started.Load()))
+}
+
+func (p *progressReporter) report() {
+	s := p.status()
+	speed := float64(s.Bytes) / p.elapsed().Seconds()
+	eta := "unknown"
+	if speed > 0 {
+		eta = time.Duration(float64(s.TotalBytes-s.Bytes) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+	percent := float64(100)
+	if s.TotalBytes > 0 {
+		percent = float64(s.Bytes) * 100 / float64(s.TotalBytes)
+	}
+	slog.Info("Progress", "files", s.Files, "total_files", s.TotalFiles, "bytes", s.Bytes,
+		"total_bytes", s.TotalBytes, "percent", fmt.Sprintf("%.1f", percent), "speed", formatBytes(int64(speed))+"/s",
+		"eta", eta)
+}
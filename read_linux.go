@@ -0,0 +1,23 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openFile opens the file for hashing, with O_DIRECT if requested and supported by the filesystem
+func openFile(path string) (*os.File, error) {
+	if !params.DirectIO {
+		return os.Open(path)
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_DIRECT, 0)
+	if errors.Is(err, unix.EINVAL) {
+		// the filesystem doesn't support O_DIRECT
+		return os.Open(path)
+	}
+	return f, err
+}
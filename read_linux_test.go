@@ -0,0 +1,62 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+	"golang.org/x/sys/unix"
+)
+
+func TestDirectIO(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() {
+		params = savedParams
+		bufferPool = sync.Pool{}
+	})
+	params.DirectIO, params.BufferSize, params.MaxHashers = true, 2*bufferAlign, 1
+	bufferPool = sync.Pool{}
+	setupBuffers()
+	// the last read is shorter than the buffer and isn't aligned
+	data := make([]byte, 5*bufferAlign+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	// the working directory is more likely to support O_DIRECT than tmpfs
+	for _, dir := range []string{t.TempDir(), "."} {
+		path := filepath.Join(dir, "direct.safetensors")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(path)
+		f, err := openFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if flags, err := unix.FcntlInt(f.Fd(), unix.F_GETFL, 0); err == nil && flags&unix.O_DIRECT == 0 {
+			t.Logf("%s doesn't support O_DIRECT, read normally", dir)
+		}
+		var bufs [][]byte
+		for i := 0; i < buffersPerHasher(); i++ {
+			bufp := getBuffer()
+			defer putBuffer(bufp)
+			bufs = append(bufs, *bufp)
+		}
+		var got bytes.Buffer
+		if err := sdhasher.ReadChunks(f, bufs, func(p []byte) error {
+			got.Write(p)
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		if !bytes.Equal(got.Bytes(), data) {
+			t.Errorf("%s: got %d bytes, want the %d bytes of data", dir, got.Len(), len(data))
+		}
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+)
+
+var directIOWarning sync.Once
+
+// openFile opens the file for hashing
+func openFile(path string) (*os.File, error) {
+	if params.DirectIO {
+		directIOWarning.Do(func() { slog.Warn("Direct I/O is not supported on this platform") })
+	}
+	return os.Open(path)
+}
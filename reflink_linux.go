@@ -0,0 +1,14 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the data blocks of src, only supported by some filesystems such as Btrfs and XFS
+func cloneFile(dst, src *os.File) error {
+	return unix.IoctlFileCloneRange(int(dst.Fd()), &unix.FileCloneRange{Src_fd: int64(src.Fd())})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// refreshEndpoints are the webui API calls that reload the model lists, called for the prefixes of the hashed files
+var refreshEndpoints = map[string]string{
+	"checkpoint/":        "/sdapi/v1/refresh-checkpoints",
+	"lora/":              "/sdapi/v1/refresh-loras",
+	"textual_inversion/": "/sdapi/v1/refresh-embeddings",
+	"vae/":               "/sdapi/v1/refresh-vae",
+}
+
+// refreshWebui asks the running webui to reload the lists of the model types that got new hashes so that it picks
+// them up without a restart
+func refreshWebui() {
+	if params.WebuiURL == "" || len(stats.hashed) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	endpoints := map[string]struct{}{}
+	for _, e := range stats.hashed {
+		for prefix, endpoint := range refreshEndpoints {
+			if strings.HasPrefix(e.Key, prefix) {
+				endpoints[endpoint] = struct{}{}
+			}
+		}
+	}
+	for _, endpoint := range sortedKeys(endpoints) {
+		url := strings.TrimSuffix(params.WebuiURL, "/") + endpoint
+		if err := postRefresh(ctx, url); err != nil {
+			slog.Error("Error refreshing webui models", "url", url, "error", err)
+			continue
+		}
+		slog.Info("Refreshed webui models", "url", url)
+	}
+}
+
+func postRefresh(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	if params.WebuiAuth != "" {
+		user, password, _ := strings.Cut(params.WebuiAuth, ":")
+		req.SetBasicAuth(user, password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
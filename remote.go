@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// remoteFile is a file in a remote models directory, the name is relative to the directory with forward slashes
+type remoteFile struct {
+	name  string
+	size  int64
+	mtime time.Time
+}
+
+func (f remoteFile) Name() string       { return path.Base(f.name) }
+func (f remoteFile) Size() int64        { return f.size }
+func (f remoteFile) Mode() fs.FileMode  { return 0444 }
+func (f remoteFile) ModTime() time.Time { return f.mtime }
+func (f remoteFile) IsDir() bool        { return false }
+func (f remoteFile) Sys() any           { return nil }
+
+// remoteFS is a models directory on a remote storage
+type remoteFS interface {
+	// list returns all the files under the directory
+	list(ctx context.Context) ([]remoteFile, error)
+	// open streams the listed file starting from the offset
+	open(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+}
+
+// remoteSchemes create the remote storages for the models directory URLs
+var remoteSchemes = map[string]func(path string) (remoteFS, error){
+	"s3":      newS3FS,
+	"sftp":    newSFTPFS,
+	"ssh":     newSSHFS,
+	"webdav":  newWebDAVFS,
+	"webdavs": newWebDAVFS,
+}
+
+// isRemote reports whether the path is a URL of a remote storage
+func isRemote(path string) bool {
+	scheme, _, ok := strings.Cut(path, "://")
+	_, known := remoteSchemes[scheme]
+	return ok && known
+}
+
+// withoutPassword removes the password from the URL so that it doesn't appear in the logs
+func withoutPassword(path string) string {
+	u, err := url.Parse(path)
+	if err != nil || u.User == nil {
+		return path
+	}
+	if _, ok := u.User.Password(); !ok {
+		return path
+	}
+	u.User = url.User(u.User.Username())
+	return u.String()
+}
+
+// listRemotes lists the files of the remote roots and resets the name index, the roots that can't be listed are left
+// without storage so that their entries aren't removed
+func listRemotes(ctx context.Context) {
+	listed := make([]storage, len(roots))
+	for i := range roots {
+		r := &roots[i]
+		if r.remote == nil {
+			continue
+		}
+		files, err := r.remote.list(ctx)
+		if err != nil {
+			slog.Error("Error listing remote directory", "path", r.path, "error", err)
+			continue
+		}
+		listed[i] = newListedStorage(r.remote, files)
+	}
+	scanState.Lock()
+	defer scanState.Unlock()
+	for i := range roots {
+		if roots[i].remote != nil {
+			roots[i].fsys = listed[i]
+		}
+	}
+	resetNames()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// renameSidecarExts are the files next to the model that are renamed with it, the .sha256 sidecar is named after the
+// whole file name
+var renameSidecarExts = append([]string{civitaiInfoExt, ".json", ".txt", ".yaml"}, previewExts...)
+
+// maxNameLength keeps the names well under the file name limits
+const maxNameLength = 150
+
+// civitaiVersion is the part of the Civitai model version info used for the canonical name
+type civitaiVersion struct {
+	Name  string `json:"name"`
+	Model struct {
+		Name string `json:"name"`
+	} `json:"model"`
+}
+
+// renameRecord is an entry of the rename mapping, the names are the ones used in the infotext
+type renameRecord struct {
+	Time    time.Time `json:"time"`
+	OldKey  string    `json:"old_key"`
+	NewKey  string    `json:"new_key"`
+	OldName string    `json:"old_name"`
+	NewName string    `json:"new_name"`
+	SHA256  string    `json:"sha256"`
+}
+
+// canonicalName returns the "model version" name from the Civitai info safe to use as a file name on all platforms
+func canonicalName(info []byte) (string, error) {
+	var v civitaiVersion
+	if err := json.Unmarshal(info, &v); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(v.Model.Name)
+	if version := strings.TrimSpace(v.Name); version != "" && !strings.Contains(name, version) {
+		name += " " + version
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	if r := []rune(name); len(r) > maxNameLength {
+		name = string(r[:maxNameLength])
+	}
+	// Windows doesn't allow the trailing dots and spaces
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "", errors.New("empty model name")
+	}
+	return name, nil
+}
+
+// renameModels renames the cached models to their Civitai names together with their sidecar files, moves their cache
+// entries to the new keys and appends the changes to the mapping file
+func renameModels(ctx context.Context, result *sdhasher.Cache) {
+	var renamed []renameRecord
+	for _, k := range sortedKeys(result.Hashes) {
+		if ctx.Err() != nil {
+			break
+		}
+		e := result.Hashes[k]
+		modelPath, _, err := statKey(k)
+		if modelPath == "" || err != nil || rootFor(modelPath).remote != nil {
+			continue
+		}
+		info, err := os.ReadFile(sidecarPath(modelPath, civitaiInfoExt))
+		if err != nil {
+			if civitaiSkipped(e) {
+				slog.Debug("Model not found on Civitai recently, skipping", "path", modelPath)
+				continue
+			}
+			info, err = civitaiLookup(ctx, e.SHA256)
+		}
+		if err != nil {
+			slog.Error("Error looking up model on Civitai", "path", modelPath, "error", err)
+			continue
+		}
+		if info == nil {
+			slog.Warn("Model not found on Civitai", "path", modelPath)
+			civitaiNotFound(*result, k)
+			continue
+		}
+		name, err := canonicalName(info)
+		if err != nil {
+			slog.Error("Error reading Civitai info", "path", modelPath, "error", err)
+			continue
+		}
+		ext := filepath.Ext(modelPath)
+		oldName := strings.TrimSuffix(filepath.Base(modelPath), ext)
+		if name == oldName {
+			continue
+		}
+		newPath := filepath.Join(filepath.Dir(modelPath), name+ext)
+		newKey, err := keyFor(newPath)
+		if err != nil {
+			slog.Error("Error getting relative path", "path", newPath, "error", err)
+			continue
+		}
+		if _, err := os.Lstat(newPath); !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("File with the Civitai name already exists", "path", modelPath, "new_path", newPath)
+			continue
+		}
+		if _, ok := result.Hashes[newKey]; ok && newKey != k {
+			slog.Warn("Cache entry with the Civitai name already exists", "path", modelPath, "new_key", newKey)
+			continue
+		}
+		slog.Info("Renaming model", "path", modelPath, "new_path", newPath, "dry_run", params.DryRun)
+		if params.DryRun {
+			continue
+		}
+		if err := renameModel(modelPath, newPath); err != nil {
+			slog.Error("Error renaming model", "path", modelPath, "error", err)
+			continue
+		}
+		result.Rename(k, newKey)
+		renamed = append(renamed, renameRecord{Time: time.Now(), OldKey: k, NewKey: newKey, OldName: oldName,
+			NewName: name, SHA256: e.SHA256})
+	}
+	slog.Info("Renamed models", "renamed", len(renamed), "dry_run", params.DryRun)
+	if len(renamed) == 0 {
+		return
+	}
+	if err := appendRenames(renameOptions.Mapping, renamed); err != nil {
+		slog.Error("Error writing rename mapping", "path", renameOptions.Mapping, "error", err)
+	}
+}
+
+// renameModel renames the model and then its sidecar files, the sidecars that can't be renamed are only logged
+func renameModel(oldPath, newPath string) error {
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	sidecars := map[string]string{oldPath + sha256Ext: newPath + sha256Ext}
+	for _, ext := range renameSidecarExts {
+		sidecars[sidecarPath(oldPath, ext)] = sidecarPath(newPath, ext)
+	}
+	for from, to := range sidecars {
+		if !exists(from) {
+			continue
+		}
+		if exists(to) {
+			slog.Warn("Sidecar file already exists, not renaming", "path", from, "new_path", to)
+			continue
+		}
+		if err := os.Rename(from, to); err != nil {
+			slog.Error("Error renaming sidecar file", "path", from, "error", err)
+			continue
+		}
+		if to == newPath+sha256Ext {
+			if err := renameSidecar(to, filepath.Base(newPath)); err != nil {
+				slog.Error("Error updating sidecar file", "path", to, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// appendRenames adds the records to the JSON list in the mapping file
+func appendRenames(path string, records []renameRecord) error {
+	var all []renameRecord
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &all); err != nil {
+			return err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	all = append(all, records...)
+	if data, err = json.MarshalIndent(all, "", "    "); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
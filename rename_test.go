@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestCanonicalName(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    string
+		want    string
+		wantErr bool
+	}{
+		{"model and version", `{"name":"v1.0","model":{"name":"Foo Style"}}`, "Foo Style v1.0", false},
+		{"version in the model name", `{"name":"v2","model":{"name":"Bar v2"}}`, "Bar v2", false},
+		{"reserved characters", `{"name":"a/b","model":{"name":"Foo: \"x\" <y>?"}}`, "Foo_ _x_ _y__ a_b", false},
+		{"spaces", `{"name":"\tv1\n","model":{"name":"  Foo   Bar "}}`, "Foo Bar v1", false},
+		{"trailing dots", `{"name":"","model":{"name":"Foo..."}}`, "Foo", false},
+		{"empty", `{"name":"","model":{"name":" . "}}`, "", true},
+		{"invalid", `[]`, "", true},
+	}
+	for _, tt := range tests {
+		got, err := canonicalName([]byte(tt.info))
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestRenameModels(t *testing.T) {
+	savedParams, savedRoots, savedOptions := params, roots, renameOptions
+	t.Cleanup(func() { params, roots, renameOptions = savedParams, savedRoots, savedOptions })
+	dir := t.TempDir()
+	sha := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	files := map[string]string{
+		"foo.safetensors":        "model",
+		"foo.civitai.info":       `{"name":"v1.0","model":{"name":"Foo Style"}}`,
+		"foo.preview.png":        "png",
+		"foo.safetensors.sha256": sha + " *foo.safetensors\n",
+		// the target name is taken by another file
+		"bar.safetensors":   "other",
+		"bar.civitai.info":  `{"name":"","model":{"name":"Taken"}}`,
+		"Taken.safetensors": "taken",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots = []root{{path: dir, prefix: "checkpoint/", fsys: localStorage{dir: dir}}}
+	resetNames()
+	params.DryRun = false
+	renameOptions.Mapping = filepath.Join(t.TempDir(), "renames.json")
+	var result sdhasher.Cache
+	result.Init()
+	result.Hashes["checkpoint/foo.safetensors"] = sdhasher.Entry{SHA256: sha}
+	result.HashesAddnet["checkpoint/foo.safetensors"] = sdhasher.Entry{SHA256: "addnet"}
+	result.Hashes["checkpoint/bar.safetensors"] = sdhasher.Entry{SHA256: "bar"}
+	renameModels(context.Background(), &result)
+	for name, want := range map[string]string{
+		"Foo Style v1.0.safetensors":        "model",
+		"Foo Style v1.0.civitai.info":       files["foo.civitai.info"],
+		"Foo Style v1.0.preview.png":        "png",
+		"Foo Style v1.0.safetensors.sha256": sha + " *Foo Style v1.0.safetensors\n",
+		"bar.safetensors":                   "other",
+		"Taken.safetensors":                 "taken",
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s: got %q, want %q", name, data, want)
+		}
+	}
+	for _, name := range []string{"foo.safetensors", "foo.civitai.info", "foo.preview.png", "foo.safetensors.sha256"} {
+		if exists(filepath.Join(dir, name)) {
+			t.Errorf("%s isn't renamed", name)
+		}
+	}
+	if e, ok := result.Hashes["checkpoint/Foo Style v1.0.safetensors"]; !ok || e.SHA256 != sha {
+		t.Errorf("entry isn't moved: %v", result.Hashes)
+	}
+	if _, ok := result.HashesAddnet["checkpoint/Foo Style v1.0.safetensors"]; !ok {
+		t.Errorf("addnet entry isn't moved: %v", result.HashesAddnet)
+	}
+	if _, ok := result.Hashes["checkpoint/bar.safetensors"]; !ok {
+		t.Errorf("entry of the skipped file is moved: %v", result.Hashes)
+	}
+	data, err := os.ReadFile(renameOptions.Mapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []renameRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].OldName != "foo" || records[0].NewName != "Foo Style v1.0" ||
+		records[0].NewKey != "checkpoint/Foo Style v1.0.safetensors" {
+		t.Errorf("got records %+v", records)
+	}
+}
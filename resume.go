@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// resumeState is the saved progress of hashing a file
+type resumeState struct {
+	Path   string         `json:"path"`
+	Size   int64          `json:"size"`
+	MTime  sdhasher.MTime `json:"mtime"`
+	Offset int64          `json:"offset"`
+	State  []byte         `json:"state"`
+}
+
+// resumePath returns the state file of the model file
+func resumePath(path string) string {
+	return filepath.Join(params.ResumeDir, fmt.Sprintf("%x.json", sha256.Sum256([]byte(path))))
+}
+
+// loadResume restores the digest state saved for the unchanged file and returns the offset to continue from
+func loadResume(path string, info fs.FileInfo, w *sdhasher.Digest) int64 {
+	if params.ResumeDir == "" {
+		return 0
+	}
+	data, err := os.ReadFile(resumePath(path))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("Error reading hashing state", "path", path, "error", err)
+		}
+		return 0
+	}
+	var s resumeState
+	if err := json.Unmarshal(data, &s); err != nil {
+		slog.Warn("Error decoding hashing state", "path", path, "error", err)
+		return 0
+	}
+	if s.Path != path || s.Size != info.Size() || s.MTime != mtimeMode().FileMTime(info) || s.Offset > s.Size {
+		slog.Info("File changed, discarding hashing state", "path", path)
+		return 0
+	}
+	if err := w.UnmarshalBinary(s.State); err != nil {
+		slog.Warn("Error restoring hashing state", "path", path, "error", err)
+		return 0
+	}
+	return s.Offset
+}
+
+// saveResume saves the digest state after hashing the first offset bytes of the file
+func saveResume(path string, info fs.FileInfo, offset int64, w *sdhasher.Digest) {
+	state, err := w.MarshalBinary()
+	if errors.Is(err, sdhasher.ErrNotResumable) {
+		slog.Debug("Hashing state can't be saved", "path", path)
+		return
+	}
+	if err != nil {
+		slog.Warn("Error saving hashing state", "path", path, "error", err)
+		return
+	}
+	data, err := json.Marshal(resumeState{Path: path, Size: info.Size(), MTime: mtimeMode().FileMTime(info),
+		Offset: offset, State: state})
+	if err != nil {
+		slog.Warn("Error saving hashing state", "path", path, "error", err)
+		return
+	}
+	if err := os.MkdirAll(params.ResumeDir, 0755); err != nil {
+		slog.Warn("Error saving hashing state", "path", path, "error", err)
+		return
+	}
+	// the state is replaced atomically so that an interruption doesn't leave it truncated
+	target := resumePath(path)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		slog.Warn("Error saving hashing state", "path", path, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		slog.Warn("Error saving hashing state", "path", path, "error", err)
+	}
+}
+
+// removeResume removes the state of the fully hashed file
+func removeResume(path string) {
+	if params.ResumeDir == "" {
+		return
+	}
+	if err := os.Remove(resumePath(path)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Error removing hashing state", "path", path, "error", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestResumeState(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	params.ResumeDir = t.TempDir()
+	path := filepath.Join(t.TempDir(), "model.safetensors")
+	data := make([]byte, 10000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	full, err := sdhasher.NewDigest(nil, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full.Write(data)
+	want, err := full.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := func() os.FileInfo {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi
+	}
+	interrupted, err := sdhasher.NewDigest(nil, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted.Write(data[:4000])
+	saveResume(path, stat(), 4000, interrupted)
+	resumed, err := sdhasher.NewDigest(nil, true, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := loadResume(path, stat(), resumed)
+	if offset != 4000 {
+		t.Fatalf("got offset %d, want 4000", offset)
+	}
+	resumed.Write(data[offset:])
+	got, err := resumed.Entry()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SHA256 != want.SHA256 || got.Addnet != want.Addnet {
+		t.Errorf("got %s %s, want %s %s", got.SHA256, got.Addnet, want.SHA256, want.Addnet)
+	}
+	// the state of another file with the same size and mtime isn't used
+	saveResume(path, stat(), 4000, interrupted)
+	other := filepath.Join(filepath.Dir(path), "other.safetensors")
+	if err := os.WriteFile(other, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(other, stat().ModTime(), stat().ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	state, err := os.ReadFile(resumePath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(resumePath(other), state, 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherInfo, err := os.Stat(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset := loadResume(other, otherInfo, resumed); offset != 0 {
+		t.Errorf("got offset %d for the state of another file, want 0", offset)
+	}
+	// the state of the changed file is discarded
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{"mtime", func() error {
+			mtime := stat().ModTime().Add(time.Hour)
+			return os.Chtimes(path, mtime, mtime)
+		}},
+		{"size", func() error { return os.WriteFile(path, data[:9000], 0644) }},
+	}
+	for _, tt := range tests {
+		saveResume(path, stat(), 4000, interrupted)
+		if err := tt.change(); err != nil {
+			t.Fatal(err)
+		}
+		d, err := sdhasher.NewDigest(nil, true, false, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if offset := loadResume(path, stat(), d); offset != 0 {
+			t.Errorf("%s changed: got offset %d, want 0", tt.name, offset)
+		}
+	}
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Client talks to an S3 compatible storage with the path style requests signed with the credentials from the
+// standard AWS environment variables
+type s3Client struct {
+	endpoint     *url.URL
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+var s3 *s3Client
+
+// s3Setup creates the client on the first use
+func s3Setup() (*s3Client, error) {
+	if s3 != nil {
+		return s3, nil
+	}
+	region := params.S3Region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := params.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint %s: %w", endpoint, err)
+	}
+	c := &s3Client{endpoint: u, region: region, accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), sessionToken: os.Getenv("AWS_SESSION_TOKEN")}
+	if c.accessKey == "" || c.secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required for S3")
+	}
+	s3 = c
+	return s3, nil
+}
+
+// parseS3 splits the s3://bucket/key URL
+func parseS3(path string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(path, "s3://")
+	if !ok {
+		return "", "", fmt.Errorf("not an S3 URL: %s", path)
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", fmt.Errorf("no bucket in %s", path)
+	}
+	return bucket, key, nil
+}
+
+// do signs and sends the request for the object, 404 is returned as fs.ErrNotExist
+func (c *s3Client) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header,
+	body []byte) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	u.RawPath = s3Escape(u.Path)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	payload := "UNSIGNED-PAYLOAD"
+	if body != nil {
+		payload = fmt.Sprintf("%x", sha256.Sum256(body))
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+	signV4(req, payload, c.accessKey, c.secretKey, c.region, "s3", time.Now())
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3://%s/%s: %w", bucket, key, fs.ErrNotExist)
+	}
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return nil, fmt.Errorf("s3://%s/%s: %s: %s", bucket, key, e.Code, e.Message)
+	}
+	return nil, fmt.Errorf("s3://%s/%s: %s", bucket, key, resp.Status)
+}
+
+// list returns the objects under the prefix
+func (c *s3Client) list(ctx context.Context, bucket, prefix string) ([]remoteFile, error) {
+	var result []remoteFile
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := c.do(ctx, http.MethodGet, bucket, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the list of s3://%s/%s: %w", bucket, prefix, err)
+		}
+		for _, o := range page.Contents {
+			if strings.HasSuffix(o.Key, "/") {
+				continue
+			}
+			result = append(result, remoteFile{name: strings.TrimPrefix(o.Key, prefix), size: o.Size,
+				mtime: o.LastModified})
+		}
+		if !page.IsTruncated {
+			return result, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// get downloads the whole object
+func (c *s3Client) get(ctx context.Context, bucket, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// getRange downloads the bytes from start to end inclusive
+func (c *s3Client) getRange(ctx context.Context, bucket, key string, start, end int64) ([]byte, error) {
+	header := http.Header{"Range": {fmt.Sprintf("bytes=%d-%d", start, end)}}
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, header, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err == nil && int64(len(data)) != end-start+1 {
+		err = fmt.Errorf("s3://%s/%s: got %d bytes at %d instead of %d", bucket, key, len(data), start, end-start+1)
+	}
+	return data, err
+}
+
+// put uploads the object
+func (c *s3Client) put(ctx context.Context, bucket, key string, data []byte) error {
+	header := http.Header{"Content-Type": {"application/json"}}
+	resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// isS3 reports whether the cache path is an S3 object URL
+func isS3(path string) bool {
+	return strings.HasPrefix(path, "s3://")
+}
+
+// statS3 checks that the object exists
+func statS3(path string) error {
+	c, err := s3Setup()
+	if err != nil {
+		return err
+	}
+	bucket, key, err := parseS3(path)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(context.Background(), http.MethodHead, bucket, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// readS3 downloads the cache object
+func readS3(path string) (io.ReadCloser, error) {
+	c, err := s3Setup()
+	if err != nil {
+		return nil, err
+	}
+	bucket, key, err := parseS3(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.get(context.Background(), bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// writeS3 uploads the cache object
+func writeS3(path string, data []byte) error {
+	c, err := s3Setup()
+	if err != nil {
+		return err
+	}
+	bucket, key, err := parseS3(path)
+	if err != nil {
+		return err
+	}
+	return c.put(context.Background(), bucket, key, data)
+}
+
+// s3Reader streams the object downloading up to --s3-concurrency parts of --s3-part-size ahead in parallel
+type s3Reader struct {
+	cancel context.CancelFunc
+	parts  chan chan s3Part
+	cur    []byte
+	err    error
+}
+
+type s3Part struct {
+	data []byte
+	err  error
+}
+
+func (c *s3Client) openRange(ctx context.Context, bucket, key string, offset, size int64) *s3Reader {
+	ctx, cancel := context.WithCancel(ctx)
+	r := &s3Reader{cancel: cancel, parts: make(chan chan s3Part, max(params.S3Concurrency-1, 0))}
+	partSize := max(int64(params.S3PartSize), 1)
+	go func() {
+		defer close(r.parts)
+		for start := offset; start < size; start += partSize {
+			part := make(chan s3Part, 1)
+			select {
+			case r.parts <- part:
+			case <-ctx.Done():
+				return
+			}
+			go func(start int64) {
+				data, err := c.getRange(ctx, bucket, key, start, min(start+partSize, size)-1)
+				part <- s3Part{data, err}
+			}(start)
+		}
+	}()
+	return r
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 && r.err == nil {
+		part, ok := <-r.parts
+		if !ok {
+			r.err = io.EOF
+			break
+		}
+		res := <-part
+		r.cur, r.err = res.data, res.err
+	}
+	if len(r.cur) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	return n, nil
+}
+
+func (r *s3Reader) Close() error {
+	r.cancel()
+	return nil
+}
+
+// s3FS is a models directory in a bucket
+type s3FS struct {
+	client *s3Client
+	bucket string
+	prefix string
+	sizes  map[string]int64
+}
+
+func newS3FS(path string) (remoteFS, error) {
+	c, err := s3Setup()
+	if err != nil {
+		return nil, err
+	}
+	bucket, prefix, err := parseS3(path)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &s3FS{client: c, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *s3FS) list(ctx context.Context) ([]remoteFile, error) {
+	files, err := s.client.list(ctx, s.bucket, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.sizes = map[string]int64{}
+	for _, f := range files {
+		s.sizes[f.name] = f.size
+	}
+	return files, nil
+}
+
+func (s *s3FS) open(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	size, ok := s.sizes[name]
+	if !ok {
+		return nil, fmt.Errorf("s3://%s/%s%s: %w", s.bucket, s.prefix, name, fs.ErrNotExist)
+	}
+	return s.client.openRange(ctx, s.bucket, s.prefix+name, offset, size), nil
+}
+
+// signV4 adds the AWS Signature Version 4 authorization header signing the host, content-type and x-amz-* headers
+func signV4(req *http.Request, payload, accessKey, secretKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "content-type" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(),
+		signedHeaders, payload}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		fmt.Sprintf("%x", sha256.Sum256([]byte(canonicalRequest)))
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	for _, s := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, s)
+	}
+	signature := fmt.Sprintf("%x", hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+
+		signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes the query sorted by the keys with the spaces as %20 as the signature requires
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// s3Escape encodes everything except the unreserved characters and slashes in the object path as the signature requires
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
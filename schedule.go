@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed five field cron expression, the fields are the bit sets of the allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// the day matches either the day of month or the day of week when both are restricted like in cron
+	domAny, dowAny bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+
+var cronDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseCron parses the minute, hour, day of month, month and day of week fields with the lists, ranges, steps and the
+// month and day names, or one of the @daily style macros
+func parseCron(expr string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q should have 5 fields", expr)
+	}
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7, cronDays); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// 7 is Sunday too
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny, c.dowAny = fields[2] == "*", fields[4] == "*"
+	return &c, nil
+}
+
+// parseCronField returns the bit set of the values of the comma separated list, the names are the values starting
+// from min
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var result uint64
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.EqualFold(s, name) {
+				return min + i, nil
+			}
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return v, nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		from, to := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if from, err = value(first); err != nil {
+				return 0, err
+			}
+			to = from
+			if isRange {
+				if to, err = value(last); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				to = max
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		for v := from; v <= to; v += step {
+			result |= 1 << v
+		}
+	}
+	return result, nil
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first matching minute after t
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// every date matches within a few years unless it's impossible like February 30
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		next := t.Add(time.Minute)
+		switch {
+		case c.month&(1<<t.Month()) == 0:
+			next = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			next = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			next = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+		default:
+			return t
+		}
+		// the time skipped by the DST change is normalized to before the gap, it's stepped over by minutes then
+		if !next.After(t) {
+			next = t.Add(time.Minute)
+		}
+		t = next
+	}
+	return time.Time{}
+}
+
+// setupSchedule checks the schedule options, they're only used by the long running modes
+func setupSchedule(command string) *cronSchedule {
+	if params.Schedule == "" && params.Interval == 0 {
+		return nil
+	}
+	if !params.Watch && command != "serve" {
+		fatal("The schedule and the interval can only be used in watch mode or with the serve command")
+	}
+	if params.Interval < 0 {
+		fatal("Invalid interval", "interval", params.Interval)
+	}
+	if params.Schedule == "" {
+		return nil
+	}
+	c, err := parseCron(params.Schedule)
+	if err != nil {
+		fatal("Invalid schedule", "schedule", params.Schedule, "error", err)
+	}
+	if c.next(time.Now()).IsZero() {
+		fatal("The schedule never runs", "schedule", params.Schedule)
+	}
+	return c
+}
+
+// runSchedule calls rescan at the times of the cron schedule and every --interval until the context is cancelled
+func runSchedule(ctx context.Context, c *cronSchedule, rescan func()) {
+	if c == nil && params.Interval == 0 {
+		return
+	}
+	go func() {
+		lastInterval := time.Now()
+		for {
+			var next time.Time
+			if c != nil {
+				next = c.next(time.Now())
+			}
+			if params.Interval > 0 {
+				if t := lastInterval.Add(params.Interval); next.IsZero() || t.Before(next) {
+					next = t
+				}
+			}
+			slog.Debug("Next scheduled rescan", "time", next)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			}
+			if params.Interval > 0 && !time.Now().Before(lastInterval.Add(params.Interval)) {
+				lastInterval = time.Now()
+			}
+			slog.Info("Scheduled rescan")
+			rescan()
+		}
+	}()
+}
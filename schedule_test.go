@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestCronNext(t *testing.T) {
+	const layout = "2006-01-02 15:04 MST"
+	tests := []struct {
+		name, expr, zone, from, want string
+	}{
+		{"next minute", "* * * * *", "UTC", "2024-01-01 10:00", "2024-01-01 10:01 UTC"},
+		{"month end", "0 0 1 * *", "UTC", "2024-01-31 23:59", "2024-02-01 00:00 UTC"},
+		{"year end", "@yearly", "UTC", "2024-12-31 23:59", "2025-01-01 00:00 UTC"},
+		{"short month skipped", "0 0 31 * *", "UTC", "2024-04-01 00:00", "2024-05-31 00:00 UTC"},
+		{"leap day", "0 0 29 2 *", "UTC", "2023-03-01 00:00", "2024-02-29 00:00 UTC"},
+		{"impossible date", "0 0 30 2 *", "UTC", "2023-03-01 00:00", ""},
+		{"day of month or week", "0 0 13 * fri", "UTC", "2024-09-01 00:00", "2024-09-06 00:00 UTC"},
+		{"day of month and any week day", "0 0 13 * *", "UTC", "2024-09-01 00:00", "2024-09-13 00:00 UTC"},
+		// the hour skipped when DST starts doesn't run that day
+		{"skipped hour", "30 2 * * *", "America/New_York", "2024-03-09 03:00", "2024-03-11 02:30 EDT"},
+		{"hourly over DST start", "0 * * * *", "America/New_York", "2024-03-10 01:30", "2024-03-10 03:00 EDT"},
+		{"hourly over DST end", "0 * * * *", "America/New_York", "2024-11-03 01:30", "2024-11-03 01:00 EST"},
+		{"repeated hour first", "30 1 * * *", "America/New_York", "2024-11-03 00:00", "2024-11-03 01:30 EDT"},
+		{"repeated hour second", "30 1 * * *", "America/New_York", "2024-11-03 01:45", "2024-11-03 01:30 EST"},
+		// DST started at midnight in Brazil so the day began at 01:00
+		{"skipped midnight", "0 0 * * *", "America/Sao_Paulo", "2018-11-03 12:00", "2018-11-05 00:00 -02"},
+		{"day after skipped midnight", "0 12 * * *", "America/Sao_Paulo", "2018-11-03 13:00",
+			"2018-11-04 12:00 -02"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loc, err := time.LoadLocation(tt.zone)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.ParseInLocation("2006-01-02 15:04", tt.from, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := c.next(from)
+			if tt.want == "" {
+				if !next.IsZero() {
+					t.Errorf("got %s, want no time", next.Format(layout))
+				}
+				return
+			}
+			if got := next.Format(layout); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// server answers the hash queries from the current cache and rescans the models on request, mu also guards the roots
+// changed by the scan
+type server struct {
+	mu     sync.RWMutex
+	cache  sdhasher.Cache
+	scanMu sync.Mutex
+	// ctx is the server context, the rescans aren't cancelled when the client goes away
+	ctx context.Context
+}
+
+type pathEntry struct {
+	Key   string         `json:"key"`
+	Path  string         `json:"path,omitempty"`
+	Entry sdhasher.Entry `json:"entry"`
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+// handleScan hashes the new and changed files and saves the cache, only one scan runs at a time
+func (s *server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if !s.scanMu.TryLock() {
+		http.Error(w, "scan is already running", http.StatusConflict)
+		return
+	}
+	defer s.scanMu.Unlock()
+	result, err := s.rescan(s.ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, result)
+}
+
+// rescan updates the served cache and returns the stats of the scan, scanMu should be held
+func (s *server) rescan(ctx context.Context) (runStats, error) {
+	s.mu.RLock()
+	result := s.cache.Clone()
+	s.mu.RUnlock()
+	changes := scan(ctx, &result)
+	if changes > 0 {
+		if err := writeCache(result); err != nil {
+			slog.Error("Error writing cache", "error", err)
+			return stats, err
+		}
+	}
+	s.mu.Lock()
+	s.cache = result
+	s.mu.Unlock()
+	stats.report()
+	sendWebhook()
+	refreshWebui()
+	return stats, nil
+}
+
+// scheduledScan runs the scheduled rescan unless one is already running
+func (s *server) scheduledScan(ctx context.Context) {
+	if !s.scanMu.TryLock() {
+		slog.Info("Scan is already running, skipping the scheduled one")
+		return
+	}
+	defer s.scanMu.Unlock()
+	s.rescan(ctx)
+}
+
+// handleHash returns the entry by the cache key or the file path
+func (s *server) handleHash(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if path := r.URL.Query().Get("path"); path != "" {
+		var err error
+		if key, err = keyFor(longPath(filepath.Clean(path))); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	e, ok := s.cache.Hashes[key]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, pathEntry{Key: key, Path: firstPath(key), Entry: e})
+}
+
+// handlePaths returns the entries with the hash, a prefix such as the AutoV2 hash is enough
+func (s *server) handlePaths(w http.ResponseWriter, r *http.Request) {
+	hash := strings.ToLower(r.URL.Query().Get("sha256"))
+	if hash == "" {
+		http.Error(w, "sha256 is required", http.StatusBadRequest)
+		return
+	}
+	s.mu.RLock()
+	result := s.withHash(hash)
+	s.mu.RUnlock()
+	writeJSON(w, result)
+}
+
+// withHash returns the entries with the hash or its prefix, mu should be held
+func (s *server) withHash(hash string) []pathEntry {
+	hash = strings.ToLower(hash)
+	result := []pathEntry{}
+	for k, e := range s.cache.Hashes {
+		if strings.HasPrefix(e.SHA256, hash) {
+			result = append(result, pathEntry{Key: k, Path: firstPath(k), Entry: e})
+		}
+	}
+	return result
+}
+
+func (s *server) handleCache(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	writeJSON(w, s.cache)
+}
+
+// firstPath returns the existing file of the key if there's any
+func firstPath(key string) string {
+	path, _, err := statKey(key)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+// serve runs the HTTP API until the context is cancelled
+func serve(ctx context.Context, result sdhasher.Cache, schedule *cronSchedule) {
+	s := &server{cache: result, ctx: ctx}
+	scanState = &s.mu
+	runSchedule(ctx, schedule, func() { s.scheduledScan(ctx) })
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scan", s.handleScan)
+	mux.HandleFunc("GET /hash", s.handleHash)
+	mux.HandleFunc("GET /paths", s.handlePaths)
+	mux.HandleFunc("GET /cache", s.handleCache)
+	mux.Handle("GET /metrics", &metrics)
+	dashboard.register(mux, true)
+	srv := &http.Server{
+		Addr:        serveOptions.Listen,
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if serveOptions.GRPCListen != "" {
+		listenGRPC(ctx, s)
+	}
+	l, err := net.Listen("tcp", serveOptions.Listen)
+	if err != nil {
+		fatal("Error serving", "address", serveOptions.Listen, "error", err)
+	}
+	slog.Info("Serving", "address", serveOptions.Listen)
+	sdNotify("READY=1")
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		fatal("Error serving", "address", serveOptions.Listen, "error", err)
+	}
+}
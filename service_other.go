@@ -0,0 +1,12 @@
+//go:build !windows
+
+package main
+
+func startService(stop func()) {}
+
+func stopService(code int) {}
+
+func manageService(args []string) bool {
+	fatal("Services are only supported on Windows, see the README for a systemd unit")
+	return true
+}
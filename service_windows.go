@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsService is set when running under the service control manager
+var windowsService struct {
+	exit chan int
+	done chan struct{}
+}
+
+// serviceHandler reports the service state and cancels the run when the service is stopped
+type serviceHandler struct {
+	stop func()
+}
+
+func (h serviceHandler) Execute(_ []string, requests <-chan svc.ChangeRequest,
+	status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.stop()
+			}
+		case code := <-windowsService.exit:
+			// the non-zero codes are ours and not the Win32 errors
+			return code != 0, uint32(code)
+		}
+	}
+}
+
+// eventlogWriter writes the text handler lines without the time to the event log
+type eventlogWriter struct {
+	log *eventlog.Log
+}
+
+func (w eventlogWriter) Write(p []byte) (int, error) {
+	level, text, ok := splitLogLine(p)
+	if !ok {
+		level, text = "INFO", p
+	}
+	msg := strings.TrimSpace(string(text))
+	var err error
+	switch level {
+	case "ERROR":
+		err = w.log.Error(1, msg)
+	case "WARN":
+		err = w.log.Warning(1, msg)
+	default:
+		err = w.log.Info(1, msg)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startService reports to the service control manager if sdhasher was started as a Windows service and sends the log
+// to the event log, stopping the service calls stop
+func startService(stop func()) {
+	if ok, err := svc.IsWindowsService(); err != nil || !ok {
+		return
+	}
+	if elog, err := eventlog.Open(serviceOptions.Name); err == nil {
+		out := io.MultiWriter(eventlogWriter{elog}, &recentErrors)
+		opts := &slog.HandlerOptions{Level: logLevel(), ReplaceAttr: dropTime}
+		slog.SetDefault(slog.New(slog.NewTextHandler(out, opts)))
+	}
+	windowsService.exit = make(chan int)
+	windowsService.done = make(chan struct{})
+	go func() {
+		defer close(windowsService.done)
+		if err := svc.Run(serviceOptions.Name, serviceHandler{stop}); err != nil {
+			slog.Error("Error running service", "name", serviceOptions.Name, "error", err)
+		}
+	}()
+}
+
+// stopService reports the exit code to the service control manager before the process exits
+func stopService(code int) {
+	if windowsService.exit == nil {
+		return
+	}
+	select {
+	case windowsService.exit <- code:
+		<-windowsService.done
+	case <-windowsService.done:
+	}
+	windowsService.exit = nil
+}
+
+// manageService installs, uninstalls, starts or stops the service, it returns false for the run action used by the
+// service control manager to start sdhasher
+func manageService(args []string) bool {
+	if len(args) != 1 {
+		fatal("The action is required", "actions", serviceActions)
+	}
+	name := serviceOptions.Name
+	if args[0] == "run" {
+		return false
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fatal("Error connecting to the service manager, run as administrator", "error", err)
+	}
+	defer m.Disconnect()
+	switch args[0] {
+	case "install":
+		installService(m, name)
+	case "uninstall":
+		s, err := m.OpenService(name)
+		if err != nil {
+			fatal("Error opening service", "name", name, "error", err)
+		}
+		defer s.Close()
+		if status, err := s.Query(); err == nil && status.State != svc.Stopped {
+			controlService(s, svc.Stop, svc.Stopped)
+		}
+		if err := s.Delete(); err != nil {
+			fatal("Error removing service", "name", name, "error", err)
+		}
+		if err := eventlog.Remove(name); err != nil {
+			slog.Warn("Error removing the event log source", "name", name, "error", err)
+		}
+		slog.Info("Service removed", "name", name)
+	case "start":
+		s, err := m.OpenService(name)
+		if err != nil {
+			fatal("Error opening service", "name", name, "error", err)
+		}
+		defer s.Close()
+		if err := s.Start(); err != nil {
+			fatal("Error starting service", "name", name, "error", err)
+		}
+		slog.Info("Service started", "name", name)
+	case "stop":
+		s, err := m.OpenService(name)
+		if err != nil {
+			fatal("Error opening service", "name", name, "error", err)
+		}
+		defer s.Close()
+		controlService(s, svc.Stop, svc.Stopped)
+		slog.Info("Service stopped", "name", name)
+	default:
+		fatal("Unknown action", "action", args[0], "actions", serviceActions)
+	}
+	return true
+}
+
+// installService creates the service running this executable in watch mode with the options of the command line
+func installService(m *mgr.Mgr, name string) {
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Error finding the executable", "error", err)
+	}
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		fatal("Service already exists", "name", name)
+	}
+	args := serviceArgs(os.Args[1:], "install")
+	if !params.Watch {
+		args = append(args, "--watch")
+	}
+	// the service account has its own config directory and environment
+	config := params.Config
+	if config == "" {
+		config = configFile(nil)
+	}
+	if config != "" {
+		if abs, err := filepath.Abs(config); err == nil {
+			config = abs
+		}
+		args = append(args, "--config", config)
+	}
+	args = append(args, "service", "run", "--name", name)
+	s, err := m.CreateService(name, exe, mgr.Config{DisplayName: "sdhasher (" + name + ")",
+		Description: "Keeps the hashes of the Stable Diffusion models up to date",
+		StartType:   mgr.StartAutomatic, DelayedAutoStart: true}, args...)
+	if err != nil {
+		fatal("Error creating service", "name", name, "error", err)
+	}
+	defer s.Close()
+	if err := eventlog.InstallAsEventCreate(name, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		slog.Warn("Error adding the event log source", "name", name, "error", err)
+	}
+	slog.Info("Service installed, start it with the start action", "name", name, "command",
+		fmt.Sprintf("%s %s", exe, strings.Join(args, " ")))
+}
+
+// serviceArgs removes the service command, its action and its options and the config file from the command line
+// leaving the options of the run
+func serviceArgs(cmdline []string, action string) []string {
+	var result []string
+	command, skipAction := false, true
+	for i := 0; i < len(cmdline); i++ {
+		arg := cmdline[i]
+		switch {
+		case !command && arg == "service":
+			command = true
+		case command && skipAction && arg == action:
+			skipAction = false
+		case command && arg == "--name":
+			i++
+		case command && strings.HasPrefix(arg, "--name="):
+		case arg == "--config":
+			i++
+		case strings.HasPrefix(arg, "--config="):
+		default:
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// controlService sends the command and waits for the state
+func controlService(s *mgr.Service, cmd svc.Cmd, state svc.State) {
+	status, err := s.Control(cmd)
+	if err != nil {
+		fatal("Error controlling service", "error", err)
+	}
+	deadline := time.Now().Add(time.Minute)
+	for status.State != state {
+		if time.Now().After(deadline) {
+			fatal("Timeout waiting for the service state", "state", state)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			fatal("Error querying service", "error", err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os/exec"
+	"path"
+	"sync"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpFS is a models directory on a remote host accessed with the SFTP protocol over the ssh client, it needs neither
+// a shell nor the GNU tools on the host so it works with the SFTP-only accounts
+type sftpFS struct {
+	sshTarget
+	// conns limits the number of the files streamed at the same time
+	conns chan struct{}
+	// connect starts the SFTP session, it runs the ssh client with the sftp subsystem
+	connect func() (io.Reader, io.WriteCloser, error)
+	mu      sync.Mutex
+	client  *sftpSession
+}
+
+// sftpSession is the SFTP client of the session, ended is closed when the session ends
+type sftpSession struct {
+	*sftp.Client
+	ended chan struct{}
+}
+
+func newSFTPFS(path string) (remoteFS, error) {
+	t, err := parseSSHURL(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sftpFS{sshTarget: t, conns: make(chan struct{}, max(params.SSHConnections, 1))}
+	s.connect = s.startSSH
+	return s, nil
+}
+
+func (s *sftpFS) startSSH() (io.Reader, io.WriteCloser, error) {
+	args := append(s.args(), "-s", s.target, "sftp")
+	cmd := exec.Command(params.SSHCommand, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	// the client exits when the session is closed, the output is read until then
+	r, w := io.Pipe()
+	go func() {
+		io.Copy(w, out)
+		err := cmd.Wait()
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		w.CloseWithError(commandError(err, &stderr))
+	}()
+	return r, in, nil
+}
+
+// session returns the SFTP client, a new session is started if the previous one has ended
+func (s *sftpFS) session() (*sftpSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		select {
+		case <-s.client.ended:
+		default:
+			return s.client, nil
+		}
+	}
+	r, w, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	c, err := sftp.NewClientPipe(r, w)
+	if err != nil {
+		w.Close()
+		return nil, fmt.Errorf("error starting SFTP session with %s: %w", s.target, err)
+	}
+	s.client = &sftpSession{Client: c, ended: make(chan struct{})}
+	go func(session *sftpSession) {
+		session.Wait()
+		close(session.ended)
+	}(s.client)
+	return s.client, nil
+}
+
+// linkTarget returns the real path of the linked directory, the link is read first as not all servers resolve the
+// links in realpath
+func (c *sftpSession) linkTarget(p string) (string, error) {
+	target, err := c.ReadLink(p)
+	if err != nil {
+		return "", err
+	}
+	if !path.IsAbs(target) {
+		target = path.Join(path.Dir(p), target)
+	}
+	return c.RealPath(target)
+}
+
+func (s *sftpFS) list(ctx context.Context) ([]remoteFile, error) {
+	c, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+	root, err := c.RealPath(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s:%s: %w", s.target, s.dir, err)
+	}
+	// the linked directories are walked once so that the link loops end
+	visited := map[string]bool{root: true}
+	var result []remoteFile
+	var walk func(dir, prefix string) error
+	walk = func(dir, prefix string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entries, err := c.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("error listing %s:%s: %w", s.target, dir, err)
+		}
+		for _, fi := range entries {
+			p, name := path.Join(dir, fi.Name()), prefix+fi.Name()
+			if fi.Mode()&fs.ModeSymlink != 0 {
+				if params.Symlinks == "skip" {
+					continue
+				}
+				if fi, err = c.Stat(p); err != nil {
+					slog.Warn("Error following symlink", "path", s.target+":"+p, "error", err)
+					continue
+				}
+				if fi.IsDir() {
+					resolved, err := c.linkTarget(p)
+					if err != nil || visited[resolved] {
+						continue
+					}
+					visited[resolved] = true
+				}
+			}
+			switch {
+			case fi.IsDir():
+				if err := walk(p, name+"/"); err != nil {
+					return err
+				}
+			case fi.Mode().IsRegular():
+				result = append(result, remoteFile{name: name, size: fi.Size(), mtime: fi.ModTime()})
+			}
+		}
+		return nil
+	}
+	if err := walk(root, ""); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *sftpFS) open(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	select {
+	case s.conns <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-s.conns }
+	c, err := s.session()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	f, err := c.Open(path.Join(s.dir, name))
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	return &sftpReader{ctx: ctx, File: f, release: release}, nil
+}
+
+// sftpReader streams the file, the large reads are split into several requests in flight so that the latency of the
+// connection doesn't limit the speed
+type sftpReader struct {
+	ctx context.Context
+	*sftp.File
+	release func()
+}
+
+func (r *sftpReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.File.Read(p)
+}
+
+func (r *sftpReader) Close() error {
+	err := r.File.Close()
+	r.release()
+	return err
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// discardCloser accepts all writes
+type discardCloser struct{}
+
+func (discardCloser) Write(p []byte) (int, error) { return len(p), nil }
+func (discardCloser) Close() error                { return nil }
+
+// sftpPipe is the connection of the SFTP server
+type sftpPipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// newTestSFTP returns the remote storage served by the SFTP server from the home directory, the number of the started
+// sessions is counted
+func newTestSFTP(t *testing.T, home, dir string, sessions *int) *sftpFS {
+	s := &sftpFS{sshTarget: sshTarget{target: "host", dir: dir}, conns: make(chan struct{}, 2)}
+	s.connect = func() (io.Reader, io.WriteCloser, error) {
+		*sessions++
+		requests, requestsW := io.Pipe()
+		responses, responsesW := io.Pipe()
+		server, err := sftp.NewServer(sftpPipe{requests, responsesW}, sftp.WithServerWorkingDirectory(home),
+			sftp.ReadOnly())
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			server.Serve()
+			responsesW.Close()
+		}()
+		t.Cleanup(func() { requestsW.Close() })
+		return responses, requestsW, nil
+	}
+	return s
+}
+
+func TestSFTPList(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	home := t.TempDir()
+	dir := filepath.Join(home, "models")
+	for name, size := range map[string]int{"a.safetensors": 100, "sub/b.ckpt": 200, "notes.txt": 3} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"linked.safetensors": "a.safetensors",
+		"broken.safetensors": "missing.safetensors",
+		// the loop is listed once
+		"sub/loop": "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skip("symlinks aren't supported:", err)
+		}
+	}
+	tests := []struct {
+		symlinks string
+		dir      string
+		want     []string
+	}{
+		{"follow", "models", []string{"a.safetensors", "linked.safetensors", "notes.txt", "sub/b.ckpt"}},
+		{"skip", dir, []string{"a.safetensors", "notes.txt", "sub/b.ckpt"}},
+	}
+	for _, tt := range tests {
+		params.Symlinks = tt.symlinks
+		sessions := 0
+		files, err := newTestSFTP(t, home, tt.dir, &sessions).list(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, f := range files {
+			got = append(got, f.name)
+			if want, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.name))); err != nil ||
+				f.size != want.Size() || f.mtime.Unix() != want.ModTime().Unix() {
+				t.Errorf("%s: got size %d and mtime %v, want %+v", f.name, f.size, f.mtime, want)
+			}
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.symlinks, got, tt.want)
+		}
+	}
+}
+
+func TestSFTPOpen(t *testing.T) {
+	home := t.TempDir()
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	if err := os.WriteFile(filepath.Join(home, "model.safetensors"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sessions := 0
+	s := newTestSFTP(t, home, ".", &sessions)
+	for _, offset := range []int64{0, 1000, 32 << 10, int64(len(data)) - 1, int64(len(data))} {
+		r, err := s.open(context.Background(), "model.safetensors", offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("offset %d: %v", offset, err)
+		}
+		if !bytes.Equal(got, data[offset:]) {
+			t.Errorf("offset %d: got %d bytes, want the %d bytes after the offset", offset, len(got),
+				len(data)-int(offset))
+		}
+	}
+	// the reader closed before the end leaves the session usable
+	r, err := s.open(context.Background(), "model.safetensors", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if _, err := s.open(context.Background(), "missing.safetensors", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("missing file: got error %v, want %v", err, fs.ErrNotExist)
+	}
+	if sessions != 1 {
+		t.Errorf("got %d sessions, want 1", sessions)
+	}
+	// a new session is started after the previous one ends
+	s.client.Close()
+	<-s.client.ended
+	if _, err := s.list(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sessions != 2 {
+		t.Errorf("got %d sessions, want 2", sessions)
+	}
+}
+
+func TestSFTPMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"zero length", []byte{0, 0, 0, 0}},
+		{"huge length", binary.BigEndian.AppendUint32(nil, 1<<31)},
+		{"truncated", append(binary.BigEndian.AppendUint32(nil, 9), 2, 0, 0)},
+		{"not version", append(binary.BigEndian.AppendUint32(nil, 5), 101, 0, 0, 0, 3)},
+		{"old version", append(binary.BigEndian.AppendUint32(nil, 5), 2, 0, 0, 0, 2)},
+	}
+	for _, tt := range tests {
+		s := &sftpFS{sshTarget: sshTarget{target: "host", dir: "."}, conns: make(chan struct{}, 1)}
+		s.connect = func() (io.Reader, io.WriteCloser, error) {
+			return bytes.NewReader(tt.data), discardCloser{}, nil
+		}
+		if _, err := s.session(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
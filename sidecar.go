@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+const sha256Ext = ".sha256"
+
+// readSidecar returns the hash from the <file>.sha256 file if it's newer than the model, the file contains the hash
+// alone or a sha256sum line
+func readSidecar(t *task) string {
+	info, err := t.d.Info()
+	if err != nil {
+		return ""
+	}
+	fi, err := os.Stat(t.path + sha256Ext)
+	if err != nil || !fi.ModTime().After(info.ModTime()) {
+		return ""
+	}
+	data, err := os.ReadFile(t.path + sha256Ext)
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return ""
+	}
+	hash := strings.ToLower(strings.TrimPrefix(fields[0], "\\"))
+	if b, err := hex.DecodeString(hash); err != nil || len(b) != 32 {
+		return ""
+	}
+	return hash
+}
+
+// writeSidecars writes the <file>.sha256 files in the sha256sum format with --write-sidecars, the remote roots are
+// read-only
+func writeSidecars(hashed []*sdhasher.Entry) {
+	if !params.WriteSidecars {
+		return
+	}
+	for _, e := range hashed {
+		if r := rootFor(e.Path); r == nil || r.remote != nil {
+			continue
+		}
+		line := fmt.Sprintf("%s  %s\n", e.SHA256, filepath.Base(e.Path))
+		if err := os.WriteFile(e.Path+sha256Ext, []byte(line), 0644); err != nil {
+			slog.Error("Error writing sidecar", "path", e.Path+sha256Ext, "error", err)
+		}
+	}
+}
+
+// renameSidecar replaces the file name in the sha256sum line of the sidecar after the model is renamed, the sidecars
+// with the hash alone are left as is
+func renameSidecar(path, name string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	hash, rest, ok := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if !ok {
+		return nil
+	}
+	// the new names never need escaping so the backslash marking it is dropped
+	line := strings.TrimPrefix(hash, "\\") + "  " + name + "\n"
+	if strings.HasPrefix(rest, "*") {
+		line = strings.TrimPrefix(hash, "\\") + " *" + name + "\n"
+	}
+	return os.WriteFile(path, []byte(line), 0644)
+}
+
+// hashFiles hashes the tasks, with --read-sidecars the hashes from the sidecar files are used instead unless the file
+// needs other hashes or is picked for the sample verification
+func hashFiles(ctx context.Context, tasks []*task,
+	autosave func(hashed []*sdhasher.Entry, due bool)) []*sdhasher.Entry {
+	tasks, duplicates := dedupTasks(tasks)
+	if !params.ReadSidecars || len(params.ExtraHashes) > 0 {
+		hashed := hashTasks(ctx, tasks, autosave)
+		writeSidecars(hashed)
+		return copyDuplicates(hashed, duplicates)
+	}
+	var trusted []*sdhasher.Entry
+	var rest []*task
+	expected := map[string]string{}
+	for _, t := range tasks {
+		hash := readSidecar(t)
+		if hash == "" || wantAddnet(t.path) || wantMetadata(t.path) || wantKohya(t.path) || wantGGUF(t.path) ||
+			wantModelType(t.path) {
+			rest = append(rest, t)
+			continue
+		}
+		if rand.Intn(100) < params.SidecarSample {
+			expected[t.path] = hash
+			rest = append(rest, t)
+			continue
+		}
+		info, err := t.d.Info()
+		if err != nil {
+			rest = append(rest, t)
+			continue
+		}
+		slog.Info("Using sidecar hash", "path", t.path, "sha256", hash)
+		trusted = append(trusted, &sdhasher.Entry{MTime: mtimeMode().FileMTime(info), SHA256: hash, Size: info.Size(),
+			Path: t.path, Key: t.key})
+	}
+	hashed := hashTasks(ctx, rest, autosave)
+	writeSidecars(hashed)
+	for _, e := range hashed {
+		if hash, ok := expected[e.Path]; ok && hash != e.SHA256 {
+			slog.Warn("Sidecar hash mismatch", "path", e.Path, "sidecar", hash, "actual", e.SHA256)
+		}
+	}
+	return copyDuplicates(append(trusted, hashed...), duplicates)
+}
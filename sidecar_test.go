@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func TestReadSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "model.safetensors")
+	if err := os.WriteFile(path, []byte("model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	const hash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	modelTime := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, modelTime, modelTime); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		content string
+		older   bool
+		want    string
+	}{
+		{"hash alone", hash + "\n", false, hash},
+		{"sha256sum line", hash + "  model.safetensors\n", false, hash},
+		{"binary mode", hash + " *model.safetensors\n", false, hash},
+		{"escaped name", `\` + hash + `  model\\x.safetensors` + "\n", false, hash},
+		{"upper case", "B94D27B9934D3E08A52E52D7DA7DABFAC484EFE37A5380EE9088F7ACE2EFCDE9\n", false, hash},
+		{"older than the model", hash + "\n", true, ""},
+		{"short hash", hash[:32] + "\n", false, ""},
+		{"not hex", "z" + hash[1:] + "\n", false, ""},
+		{"empty", "", false, ""},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path+sha256Ext, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		sidecarTime := time.Now()
+		if tt.older {
+			sidecarTime = modelTime.Add(-time.Minute)
+		}
+		if err := os.Chtimes(path+sha256Ext, sidecarTime, sidecarTime); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readSidecar(newTask(path, "model.safetensors", fs.FileInfoToDirEntry(fi))); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteSidecars(t *testing.T) {
+	savedParams, savedRoots := params, roots
+	t.Cleanup(func() { params, roots = savedParams, savedRoots })
+	dir, remoteDir := t.TempDir(), t.TempDir()
+	// the remote root has a local path so that a sidecar written by mistake would be seen
+	remote := fakeRemote{{name: "remote.safetensors", size: 5}}
+	roots = []root{
+		{path: dir, fsys: localStorage{dir: dir}},
+		{path: remoteDir, prefix: "remote/", remote: remote, fsys: newListedStorage(remote, remote)},
+	}
+	params.WriteSidecars = true
+	local, remotePath := filepath.Join(dir, "model.safetensors"), remoteDir+"/remote.safetensors"
+	writeSidecars([]*sdhasher.Entry{{SHA256: "abc", Path: local}, {SHA256: "def", Path: remotePath}})
+	data, err := os.ReadFile(local + sha256Ext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "abc  model.safetensors\n"; string(data) != want {
+		t.Errorf("got %q, want %q", data, want)
+	}
+	if _, err := os.Stat(remotePath + sha256Ext); err == nil {
+		t.Error("sidecar of the remote file is written")
+	}
+}
+
+func TestRenameSidecar(t *testing.T) {
+	const hash = "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"text mode", hash + "  old.safetensors\n", hash + "  new name.safetensors\n"},
+		{"binary mode", hash + " *old.safetensors\n", hash + " *new name.safetensors\n"},
+		{"escaped name", `\` + hash + `  old\\x.safetensors` + "\n", hash + "  new name.safetensors\n"},
+		{"hash alone", hash + "\n", hash + "\n"},
+	}
+	path := filepath.Join(t.TempDir(), "new name.safetensors"+sha256Ext)
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := renameSidecar(path, "new name.safetensors"); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
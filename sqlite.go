@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema keeps the JSON value of every cache entry in its own row so that only the changed entries are written,
+// the values can be queried with the JSON functions, such as json_extract(value, '$.sha256')
+const sqliteSchema = "CREATE TABLE IF NOT EXISTS cache (section TEXT NOT NULL, key TEXT NOT NULL, " +
+	"value TEXT NOT NULL, PRIMARY KEY (section, key)) WITHOUT ROWID"
+
+// sqliteStore keeps the cache in an SQLite database
+type sqliteStore struct {
+	db   *sql.DB
+	path string
+}
+
+func openSQLiteStore(path string) (store, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", path, err)
+	}
+	// the timeout is set for the connection, the writes are done one at a time anyway
+	db.SetMaxOpenConns(1)
+	// the webui or another sdhasher may be reading the database at the same time
+	for _, query := range []string{"PRAGMA busy_timeout = 10000", sqliteSchema} {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error opening %s: %w", path, err)
+		}
+	}
+	return sqliteStore{db: db, path: path}, nil
+}
+
+func (s sqliteStore) load() (storeRows, error) {
+	result, err := s.db.Query("SELECT section, key, value FROM cache")
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", s.path, err)
+	}
+	defer result.Close()
+	rows := storeRows{}
+	for result.Next() {
+		var section, key, value string
+		if err := result.Scan(&section, &key, &value); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", s.path, err)
+		}
+		if rows[section] == nil {
+			rows[section] = map[string]string{}
+		}
+		rows[section][key] = value
+	}
+	if err := result.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", s.path, err)
+	}
+	return rows, nil
+}
+
+func (s sqliteStore) write(changes []storeChange) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	remove, err := tx.Prepare("DELETE FROM cache WHERE section = ? AND key = ?")
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT OR REPLACE INTO cache (section, key, value) VALUES (?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if c.value == "" {
+			_, err = remove.Exec(c.section, c.key)
+		} else {
+			_, err = insert.Exec(c.section, c.key, c.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s sqliteStore) close() error {
+	return s.db.Close()
+}
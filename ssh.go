@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshTarget is the host and the directory of the ssh:// and sftp:// URLs
+type sshTarget struct {
+	// target is the host with the optional user name
+	target string
+	port   string
+	dir    string
+}
+
+func parseSSHURL(path string) (sshTarget, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return sshTarget{}, err
+	}
+	if u.Hostname() == "" {
+		return sshTarget{}, fmt.Errorf("no host in %s", path)
+	}
+	t := sshTarget{target: u.Hostname(), port: u.Port(), dir: u.Path}
+	if u.User != nil {
+		t.target = u.User.Username() + "@" + t.target
+	}
+	// ssh://host/~/models is relative to the home directory
+	if rest, ok := strings.CutPrefix(t.dir, "/~/"); ok {
+		t.dir = rest
+	}
+	if t.dir == "" || t.dir == "/~" {
+		t.dir = "."
+	}
+	return t, nil
+}
+
+// args returns the arguments of the ssh client before the target
+func (t sshTarget) args() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if runtime.GOOS != "windows" {
+		// all the commands share one connection
+		args = append(args, "-o", "ControlMaster=auto", "-o", "ControlPersist=60",
+			"-o", "ControlPath="+filepath.Join(os.TempDir(), "sdhasher-ssh-%C"))
+	}
+	if t.port != "" {
+		args = append(args, "-p", t.port)
+	}
+	return args
+}
+
+// sshFS is a models directory on a remote host accessed by running commands with the ssh client, the account needs a
+// shell, and find from GNU findutils and tail from GNU coreutils as on any Linux box, BusyBox, macOS, BSD and Windows
+// hosts and the SFTP-only accounts are served by sftpFS instead
+type sshFS struct {
+	sshTarget
+	// conns limits the number of the files streamed at the same time
+	conns chan struct{}
+}
+
+func newSSHFS(path string) (remoteFS, error) {
+	t, err := parseSSHURL(path)
+	if err != nil {
+		return nil, err
+	}
+	return &sshFS{sshTarget: t, conns: make(chan struct{}, max(params.SSHConnections, 1))}, nil
+}
+
+// command runs the shell command on the remote host
+func (s *sshFS) command(ctx context.Context, command string) *exec.Cmd {
+	args := append(s.args(), s.target, "--", command)
+	return exec.CommandContext(ctx, params.SSHCommand, args...)
+}
+
+func (s *sshFS) list(ctx context.Context) ([]remoteFile, error) {
+	follow := "-L "
+	if params.Symlinks == "skip" {
+		follow = ""
+	}
+	cmd := s.command(ctx, "find "+follow+shellQuote(s.dir+"/")+` -type f -printf '%s %T@ %P\0'`)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing %s:%s: %w", s.target, s.dir, commandError(err, &stderr))
+	}
+	var result []remoteFile
+	for _, line := range strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected find output %q", line)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected find output %q", line)
+		}
+		mtime, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected find output %q", line)
+		}
+		sec := int64(mtime)
+		result = append(result, remoteFile{name: fields[2], size: size,
+			mtime: time.Unix(sec, int64((mtime-float64(sec))*1e9))})
+	}
+	return result, nil
+}
+
+func (s *sshFS) open(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	select {
+	case s.conns <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := s.command(ctx, "tail -c +"+strconv.FormatInt(offset+1, 10)+" "+shellQuote(s.dir+"/"+name))
+	r := &sshReader{cmd: cmd, cancel: cancel, release: func() { <-s.conns }}
+	cmd.Stderr = &r.stderr
+	out, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		cancel()
+		r.release()
+		return nil, err
+	}
+	r.out = bufio.NewReader(out)
+	return r, nil
+}
+
+// sshReader streams the output of the remote command, a failed command is reported as the read error instead of EOF
+// so that a truncated file isn't hashed
+type sshReader struct {
+	cmd     *exec.Cmd
+	out     io.Reader
+	stderr  bytes.Buffer
+	cancel  context.CancelFunc
+	release func()
+	done    bool
+}
+
+func (r *sshReader) Read(p []byte) (int, error) {
+	n, err := r.out.Read(p)
+	if err == io.EOF && !r.done {
+		r.done = true
+		if werr := r.cmd.Wait(); werr != nil {
+			return n, commandError(werr, &r.stderr)
+		}
+	}
+	return n, err
+}
+
+func (r *sshReader) Close() error {
+	r.cancel()
+	if !r.done {
+		r.done = true
+		r.cmd.Wait()
+	}
+	r.release()
+	return nil
+}
+
+// commandError adds the error output of the command to the error
+func commandError(err error, stderr *bytes.Buffer) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// shellQuote quotes the string for the POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
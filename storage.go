@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// storage is the file system of a models directory, the names are relative to the directory and separated with
+// slashes as in io/fs, OpenAt is the extension to stream the big files starting from an offset
+type storage interface {
+	fs.StatFS
+	fs.ReadDirFS
+	OpenAt(ctx context.Context, name string, offset int64) (io.ReadCloser, error)
+}
+
+// localStorage is a models directory on the local disk
+type localStorage struct {
+	dir string
+}
+
+// path returns the OS path of the name
+func (s localStorage) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+func (s localStorage) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Open(s.path(name))
+}
+
+func (s localStorage) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.Stat(s.path(name))
+}
+
+func (s localStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	return os.ReadDir(s.path(name))
+}
+
+func (s localStorage) OpenAt(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	f, err := openFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// listedStorage serves the listing of a remote directory as a read-only file system, the files are streamed by the
+// remote backend
+type listedStorage struct {
+	remote remoteFS
+	files  map[string]remoteFile
+	dirs   map[string][]fs.DirEntry
+}
+
+func newListedStorage(remote remoteFS, files []remoteFile) *listedStorage {
+	s := &listedStorage{remote: remote, files: map[string]remoteFile{}, dirs: map[string][]fs.DirEntry{".": nil}}
+	for _, f := range files {
+		s.files[f.name] = f
+		s.add(f.name, fs.FileInfoToDirEntry(f))
+	}
+	for _, entries := range s.dirs {
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	}
+	return s
+}
+
+// add adds the entry to its parent directory creating the missing parents
+func (s *listedStorage) add(name string, entry fs.DirEntry) {
+	dir := path.Dir(name)
+	_, exists := s.dirs[dir]
+	s.dirs[dir] = append(s.dirs[dir], entry)
+	if !exists && dir != "." {
+		s.add(dir, fs.FileInfoToDirEntry(remoteDir(path.Base(dir))))
+	}
+}
+
+func (s *listedStorage) Stat(name string) (fs.FileInfo, error) {
+	if f, ok := s.files[name]; ok {
+		return f, nil
+	}
+	if _, ok := s.dirs[name]; ok {
+		return remoteDir(path.Base(name)), nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (s *listedStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, ok := s.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+func (s *listedStorage) Open(name string) (fs.File, error) {
+	fi, err := s.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &listedFile{storage: s, name: name, info: fi}, nil
+}
+
+func (s *listedStorage) OpenAt(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	if _, ok := s.files[name]; !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return s.remote.open(ctx, name, offset)
+}
+
+// listedFile is an opened file or directory of listedStorage, the file is streamed on the first read
+type listedFile struct {
+	storage *listedStorage
+	name    string
+	info    fs.FileInfo
+	body    io.ReadCloser
+	read    int
+}
+
+func (f *listedFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *listedFile) Read(p []byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if f.body == nil {
+		body, err := f.storage.OpenAt(context.Background(), f.name, 0)
+		if err != nil {
+			return 0, err
+		}
+		f.body = body
+	}
+	return f.body.Read(p)
+}
+
+func (f *listedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.storage.ReadDir(f.name)
+	if err != nil {
+		return nil, err
+	}
+	entries = entries[min(f.read, len(entries)):]
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	f.read += len(entries)
+	return entries, nil
+}
+
+func (f *listedFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+// remoteDir is a directory of listedStorage
+type remoteDir string
+
+func (d remoteDir) Name() string       { return string(d) }
+func (d remoteDir) Size() int64        { return 0 }
+func (d remoteDir) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d remoteDir) ModTime() time.Time { return time.Time{} }
+func (d remoteDir) IsDir() bool        { return true }
+func (d remoteDir) Sys() any           { return nil }
+
+// storageFor returns the storage of the root containing the path and the name of the path in it, the paths outside
+// of the models directories are opened from their parent directory
+func storageFor(p string) (storage, string, error) {
+	r := rootFor(p)
+	if r == nil {
+		return localStorage{dir: filepath.Dir(p)}, filepath.Base(p), nil
+	}
+	if r.fsys == nil {
+		return nil, "", fmt.Errorf("%s: remote directory isn't listed", p)
+	}
+	return r.fsys, r.rel(p), nil
+}
+
+// rel returns the storage name of the path in the root
+func (r *root) rel(p string) string {
+	if r.remote != nil {
+		return strings.TrimPrefix(p, r.path+"/")
+	}
+	rel, err := filepath.Rel(r.path, p)
+	if err != nil {
+		return filepath.ToSlash(p)
+	}
+	return filepath.ToSlash(rel)
+}
+
+// join returns the path of the storage name in the root
+func (r *root) join(name string) string {
+	if name == "." {
+		return r.path
+	}
+	if r.remote != nil {
+		return r.path + "/" + name
+	}
+	return filepath.Join(r.path, filepath.FromSlash(name))
+}
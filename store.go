@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// storeRows are the cache as the stores keep it: the JSON values of the entries by their keys by the sections, the
+// sections that aren't objects are kept whole under the empty key
+type storeRows map[string]map[string]string
+
+// storeChange is a row to write, the value is empty for the removed rows
+type storeChange struct {
+	section string
+	key     string
+	value   string
+}
+
+// store is the database the cache is kept in with --store instead of reading and rewriting the whole cache file
+type store interface {
+	// load returns all rows
+	load() (storeRows, error)
+	// write applies the changes atomically
+	write(changes []storeChange) error
+	close() error
+}
+
+// storeSchemes open the stores of the --store values
+var storeSchemes = map[string]func(path string) (store, error){
+	"bolt":   openBoltStore,
+	"sqlite": openSQLiteStore,
+}
+
+// cacheStore is the open --store, saved is what it contains so that only the changed rows are written
+type cacheStore struct {
+	store
+	saved storeRows
+}
+
+// db is nil without --store
+var db *cacheStore
+
+// openStore opens the store of the --store value and reads its rows
+func openStore(value string) (*cacheStore, error) {
+	scheme, path, _ := strings.Cut(value, ":")
+	open, ok := storeSchemes[scheme]
+	if !ok || path == "" {
+		return nil, fmt.Errorf("unknown store %q, use sqlite:PATH or bolt:PATH", value)
+	}
+	s, err := open(path)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.load()
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	if rows == nil {
+		rows = storeRows{}
+	}
+	return &cacheStore{store: s, saved: rows}, nil
+}
+
+// empty reports whether the store has no entries, such as the one just created
+func (s *cacheStore) empty() bool {
+	return len(s.saved["hashes"]) == 0
+}
+
+// cache assembles the cache from the stored rows
+func (s *cacheStore) cache() (sdhasher.Cache, error) {
+	var result sdhasher.Cache
+	sections := map[string]json.RawMessage{}
+	for section, keys := range s.saved {
+		// the stores may keep the sections removed from the cache
+		if len(keys) == 0 {
+			continue
+		}
+		if value, ok := keys[""]; ok && len(keys) == 1 {
+			sections[section] = json.RawMessage(value)
+			continue
+		}
+		fields := make(map[string]json.RawMessage, len(keys))
+		for k, v := range keys {
+			fields[k] = json.RawMessage(v)
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return result, fmt.Errorf("error reading section %s: %w", section, err)
+		}
+		sections[section] = data
+	}
+	data, err := json.Marshal(sections)
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// save writes the rows of the cache that differ from the stored ones
+func (s *cacheStore) save(c sdhasher.Cache) error {
+	rows, err := cacheRows(c)
+	if err != nil {
+		return err
+	}
+	changes := s.changed(rows)
+	for section, keys := range s.saved {
+		for k := range keys {
+			if _, ok := rows[section][k]; !ok {
+				changes = append(changes, storeChange{section: section, key: k})
+			}
+		}
+	}
+	if err := s.apply(changes); err != nil {
+		return err
+	}
+	s.saved = rows
+	return nil
+}
+
+// saveHashed writes the rows of the freshly hashed entries, the entries of the result they replace keep their unknown
+// fields the same way they do in the saved cache
+func (s *cacheStore) saveHashed(result sdhasher.Cache, hashed []*sdhasher.Entry) error {
+	c := sdhasher.Cache{}
+	c.Init()
+	for _, e := range hashed {
+		if previous, ok := result.Hashes[e.Key]; ok {
+			c.Hashes[e.Key] = previous
+		}
+	}
+	applyHashed(&c, hashed)
+	rows, err := cacheRows(c)
+	if err != nil {
+		return err
+	}
+	changes := s.changed(rows)
+	if err := s.apply(changes); err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		if s.saved[ch.section] == nil {
+			s.saved[ch.section] = map[string]string{}
+		}
+		s.saved[ch.section][ch.key] = ch.value
+	}
+	return nil
+}
+
+// changed returns the rows that differ from the stored ones
+func (s *cacheStore) changed(rows storeRows) []storeChange {
+	var changes []storeChange
+	for section, keys := range rows {
+		for k, v := range keys {
+			if s.saved[section][k] != v {
+				changes = append(changes, storeChange{section, k, v})
+			}
+		}
+	}
+	return changes
+}
+
+// apply writes the changes sorted so that the store is updated the same way every time
+func (s *cacheStore) apply(changes []storeChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].section != changes[j].section {
+			return changes[i].section < changes[j].section
+		}
+		return changes[i].key < changes[j].key
+	})
+	if err := s.write(changes); err != nil {
+		return fmt.Errorf("error writing store: %w", err)
+	}
+	slog.Debug("Store updated", "store", params.Store, "rows", len(changes))
+	return nil
+}
+
+// cacheRows splits the cache into the rows of its sections
+func cacheRows(c sdhasher.Cache) (storeRows, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	rows := storeRows{}
+	for section, value := range sections {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(value, &fields); err != nil || fields == nil {
+			rows[section] = map[string]string{"": string(value)}
+			continue
+		}
+		rows[section] = make(map[string]string, len(fields))
+		for k, v := range fields {
+			rows[section][k] = string(v)
+		}
+	}
+	return rows, nil
+}
+
+// loadStore opens --store and returns its cache, the input cache is imported into the empty store
+func loadStore(newCache bool) sdhasher.Cache {
+	var err error
+	if db, err = openStore(params.Store); err != nil {
+		fatal("Error opening store", "store", params.Store, "error", err)
+	}
+	if db.empty() && params.Input != "" && !newCache {
+		result, err := readCache(params.Input)
+		if err != nil {
+			fatal("Error reading cache", "path", params.Input, "error", err)
+		}
+		slog.Info("Importing cache into the store", "path", params.Input, "store", params.Store,
+			"entries", len(result.Hashes))
+		return result
+	}
+	result, err := db.cache()
+	if err != nil {
+		fatal("Error reading store", "store", params.Store, "error", err)
+	}
+	return result
+}
+
+// saveStore writes the changes of the cache to --store if it's open
+func saveStore(result sdhasher.Cache) error {
+	if db == nil {
+		return nil
+	}
+	return db.save(result)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// memStore keeps the rows in memory and records the writes
+type memStore struct {
+	rows   storeRows
+	writes [][]storeChange
+}
+
+func (m *memStore) load() (storeRows, error) {
+	return m.rows, nil
+}
+
+func (m *memStore) write(changes []storeChange) error {
+	m.writes = append(m.writes, changes)
+	for _, c := range changes {
+		if c.value == "" {
+			delete(m.rows[c.section], c.key)
+			continue
+		}
+		if m.rows[c.section] == nil {
+			m.rows[c.section] = map[string]string{}
+		}
+		m.rows[c.section][c.key] = c.value
+	}
+	return nil
+}
+
+func (m *memStore) close() error {
+	return nil
+}
+
+const storeTestCache = `{
+	"hashes": {
+		"checkpoint/a.safetensors": {"mtime": 1, "sha256": "aaaa", "size": 10, "webui_field": [1, 2]},
+		"lora/b": {"mtime": 2, "sha256": "bbbb"},
+		"lora/it's": {"mtime": 3, "sha256": "cccc"}
+	},
+	"hashes-addnet": {"lora/b": {"mtime": 2, "sha256": "bbbb-addnet"}},
+	"safetensors-metadata": {"lora/b": {"mtime": 2, "value": {"ss_network_dim": "32"}}},
+	"sdhasher": {"version": "dev"},
+	"extension-counter": 5
+}`
+
+func storeTestCacheValue(t *testing.T) sdhasher.Cache {
+	t.Helper()
+	var c sdhasher.Cache
+	if err := json.Unmarshal([]byte(storeTestCache), &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// checkStoreCache compares the JSON of the cache read from the store
+func checkStoreCache(t *testing.T, s *cacheStore, want sdhasher.Cache) {
+	t.Helper()
+	got, err := s.cache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotJSON, _ := json.Marshal(got)
+	wantJSON, _ := json.Marshal(want)
+	if string(gotJSON) != string(wantJSON) {
+		t.Errorf("got cache %s, want %s", gotJSON, wantJSON)
+	}
+}
+
+func TestCacheStore(t *testing.T) {
+	c := storeTestCacheValue(t)
+	m := &memStore{rows: storeRows{}}
+	s := &cacheStore{store: m, saved: storeRows{}}
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	// 3 hashes, the addnet hash, the metadata, the version and the section that isn't an object
+	if len(m.writes) != 1 || len(m.writes[0]) != 7 {
+		t.Fatalf("got writes %v, want 7 rows at once", m.writes)
+	}
+	loaded := &cacheStore{store: m, saved: m.rows}
+	checkStoreCache(t, loaded, c)
+	// only the changed rows are written
+	updated := c.Clone()
+	updated.Remove("lora/b")
+	updated.Hashes["lora/it's"] = sdhasher.Entry{MTime: 4, SHA256: "dddd"}
+	if err := s.save(updated); err != nil {
+		t.Fatal(err)
+	}
+	want := []storeChange{
+		{section: "hashes", key: "lora/b"},
+		{"hashes", "lora/it's", `{"mtime":4.0000000,"sha256":"dddd"}`},
+		{section: "hashes-addnet", key: "lora/b"},
+		{section: "safetensors-metadata", key: "lora/b"},
+	}
+	if !reflect.DeepEqual(m.writes[1], want) {
+		t.Errorf("got changes %v, want %v", m.writes[1], want)
+	}
+	checkStoreCache(t, loaded, updated)
+	if err := s.save(updated); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.writes) != 2 {
+		t.Errorf("got writes %v for the unchanged cache", m.writes[2:])
+	}
+}
+
+func TestCacheStoreSaveHashed(t *testing.T) {
+	c := storeTestCacheValue(t)
+	m := &memStore{rows: storeRows{}}
+	s := &cacheStore{store: m, saved: storeRows{}}
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	hashed := []*sdhasher.Entry{{MTime: 5, SHA256: "eeee", Key: "checkpoint/a.safetensors", Addnet: "eeee-addnet"}}
+	if err := s.saveHashed(c, hashed); err != nil {
+		t.Fatal(err)
+	}
+	// the unknown fields of the replaced entry are kept and nothing else is written
+	want := []storeChange{
+		{"hashes", "checkpoint/a.safetensors", `{"mtime":5.0000000,"sha256":"eeee","webui_field":[1,2]}`},
+		{"hashes-addnet", "checkpoint/a.safetensors", `{"mtime":5.0000000,"sha256":"eeee-addnet"}`},
+	}
+	if len(m.writes) != 2 || !reflect.DeepEqual(m.writes[1], want) {
+		t.Fatalf("got writes %v, want %v", m.writes[1:], want)
+	}
+	// the final save of the same result writes nothing more
+	c.Apply(hashed)
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.writes) != 2 {
+		t.Errorf("got writes %v after the saved files", m.writes[2:])
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	value := "sqlite:" + filepath.Join(t.TempDir(), "cache.db")
+	s, err := openStore(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.empty() {
+		t.Fatal("the new store isn't empty")
+	}
+	c := storeTestCacheValue(t)
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	c.Remove("lora/b")
+	c.Hashes["lora/it's"] = sdhasher.Entry{MTime: 4, SHA256: "dddd"}
+	if err := s.save(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveHashed(c, []*sdhasher.Entry{{MTime: 5, SHA256: "ffff", Key: "vae/new.safetensors"}}); err != nil {
+		t.Fatal(err)
+	}
+	c.Hashes["vae/new.safetensors"] = sdhasher.Entry{MTime: 5, SHA256: "ffff"}
+	reopened, err := openStore(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStoreCache(t, reopened, c)
+}
+
+func TestOpenStoreUnknown(t *testing.T) {
+	for _, value := range []string{"cache.db", "sqlite:", "postgres:cache"} {
+		if _, err := openStore(value); err == nil {
+			t.Errorf("%s: no error", value)
+		}
+	}
+}
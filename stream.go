@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// streamRecord is the line written to --stream for every hashed file
+type streamRecord struct {
+	Key      string  `json:"key"`
+	Path     string  `json:"path"`
+	SHA256   string  `json:"sha256,omitempty"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// resultStream appends the results to --stream as they come so that they can be followed during the hashing
+type resultStream struct {
+	sync.Mutex
+	enc *json.Encoder
+}
+
+var stream resultStream
+
+func setupStream() {
+	switch params.Stream {
+	case "":
+		return
+	case "-":
+		stream.enc = json.NewEncoder(os.Stdout)
+		return
+	}
+	f, err := os.OpenFile(params.Stream, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fatal("Error opening result stream", "path", params.Stream, "error", err)
+	}
+	stream.enc = json.NewEncoder(f)
+}
+
+// write adds the result of the task, the files that couldn't be hashed have the error set
+func (s *resultStream) write(t *task, e *sdhasher.Entry, d time.Duration, err error) {
+	if s.enc == nil {
+		return
+	}
+	r := streamRecord{Key: t.key, Path: t.path, Size: t.size, Duration: d.Seconds()}
+	if err != nil {
+		r.Error = err.Error()
+	} else {
+		r.SHA256 = e.SHA256
+		r.Size = e.Size
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := s.enc.Encode(r); err != nil {
+		slog.Error("Error writing result stream", "path", params.Stream, "error", err)
+	}
+}
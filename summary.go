@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// runStats is the summary of a single scan
+type runStats struct {
+	Hashed            int       `json:"hashed"`
+	UpToDate          int       `json:"up_to_date"`
+	Pruned            int       `json:"pruned"`
+	Errors            int       `json:"errors"`
+	Retried           int       `json:"retried"`
+	BytesRead         int64     `json:"bytes_read"`
+	Duration          float64   `json:"duration"`
+	Throughput        float64   `json:"throughput"`
+	WorkerUtilization []float64 `json:"worker_utilization,omitempty"`
+
+	started     time.Time
+	hashingTime time.Duration
+	hashed      []*sdhasher.Entry
+	workerBusy  []time.Duration
+}
+
+var stats runStats
+
+const (
+	// exitPartial is the exit code when some files failed to hash
+	exitPartial = 2
+	// exitFailed is the exit code when no files could be hashed
+	exitFailed = 3
+)
+
+// exitCode returns the process exit code for the errors of the run
+func (s *runStats) exitCode() int {
+	switch {
+	case s.Errors == 0:
+		return 0
+	case s.Hashed == 0:
+		return exitFailed
+	default:
+		return exitPartial
+	}
+}
+
+// finish calculates the derived values
+func (s *runStats) finish() {
+	s.Duration = time.Since(s.started).Seconds()
+	metrics.scanFinished(s.Duration)
+	// the dashboard and the front-ends get the derived values too
+	defer func() {
+		dashboard.scanFinished(*s)
+		events.runFinished(*s)
+	}()
+	sdNotify(fmt.Sprintf("STATUS=Idle, the last scan hashed %d files with %d errors", s.Hashed, s.Errors))
+	s.WorkerUtilization = nil
+	if s.hashingTime <= 0 {
+		s.Throughput = 0
+		return
+	}
+	s.Throughput = float64(s.BytesRead) / s.hashingTime.Seconds()
+	for _, b := range s.workerBusy {
+		s.WorkerUtilization = append(s.WorkerUtilization, b.Seconds()/s.hashingTime.Seconds())
+	}
+}
+
+func (s *runStats) report() {
+	// in quiet mode it's the only line printed and only if anything changed, it stays at the info level so that it
+	// isn't taken for a warning
+	logger := slog.Default()
+	if params.Quiet {
+		logger = slog.New(unfilteredHandler{logger.Handler()})
+	}
+	if !params.Quiet || s.Hashed+s.Pruned+s.Errors > 0 {
+		logger.Info("Summary", "hashed", s.Hashed, "up_to_date", s.UpToDate, "removed", s.Pruned,
+			"errors", s.Errors, "retried", s.Retried, "bytes", s.BytesRead,
+			"duration", time.Duration(s.Duration*float64(time.Second)).Round(time.Millisecond),
+			"speed", formatBytes(int64(s.Throughput))+"/s")
+	}
+	if s.Hashed+s.Errors > 0 && len(s.WorkerUtilization) > 0 {
+		var utilization []string
+		for _, u := range s.WorkerUtilization {
+			utilization = append(utilization, fmt.Sprintf("%.0f%%", u*100))
+		}
+		slog.Info("Worker utilization", "workers", strings.Join(utilization, ", "))
+	}
+	if params.SummaryJSON == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "    ")
+	if err != nil {
+		slog.Error("Error encoding summary", "error", err)
+		return
+	}
+	data = append(data, '\n')
+	if params.SummaryJSON == "-" {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = os.WriteFile(params.SummaryJSON, data, 0644)
+	}
+	if err != nil {
+		slog.Error("Error writing summary", "path", params.SummaryJSON, "error", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	savedParams, savedRoots, savedStats := params, roots, stats
+	t.Cleanup(func() {
+		params, roots, stats = savedParams, savedRoots, savedStats
+		bufferPool = sync.Pool{}
+	})
+	dir := t.TempDir()
+	roots = []root{{path: dir, prefix: "checkpoint/", fsys: localStorage{dir: dir}}}
+	params.MaxHashers, params.BufferSize = 1, 4096
+	setupBuffers()
+	tests := []struct {
+		name       string
+		hashed     int
+		missing    int
+		maxErrors  int
+		wantErrors int
+		want       int
+	}{
+		{"success", 2, 0, 0, 0, 0},
+		{"nothing to hash", 0, 0, 0, 0, 0},
+		{"partial", 2, 1, 0, 1, exitPartial},
+		{"failed", 0, 2, 0, 2, exitFailed},
+		// the rest of the files aren't hashed after reaching the limit
+		{"max errors", 0, 5, 2, 2, exitFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats = runStats{}
+			var tasks []*task
+			for i := 0; i < tt.hashed+tt.missing; i++ {
+				path := filepath.Join(dir, tt.name+strconv.Itoa(i)+".safetensors")
+				if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+					t.Fatal(err)
+				}
+				fi, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// the missing files are removed after they were found
+				if i >= tt.hashed {
+					os.Remove(path)
+				}
+				tasks = append(tasks, newTask(path, "checkpoint/"+filepath.Base(path), fs.FileInfoToDirEntry(fi)))
+			}
+			params.MaxErrors = tt.maxErrors
+			stats.Hashed = len(hashTasks(context.Background(), tasks, nil))
+			if stats.Errors != tt.wantErrors {
+				t.Errorf("got %d errors, want %d", stats.Errors, tt.wantErrors)
+			}
+			if got := stats.exitCode(); got != tt.want {
+				t.Errorf("got exit code %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sdNotify sends the state such as READY=1 to systemd when running as a Type=notify service, it does nothing otherwise
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	// the abstract socket names start with @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		slog.Debug("Error connecting to systemd", "socket", socket, "error", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Debug("Error notifying systemd", "state", state, "error", err)
+	}
+}
+
+// startWatchdog pings the systemd watchdog twice per its WatchdogSec interval
+func startWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	go func() {
+		for range time.Tick(time.Duration(usec) * time.Microsecond / 2) {
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
+
+// journalPriorities are the syslog priorities of the levels understood by journald as the <N> line prefixes
+var journalPriorities = map[string]string{
+	"DEBUG": "<7>",
+	"INFO":  "<6>",
+	"WARN":  "<4>",
+	"ERROR": "<3>",
+}
+
+// journalWriter turns the text handler lines without the time into the journald lines, the level becomes the
+// priority prefix and the message goes first unquoted
+type journalWriter struct {
+	io.Writer
+}
+
+func (w journalWriter) Write(p []byte) (int, error) {
+	level, text, ok := splitLogLine(p)
+	if !ok {
+		return w.Writer.Write(p)
+	}
+	if _, err := w.Writer.Write(append([]byte(journalPriorities[level]), text...)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
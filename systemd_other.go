@@ -0,0 +1,7 @@
+//go:build !linux
+
+package main
+
+func underJournal() bool {
+	return false
+}
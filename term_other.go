@@ -0,0 +1,16 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd || windows)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func rawTerminal() (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
+
+func colorTerminal(f *os.File) bool {
+	return false
+}
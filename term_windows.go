@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// rawTerminal switches the console to pass the keys without Enter and echo and to understand the escape sequences,
+// the returned function restores it
+func rawTerminal() (func(), error) {
+	in, out := windows.Handle(os.Stdin.Fd()), windows.Handle(os.Stdout.Fd())
+	var inMode, outMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(out, &outMode); err != nil {
+		return nil, err
+	}
+	if err := windows.SetConsoleMode(in, inMode&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT)|
+		windows.ENABLE_VIRTUAL_TERMINAL_INPUT); err != nil {
+		return nil, err
+	}
+	windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING)
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		windows.SetConsoleMode(out, outMode)
+	}, nil
+}
+
+// colorTerminal reports if the file is a console and enables the escape sequences in it
+func colorTerminal(f *os.File) bool {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	return windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the total read rate of all hashers
+type rateLimiter struct {
+	sync.Mutex
+	rate float64
+	next time.Time
+}
+
+var readLimiter *rateLimiter
+
+func setupReadLimiter() {
+	if params.MaxReadRate > 0 {
+		readLimiter = &rateLimiter{rate: float64(params.MaxReadRate)}
+	}
+}
+
+// wait accounts for n bytes read and sleeps until the reader is allowed to continue
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n == 0 {
+		return
+	}
+	l.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.Unlock()
+	time.Sleep(delay)
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errSkipped is returned for the files skipped in the TUI, they aren't retried
+var errSkipped = errors.New("skipped by user")
+
+const (
+	tuiSamples   = 60
+	tuiQueueRows = 5
+	tuiErrorRows = 8
+	tuiPathWidth = 60
+)
+
+// tuiWorker is the file being hashed by a hasher
+type tuiWorker struct {
+	path    string
+	size    int64
+	started time.Time
+	read    atomic.Int64
+	skip    atomic.Bool
+}
+
+// tuiState is the live view of the hashing shown with --tui
+type tuiState struct {
+	mu       sync.Mutex
+	resumed  *sync.Cond
+	workers  []*tuiWorker
+	tasks    []*task
+	next     int
+	speeds   []float64
+	errors   []string
+	selected int
+	paused   bool
+	abort    func()
+	// logger and the log package output are restored after the TUI, setting a handler redirects the log package
+	logger    *slog.Logger
+	logOutput io.Writer
+	logFlags  int
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// ui is set while the TUI is shown, activeUI is the same for the key reader
+var (
+	ui       *tuiState
+	activeUI atomic.Pointer[tuiState]
+)
+
+// tuiAbort cancels the run saving the results, it's set by main
+var tuiAbort func()
+
+var tuiKeys sync.Once
+
+// startTUI replaces the log output with the live view of the tasks hashed by the hashers, the hashing is resumed when
+// the context is cancelled
+func startTUI(ctx context.Context, tasks []*task, hashers int) {
+	if !params.TUI || len(tasks) == 0 {
+		return
+	}
+	restore, err := rawTerminal()
+	if err != nil {
+		slog.Warn("Error switching the terminal to raw mode, the keys need Enter", "error", err)
+		restore = func() {}
+	}
+	u := &tuiState{workers: make([]*tuiWorker, hashers), tasks: tasks, abort: tuiAbort, logger: slog.Default(),
+		logOutput: log.Writer(), logFlags: log.Flags(), quit: make(chan struct{}), done: make(chan struct{})}
+	u.resumed = sync.NewCond(&u.mu)
+	ui = u
+	activeUI.Store(u)
+	slog.SetDefault(slog.New(tuiHandler{u}))
+	fmt.Fprint(os.Stdout, "\x1b[?1049h\x1b[?25l")
+	tuiKeys.Do(func() { go readKeys() })
+	go func() {
+		defer close(u.done)
+		defer restore()
+		ticker := time.NewTicker(time.Second / 2)
+		defer ticker.Stop()
+		last, lastTime := progress.doneBytes.Load(), time.Now()
+		cancelled := ctx.Done()
+		for {
+			select {
+			case <-ticker.C:
+			case <-cancelled:
+				u.resume()
+				cancelled = nil
+			case <-u.quit:
+				return
+			}
+			if time.Since(lastTime) >= time.Second {
+				done := progress.doneBytes.Load()
+				u.mu.Lock()
+				u.speeds = append(u.speeds, float64(done-last)/time.Since(lastTime).Seconds())
+				if len(u.speeds) > tuiSamples {
+					u.speeds = u.speeds[1:]
+				}
+				u.mu.Unlock()
+				last, lastTime = done, time.Now()
+			}
+			u.render(os.Stdout)
+		}
+	}()
+}
+
+// stop restores the terminal and the log output, the warnings shown in the TUI are logged again to keep them in the
+// scrollback
+func (u *tuiState) stop() {
+	if u == nil {
+		return
+	}
+	u.resume()
+	close(u.quit)
+	<-u.done
+	fmt.Fprint(os.Stdout, "\x1b[?25h\x1b[?1049l")
+	slog.SetDefault(u.logger)
+	log.SetOutput(u.logOutput)
+	log.SetFlags(u.logFlags)
+	ui = nil
+	activeUI.Store(nil)
+	for _, e := range u.errors {
+		fmt.Fprintln(os.Stderr, e)
+	}
+}
+
+func (u *tuiState) resume() {
+	u.mu.Lock()
+	u.paused = false
+	u.resumed.Broadcast()
+	u.mu.Unlock()
+}
+
+func (u *tuiState) dispatched() {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.next++
+	u.mu.Unlock()
+}
+
+func (u *tuiState) started(id int, t *task) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.workers[id] = &tuiWorker{path: t.path, size: t.size, started: time.Now()}
+	u.mu.Unlock()
+}
+
+func (u *tuiState) finished(id int) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	u.workers[id] = nil
+	u.mu.Unlock()
+}
+
+// check waits while the hashing is paused and returns errSkipped if the file of the worker was skipped
+func (u *tuiState) check(id int, n int) error {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	for u.paused {
+		u.resumed.Wait()
+	}
+	w := u.workers[id]
+	u.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	w.read.Add(int64(n))
+	if w.skip.Load() {
+		return errSkipped
+	}
+	return nil
+}
+
+// uiWriter passes the data to the digest checking the TUI state before every chunk
+type uiWriter struct {
+	io.Writer
+	id int
+}
+
+func (w uiWriter) Write(p []byte) (int, error) {
+	if err := ui.check(w.id, len(p)); err != nil {
+		return 0, err
+	}
+	return w.Writer.Write(p)
+}
+
+// readKeys handles the key presses for the current TUI, the reader stays for the next runs in watch mode
+func readKeys() {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		u := activeUI.Load()
+		if u == nil {
+			continue
+		}
+		switch b {
+		case 'p', ' ':
+			u.mu.Lock()
+			u.paused = !u.paused
+			u.resumed.Broadcast()
+			u.mu.Unlock()
+		case 's':
+			u.mu.Lock()
+			if w := u.workers[u.selected]; w != nil {
+				w.skip.Store(true)
+			}
+			u.mu.Unlock()
+		case 'j', 'k', 'A', 'B':
+			// the arrows come as ESC [ A and ESC [ B
+			u.mu.Lock()
+			if b == 'k' || b == 'A' {
+				u.selected = (u.selected + len(u.workers) - 1) % len(u.workers)
+			} else {
+				u.selected = (u.selected + 1) % len(u.workers)
+			}
+			u.mu.Unlock()
+		case 'q':
+			if u.abort != nil {
+				u.abort()
+			}
+			u.resume()
+		}
+	}
+}
+
+// sparkline draws the samples scaled to the biggest one
+func sparkline(samples []float64) (string, float64) {
+	bars := []rune("▁▂▃▄▅▆▇█")
+	var peak float64
+	for _, s := range samples {
+		peak = max(peak, s)
+	}
+	var sb strings.Builder
+	for _, s := range samples {
+		i := 0
+		if peak > 0 {
+			i = int(s / peak * float64(len(bars)-1))
+		}
+		sb.WriteRune(bars[i])
+	}
+	return sb.String(), peak
+}
+
+// shortPath shortens the path to the width keeping its end
+func shortPath(path string) string {
+	if r := []rune(path); len(r) > tuiPathWidth {
+		return "…" + string(r[len(r)-tuiPathWidth+1:])
+	}
+	return path
+}
+
+func (u *tuiState) render(out io.Writer) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var b bytes.Buffer
+	s := progress.status()
+	percent := float64(100)
+	if s.TotalBytes > 0 {
+		percent = float64(s.Bytes) * 100 / float64(s.TotalBytes)
+	}
+	speed := float64(s.Bytes) / progress.elapsed().Seconds()
+	eta := "unknown"
+	if speed > 0 {
+		eta = time.Duration(float64(s.TotalBytes-s.Bytes) / speed * float64(time.Second)).Round(time.Second).String()
+	}
+	state := ""
+	if u.paused {
+		state = "  PAUSED"
+	}
+	fmt.Fprintf(&b, "\x1b[H\x1b[2Jsdhasher  %d/%d files  %s/%s  %.1f%%  %s/s  ETA %s%s\r\n\r\n",
+		s.Files, s.TotalFiles, formatBytes(s.Bytes), formatBytes(s.TotalBytes), percent, formatBytes(int64(speed)), eta,
+		state)
+	line, peak := sparkline(u.speeds)
+	fmt.Fprintf(&b, "Throughput, last %d s, up to %s/s\r\n%s\r\n\r\n", len(u.speeds), formatBytes(int64(peak)), line)
+	b.WriteString("Workers\r\n")
+	for i, w := range u.workers {
+		cursor := " "
+		if i == u.selected {
+			cursor = ">"
+		}
+		if w == nil {
+			fmt.Fprintf(&b, "%s %2d  idle\r\n", cursor, i)
+			continue
+		}
+		read := ""
+		if n := w.read.Load(); n > 0 && w.size > 0 {
+			read = fmt.Sprintf("%3d%%  ", n*100/w.size)
+		}
+		fmt.Fprintf(&b, "%s %2d  %s%s  %s  %s\r\n", cursor, i, read, shortPath(w.path), formatBytes(w.size),
+			time.Since(w.started).Round(time.Second))
+	}
+	fmt.Fprintf(&b, "\r\nQueue, %d left\r\n", len(u.tasks)-u.next)
+	for _, t := range u.tasks[u.next:min(u.next+tuiQueueRows, len(u.tasks))] {
+		fmt.Fprintf(&b, "    %s  %s\r\n", shortPath(t.path), formatBytes(t.size))
+	}
+	b.WriteString("\r\nRecent errors\r\n")
+	for _, e := range u.errors[max(0, len(u.errors)-tuiErrorRows):] {
+		fmt.Fprintf(&b, "    %s\r\n", e)
+	}
+	b.WriteString("\r\np pause  s skip the selected file  j/k select  q abort and save\r\n")
+	out.Write(b.Bytes())
+}
+
+// tuiHandler keeps the warnings and errors for the TUI instead of printing them
+type tuiHandler struct {
+	u *tuiState
+}
+
+func (h tuiHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelWarn
+}
+
+func (h tuiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var buf bytes.Buffer
+	slog.NewTextHandler(&buf, nil).Handle(ctx, r)
+	h.u.mu.Lock()
+	h.u.errors = append(h.u.errors, strings.TrimSpace(buf.String()))
+	h.u.mu.Unlock()
+	return nil
+}
+
+func (h tuiHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h tuiHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// validateCache reports the malformed entries of the input cache and writes the repaired cache to the output if it's
+// given, it returns false if there were problems and they weren't written
+func validateCache() bool {
+	f, err := openCache(params.Input)
+	if err != nil {
+		fatal("Error reading cache", "path", params.Input, "error", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		fatal("Error reading cache", "path", params.Input, "error", err)
+	}
+	result, problems, err := sdhasher.Validate(data)
+	if err != nil {
+		fatal("Invalid cache", "path", params.Input, "error", err)
+	}
+	dropped := 0
+	for _, p := range problems {
+		if p.Fixed {
+			slog.Warn("Fixed cache entry", "section", p.Section, "key", p.Key, "problem", p.Message)
+		} else {
+			slog.Warn("Dropped cache entry", "section", p.Section, "key", p.Key, "problem", p.Message)
+			dropped++
+		}
+	}
+	slog.Info("Validated", "path", params.Input, "entries", len(result.Hashes), "fixed", len(problems)-dropped,
+		"dropped", dropped)
+	if len(problems) == 0 {
+		return true
+	}
+	if params.Output == "" || params.DryRun {
+		return false
+	}
+	stampVersion(&result)
+	if err := writeCache(result); err != nil {
+		fatal("Error writing cache", "error", err)
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+func matchAny(key string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// verify rehashes the cached files and reports the ones that are missing or don't match, returns false if any were
+// found
+func verify(ctx context.Context, result sdhasher.Cache, patterns []string) bool {
+	var tasks []*task
+	failed := 0
+	for k := range result.Hashes {
+		if !matchAny(k, patterns) {
+			continue
+		}
+		modelPath, fi, err := statKey(k)
+		if modelPath == "" {
+			continue
+		}
+		if err != nil {
+			slog.Error("Missing file", "key", k, "error", err)
+			events.fileFailed(k, modelPath, "", result.Hashes[k].SHA256, err)
+			failed++
+			continue
+		}
+		tasks = append(tasks, newTask(modelPath, k, fs.FileInfoToDirEntry(fi)))
+	}
+	hashed := hashTasks(ctx, tasks, nil)
+	failed += len(tasks) - len(hashed)
+	for _, e := range hashed {
+		if expected := result.Hashes[e.Key].SHA256; e.SHA256 != expected {
+			slog.Error("Hash mismatch", "key", e.Key, "path", e.Path, "expected", expected, "actual", e.SHA256)
+			events.fileFailed(e.Key, e.Path, e.SHA256, expected, nil)
+			failed++
+		}
+	}
+	slog.Info("Verified", "files", len(tasks), "failed", failed)
+	events.verifyFinished(verifyStats{Files: len(tasks), Failed: failed})
+	return failed == 0
+}
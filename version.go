@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// set at build time with -ldflags "-X main.version=1.2.3 -X main.commit=... -X main.date=...", the module version
+// and the VCS information recorded by the go command are used otherwise
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+// versionInfo is stored in the sdhasher section of the written caches
+type versionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+func currentVersion() versionInfo {
+	result := versionInfo{Version: version, Commit: commit, Date: date}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if result.Version == "" && info.Main.Version != "(devel)" {
+			result.Version = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch {
+			case s.Key == "vcs.revision" && result.Commit == "":
+				result.Commit = s.Value
+			case s.Key == "vcs.time" && result.Date == "":
+				result.Date = s.Value
+			}
+		}
+	}
+	if result.Version == "" {
+		result.Version = "dev"
+	}
+	return result
+}
+
+func printVersion(name string) {
+	v := currentVersion()
+	fmt.Printf("%s %s", name, v.Version)
+	if v.Commit != "" {
+		fmt.Printf(" (commit %s", v.Commit)
+		if v.Date != "" {
+			fmt.Printf(", built %s", v.Date)
+		}
+		fmt.Print(")")
+	}
+	fmt.Println()
+}
+
+// stampVersion records the version of sdhasher in the cache
+func stampVersion(c *sdhasher.Cache) {
+	if err := c.SetSection("sdhasher", currentVersion()); err != nil {
+		slog.Error("Error storing version", "error", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// watchDelay is how long the directory should stay quiet before rescanning so that files being copied are hashed once
+const watchDelay = time.Second * 5
+
+// rescanRequests are sent from the dashboard and the scheduler to rescan without waiting for the changes
+var rescanRequests = make(chan struct{}, 1)
+
+func watch(ctx context.Context, result *sdhasher.Cache, schedule *cronSchedule) {
+	runSchedule(ctx, schedule, func() { notify(rescanRequests) })
+	events := make(chan struct{}, 1)
+	for _, dir := range baseDirs {
+		if isRemote(dir) {
+			// the remote storages don't send notifications
+			pollEvents(events)
+			continue
+		}
+		if err := watchEvents(dir, events); err != nil {
+			fatal("Error watching directory", "path", dir, "error", err)
+		}
+	}
+	slog.Info("Watching for changes", "paths", baseDirs)
+	for {
+		select {
+		case <-events:
+			if !debounce(ctx, events) {
+				return
+			}
+		case <-rescanRequests:
+		case <-ctx.Done():
+			return
+		}
+		original := result.Clone()
+		changes := scan(ctx, result)
+		stats.report()
+		if changes == 0 {
+			continue
+		}
+		if err := writeCache(*result); err != nil {
+			slog.Error("Error writing cache", "error", err)
+		}
+		writeDelta(original, *result)
+		writeAudit(original, *result)
+		sendWebhook()
+		refreshWebui()
+		postScan(ctx, *result)
+	}
+}
+
+// debounce waits until there are no events for watchDelay, it returns false if the context was cancelled
+func debounce(ctx context.Context, events <-chan struct{}) bool {
+	timer := time.NewTimer(watchDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-events:
+			timer.Reset(watchDelay)
+		case <-timer.C:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// notify sends an event without blocking, a pending event is enough to trigger a rescan
+func notify(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// pollEvents sends an event every --watch-poll interval
+func pollEvents(events chan<- struct{}) {
+	go func() {
+		for range time.Tick(params.WatchPoll) {
+			notify(events)
+		}
+	}()
+}
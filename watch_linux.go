@@ -0,0 +1,56 @@
+//go:build linux
+
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+func watchEvents(path string, events chan<- struct{}) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	addWatches := func() {
+		filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				if _, err := unix.InotifyAddWatch(fd, p, inotifyMask); err != nil {
+					slog.Error("Error watching directory", "path", p, "error", err)
+				}
+			}
+			return nil
+		})
+	}
+	addWatches()
+	go func() {
+		defer unix.Close(fd)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := unix.Read(fd, buf)
+			if err != nil {
+				slog.Error("Error reading filesystem events", "error", err)
+				return
+			}
+			newDirs := false
+			for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+				ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				if ev.Mask&unix.IN_ISDIR != 0 && ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					newDirs = true
+				}
+				offset += unix.SizeofInotifyEvent + int(ev.Len)
+			}
+			if newDirs {
+				addWatches()
+			}
+			notify(events)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// watchEvents falls back to periodic rescans where inotify isn't available
+func watchEvents(path string, events chan<- struct{}) error {
+	pollEvents(events)
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// webdavFS is a models directory on a WebDAV server, webdav:// is accessed over HTTP and webdavs:// over HTTPS
+type webdavFS struct {
+	base *url.URL
+	user *url.Userinfo
+}
+
+func newWebDAVFS(p string) (remoteFS, error) {
+	u, err := url.Parse(p)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host in %s", p)
+	}
+	w := &webdavFS{base: u, user: u.User}
+	u.User = nil
+	u.Scheme = strings.Replace(u.Scheme, "webdav", "http", 1)
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	u.RawPath = ""
+	return w, nil
+}
+
+// request sends the request for the path relative to the base directory
+func (w *webdavFS) request(ctx context.Context, method, name string, header http.Header,
+	body io.Reader) (*http.Response, error) {
+	u := *w.base
+	u.Path += name
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.user != nil {
+		password, _ := w.user.Password()
+		req.SetBasicAuth(w.user.Username(), password)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", u.String(), fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("%s %s: %s", method, u.String(), resp.Status)
+}
+
+// webdavMultistatus is the PROPFIND response
+type webdavMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Propstat []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength int64  `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+const webdavPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+// list walks the directories one level at a time as many servers refuse the infinite depth
+func (w *webdavFS) list(ctx context.Context) ([]remoteFile, error) {
+	var result []remoteFile
+	dirs := []string{""}
+	seen := map[string]bool{"": true}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		header := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+		resp, err := w.request(ctx, "PROPFIND", dir, header, strings.NewReader(webdavPropfind))
+		if err != nil {
+			return nil, err
+		}
+		var ms webdavMultistatus
+		err = xml.NewDecoder(resp.Body).Decode(&ms)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error decoding the listing of %s: %w", w.base.String()+dir, err)
+		}
+		for _, r := range ms.Responses {
+			name, err := w.relative(r.Href)
+			if err != nil {
+				return nil, err
+			}
+			if name == strings.TrimSuffix(dir, "/") {
+				continue
+			}
+			for _, ps := range r.Propstat {
+				if !strings.Contains(ps.Status, " 200 ") {
+					continue
+				}
+				if ps.Prop.ResourceType.Collection != nil {
+					if !seen[name+"/"] {
+						seen[name+"/"] = true
+						dirs = append(dirs, name+"/")
+					}
+					break
+				}
+				result = append(result, remoteFile{name: name, size: ps.Prop.ContentLength,
+					mtime: parseWebDAVTime(ps.Prop.LastModified)})
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// relative returns the path of the href relative to the base directory, the servers return either the absolute paths
+// or the full URLs
+func (w *webdavFS) relative(href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid href %s: %w", href, err)
+	}
+	p := strings.TrimRight(u.Path, "/")
+	base := strings.TrimRight(w.base.Path, "/")
+	if p != base && !strings.HasPrefix(p, base+"/") {
+		return "", fmt.Errorf("href %s is outside of %s", href, w.base.Path)
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(p, base), "/"), nil
+}
+
+// parseWebDAVTime parses getlastmodified which is an HTTP date with the second precision, the zero time is returned
+// for the missing or invalid dates so that such files are always rehashed
+func parseWebDAVTime(s string) time.Time {
+	t, err := http.ParseTime(strings.TrimSpace(s))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+func (w *webdavFS) open(ctx context.Context, name string, offset int64) (io.ReadCloser, error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": {fmt.Sprintf("bytes=%d-", offset)}}
+	}
+	resp, err := w.request(ctx, http.MethodGet, path.Clean(name), header, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the servers without range support send the whole file
+	if offset > 0 && resp.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+	}
+	return resp.Body, nil
+}
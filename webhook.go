@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// notifyTimeout limits the notifications sent after the run, they use their own context so that they're sent after
+// the run is interrupted too
+const notifyTimeout = 30 * time.Second
+
+type hashedFile struct {
+	Key    string `json:"key"`
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type webhookPayload struct {
+	Summary *runStats    `json:"summary"`
+	Hashed  []hashedFile `json:"hashed"`
+}
+
+// sendWebhook posts the summary of the finished run and the new hashes to the notification URL
+func sendWebhook() {
+	if params.NotifyURL == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	payload := webhookPayload{Summary: &stats, Hashed: []hashedFile{}}
+	for _, e := range stats.hashed {
+		payload.Hashed = append(payload.Hashed, hashedFile{Key: e.Key, Path: e.Path, SHA256: e.SHA256, Size: e.Size})
+	}
+	if err := postJSON(ctx, params.NotifyURL, payload); err != nil {
+		slog.Error("Error sending notification", "url", params.NotifyURL, "error", err)
+	}
+}
+
+func postJSON(ctx context.Context, url string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/rkfg/sdhasher/pkg/sdhasher"
+)
+
+// webuiExtraDirs are the model directories of the webui outside of its models directory
+var webuiExtraDirs = map[string]string{
+	"embeddings": "textual_inversion/",
+	filepath.Join("extensions", "sd-webui-controlnet", "models"): "controlnet/",
+}
+
+// setupWebui finds the models directories and the cache file of the webui installation given with --webui, the cache
+// is updated in place
+func setupWebui() {
+	dir := params.Webui
+	if dir == "" {
+		return
+	}
+	if params.Cache != "" || params.Input != "" || params.Output != "" {
+		fatal("The webui directory can't be used together with the cache, input and output files")
+	}
+	models := filepath.Join(dir, "models")
+	if fi, err := os.Stat(models); err != nil || !fi.IsDir() {
+		fatal("The directory doesn't look like a webui installation, models directory not found", "path", dir)
+	}
+	dirs, err := os.ReadDir(models)
+	if err != nil {
+		fatal("Error reading models directory", "path", models, "error", err)
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if prefix, ok := sdhasher.LayoutPrefix(d.Name()); ok {
+			params.Paths = append(params.Paths, filepath.Join(models, d.Name())+"="+prefix)
+		}
+	}
+	// the embeddings and the models of the ControlNet extension are kept outside of the models directory
+	for _, d := range sortedKeys(webuiExtraDirs) {
+		if fi, err := os.Stat(filepath.Join(dir, d)); err == nil && fi.IsDir() {
+			params.Paths = append(params.Paths, filepath.Join(dir, d)+"="+webuiExtraDirs[d])
+		}
+	}
+	// the webui reads cache.json only to import it into the new cache directory
+	if cacheDir := webuiCacheDir(dir); cacheDir != "" {
+		fatal("The webui keeps its cache in the database directory sdhasher can't update, cache.json would be "+
+			"ignored, move the directory away to have cache.json imported on the next webui start", "path", cacheDir)
+	}
+	params.Cache = webuiCache(dir)
+	slog.Info("Found webui installation", "path", dir, "cache", params.Cache, "models", params.Paths)
+}
+
+// webuiCache returns the cache file the webui uses, it's cache.json in the data directory (cache_filename in
+// modules/cache.py, modules/hashes.py before it), SD_WEBUI_CACHE_FILE overrides it like in the webui itself
+func webuiCache(dir string) string {
+	if f := os.Getenv("SD_WEBUI_CACHE_FILE"); f != "" {
+		return f
+	}
+	return cacheLocation(dir)
+}
+
+// webuiCacheDir returns the cache directory of the webui 1.9 and newer if it exists, it's cache in the data directory
+// (cache_dir in modules/cache.py), SD_WEBUI_CACHE_DIR overrides it, SD.Next still uses cache.json
+func webuiCacheDir(dir string) string {
+	if params.UI == "sdnext" {
+		return ""
+	}
+	cacheDir := os.Getenv("SD_WEBUI_CACHE_DIR")
+	if cacheDir == "" {
+		cacheDir = filepath.Join(dir, "cache")
+	}
+	if fi, err := os.Stat(cacheDir); err != nil || !fi.IsDir() {
+		return ""
+	}
+	return cacheDir
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWebuiCache(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	dir := t.TempDir()
+	// the hashes directory of the webui holds no cache
+	if err := os.MkdirAll(filepath.Join(dir, "hashes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ui   string
+		env  string
+		want string
+	}{
+		{"automatic1111", "", "", filepath.Join(dir, "cache.json")},
+		{"sdnext", "sdnext", "", filepath.Join(dir, "data", "cache.json")},
+		{"override", "", "/srv/webui/cache.json", "/srv/webui/cache.json"},
+		{"sdnext override", "sdnext", "/srv/webui/cache.json", "/srv/webui/cache.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SD_WEBUI_CACHE_FILE", tt.env)
+			params.UI = tt.ui
+			if got := webuiCache(dir); got != tt.want {
+				t.Errorf("webuiCache() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebuiCacheDir(t *testing.T) {
+	savedParams := params
+	t.Cleanup(func() { params = savedParams })
+	old, current, other := t.TempDir(), t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(current, "cache", "hashes"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		dir  string
+		ui   string
+		env  string
+		want string
+	}{
+		{"before 1.9", old, "", "", ""},
+		{"1.9", current, "", "", filepath.Join(current, "cache")},
+		{"sdnext", current, "sdnext", "", ""},
+		{"override", old, "", other, other},
+		{"missing override", current, "", filepath.Join(other, "missing"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SD_WEBUI_CACHE_DIR", tt.env)
+			params.UI = tt.ui
+			if got := webuiCacheDir(tt.dir); got != tt.want {
+				t.Errorf("webuiCacheDir() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}